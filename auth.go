@@ -0,0 +1,127 @@
+package comfyUIclient
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// ComfyUserHeader is the header ComfyUI uses to select a user in multi-user mode
+const ComfyUserHeader = "Comfy-User"
+
+// ErrRefreshNotSupported is returned by authenticators whose credentials can't be refreshed
+var ErrRefreshNotSupported = errors.New("authenticator does not support refresh")
+
+// Authenticator adds credentials to every http request and to the websocket handshake
+type Authenticator interface {
+	// Authenticate sets credentials on header
+	Authenticate(header http.Header) error
+	// Refresh is called once after the server answered 401, the request is retried when it returns nil
+	Refresh() error
+}
+
+type headerAuth struct {
+	header http.Header
+}
+
+// NewHeaderAuth returns an Authenticator that sets static headers, e.g. cookies or Comfy-User
+func NewHeaderAuth(header http.Header) Authenticator {
+	return &headerAuth{header: header.Clone()}
+}
+
+func (h *headerAuth) Authenticate(header http.Header) error {
+	for key, values := range h.header {
+		header.Del(key)
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+	return nil
+}
+
+func (h *headerAuth) Refresh() error {
+	return ErrRefreshNotSupported
+}
+
+// NewBearerAuth returns an Authenticator that sends a static bearer token
+func NewBearerAuth(token string) Authenticator {
+	return NewHeaderAuth(http.Header{"Authorization": {"Bearer " + token}})
+}
+
+// NewBasicAuth returns an Authenticator that sends http basic auth credentials
+func NewBasicAuth(username, password string) Authenticator {
+	credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return NewHeaderAuth(http.Header{"Authorization": {"Basic " + credentials}})
+}
+
+// NewComfyUserAuth returns an Authenticator that selects user in ComfyUI multi-user mode
+func NewComfyUserAuth(user string) Authenticator {
+	return NewHeaderAuth(http.Header{ComfyUserHeader: {user}})
+}
+
+type tokenSourceAuth struct {
+	mu     sync.Mutex
+	token  string
+	source func() (string, error)
+}
+
+// NewTokenSourceAuth returns an Authenticator that sends a bearer token fetched from source
+// source is called on first use and again on every Refresh
+func NewTokenSourceAuth(source func() (string, error)) Authenticator {
+	return &tokenSourceAuth{source: source}
+}
+
+func (t *tokenSourceAuth) Authenticate(header http.Header) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token == "" {
+		token, err := t.source()
+		if err != nil {
+			return err
+		}
+		t.token = token
+	}
+	header.Set("Authorization", "Bearer "+t.token)
+	return nil
+}
+
+func (t *tokenSourceAuth) Refresh() error {
+	token, err := t.source()
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.token = token
+	t.mu.Unlock()
+	return nil
+}
+
+type chainAuth []Authenticator
+
+// ChainAuth combines authenticators, they are applied in order
+// Refresh succeeds if any of them can be refreshed
+func ChainAuth(authenticators ...Authenticator) Authenticator {
+	return chainAuth(authenticators)
+}
+
+func (c chainAuth) Authenticate(header http.Header) error {
+	for _, a := range c {
+		if err := a.Authenticate(header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c chainAuth) Refresh() error {
+	err := ErrRefreshNotSupported
+	for _, a := range c {
+		if refreshErr := a.Refresh(); refreshErr == nil {
+			err = nil
+		} else if !errors.Is(refreshErr, ErrRefreshNotSupported) {
+			return refreshErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,270 @@
+package comfyUIclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BatchOptions controls SubmitBatch
+type BatchOptions struct {
+	// Concurrency is the number of prompts queued and not yet completed at a time, 1 when not positive
+	Concurrency int
+	// ContinueOnError keeps submitting after a prompt failed, otherwise no new prompt is queued after the first failure
+	ContinueOnError bool
+	// PromptTimeout limits how long a prompt may take from queueing to completion, zero means no limit
+	PromptTimeout time.Duration
+	// QueueOptions is applied to every prompt, its PromptID is ignored
+	QueueOptions *QueueOptions
+}
+
+// BatchResult is the result of one prompt of a batch
+type BatchResult struct {
+	// Index is the position of the prompt in the submitted slice
+	Index    int
+	PromptID string
+	// Completed is nil when the prompt couldn't be queued or didn't complete
+	Completed *WSMessageDataCompleted
+	Err       error
+}
+
+// BatchSummary sums up a finished batch
+type BatchSummary struct {
+	Total     int
+	Succeeded int
+	Failed    int
+	// Skipped prompts were never queued because the batch stopped early
+	Skipped int
+	// CachedPrompts completed without executing any node, CachedNodes counts cached nodes of all prompts
+	CachedPrompts int
+	CachedNodes   int
+	// GPUTime is the sum of the execution times of all prompts
+	GPUTime time.Duration
+	Elapsed time.Duration
+}
+
+// Batch is a running SubmitBatch
+type Batch struct {
+	results chan *BatchResult
+	done    chan struct{}
+	summary BatchSummary
+	err     error
+}
+
+// Results returns the results in the order the prompts finish, the channel is closed when the batch is done
+// The channel is buffered for the whole batch, so it may be ignored when only the summary is needed
+func (b *Batch) Results() <-chan *BatchResult {
+	return b.results
+}
+
+// Wait waits for the batch and returns its summary
+// The error is the first failure when ContinueOnError is false, or the error of the context
+func (b *Batch) Wait() (*BatchSummary, error) {
+	<-b.done
+	summary := b.summary
+	return &summary, b.err
+}
+
+// SubmitBatch queues prompts with bounded concurrency and tracks each of them until it completed
+// The client must be listening, see ConnectAndListen, otherwise the batch fails without queueing anything
+// Prompts that time out or are abandoned when ctx is done are cancelled on the server, see CancelPrompt
+func (c *Client) SubmitBatch(ctx context.Context, prompts []Prompt, opts *BatchOptions) *Batch {
+	if opts == nil {
+		opts = &BatchOptions{}
+	}
+	b := &Batch{
+		results: make(chan *BatchResult, len(prompts)),
+		done:    make(chan struct{}),
+	}
+	if !c.IsInitialized() {
+		b.summary = BatchSummary{Total: len(prompts), Skipped: len(prompts)}
+		b.err = errors.New("client not initialized")
+		close(b.results)
+		close(b.done)
+		return b
+	}
+	go c.runBatch(ctx, prompts, opts, b)
+	return b
+}
+
+func (c *Client) runBatch(ctx context.Context, prompts []Prompt, opts *BatchOptions, b *Batch) {
+	started := time.Now()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	waiter := newCompletionWaiter()
+	remove := c.AddListener(waiter.handle)
+	defer remove()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		stopped  bool
+		firstErr error
+	)
+	summary := BatchSummary{Total: len(prompts)}
+	record := func(result *BatchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		if result.Err == nil {
+			summary.Succeeded++
+		} else {
+			summary.Failed++
+			if !opts.ContinueOnError {
+				stopped = true
+				if firstErr == nil {
+					firstErr = result.Err
+				}
+			}
+		}
+		if result.Completed != nil {
+			summary.CachedNodes += len(result.Completed.CachedNodes)
+			if len(result.Completed.ExecutedNodes) == 0 && result.Completed.Status == ExecutionSuccess {
+				summary.CachedPrompts++
+			}
+			summary.GPUTime += result.Completed.Duration()
+		}
+		b.results <- result
+	}
+
+	semaphore := make(chan struct{}, concurrency)
+	for index, prompt := range prompts {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+		}
+
+		// a failure may have happened while waiting for a free slot
+		mu.Lock()
+		stop := stopped || ctx.Err() != nil
+		if stop {
+			summary.Skipped = len(prompts) - index
+		}
+		mu.Unlock()
+		if stop {
+			break
+		}
+
+		wg.Add(1)
+		go func(index int, prompt Prompt) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			record(c.runBatchItem(ctx, index, prompt, opts, waiter))
+		}(index, prompt)
+	}
+	wg.Wait()
+
+	summary.Elapsed = time.Since(started)
+	b.summary = summary
+	b.err = firstErr
+	if b.err == nil && ctx.Err() != nil && summary.Skipped > 0 {
+		b.err = ctx.Err()
+	}
+	close(b.results)
+	close(b.done)
+}
+
+func (c *Client) runBatchItem(ctx context.Context, index int, prompt Prompt, opts *BatchOptions, waiter *completionWaiter) *BatchResult {
+	queueOptions := QueueOptions{}
+	if opts.QueueOptions != nil {
+		queueOptions = *opts.QueueOptions
+	}
+	queueOptions.PromptID = NewPromptID()
+	result := &BatchResult{Index: index, PromptID: queueOptions.PromptID}
+
+	if opts.PromptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.PromptTimeout)
+		defer cancel()
+	}
+
+	// register before queueing, the prompt may complete before QueuePrompt returns
+	completed := waiter.wait(queueOptions.PromptID)
+	defer waiter.forget(queueOptions.PromptID)
+
+	resp, err := c.QueuePromptByNodesWithOptions(ctx, prompt, &queueOptions)
+	if err != nil {
+		result.Err = fmt.Errorf("c.QueuePromptByNodesWithOptions: error: %w", err)
+		// the request may have been cancelled after the server queued the prompt
+		if ctx.Err() != nil {
+			c.cancelAbandoned(result.PromptID)
+		}
+		return result
+	}
+	if len(resp.NodeErrors) != 0 || resp.PromptID == "" {
+		result.Err = fmt.Errorf("prompt rejected, node errors: %v", resp.NodeErrors)
+		return result
+	}
+
+	select {
+	case data := <-completed:
+		result.Completed = data
+		switch data.Status {
+		case ExecutionError:
+			result.Err = errors.New("execution error")
+			if data.Error != nil {
+				result.Err = fmt.Errorf("execution error in node %s (%s): %s", data.Error.Node, data.Error.NodeType, data.Error.ExceptionMessage)
+			}
+		case ExecutionInterrupted:
+			result.Err = errors.New("execution interrupted")
+		}
+	case <-ctx.Done():
+		result.Err = ctx.Err()
+		c.cancelAbandoned(result.PromptID)
+	}
+	return result
+}
+
+// cancelAbandoned cancels a prompt nobody waits for anymore, so that it doesn't keep using the server
+func (c *Client) cancelAbandoned(promptID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.CancelPrompt(ctx, promptID); err != nil {
+		c.logger.Warn("cancel abandoned prompt failed", "prompt_id", promptID, "error", err)
+	}
+}
+
+// completionWaiter hands Completed messages to the goroutines waiting for them
+type completionWaiter struct {
+	mu      sync.Mutex
+	waiting map[string]chan *WSMessageDataCompleted
+}
+
+func newCompletionWaiter() *completionWaiter {
+	return &completionWaiter{waiting: make(map[string]chan *WSMessageDataCompleted)}
+}
+
+func (w *completionWaiter) wait(promptID string) <-chan *WSMessageDataCompleted {
+	ch := make(chan *WSMessageDataCompleted, 1)
+	w.mu.Lock()
+	w.waiting[promptID] = ch
+	w.mu.Unlock()
+	return ch
+}
+
+func (w *completionWaiter) forget(promptID string) {
+	w.mu.Lock()
+	delete(w.waiting, promptID)
+	w.mu.Unlock()
+}
+
+func (w *completionWaiter) handle(message *WSMessage) {
+	data, ok := message.Data.(*WSMessageDataCompleted)
+	if !ok {
+		return
+	}
+	w.mu.Lock()
+	ch, ok := w.waiting[data.PromptID]
+	delete(w.waiting, data.PromptID)
+	w.mu.Unlock()
+	if ok {
+		ch <- data
+	}
+}
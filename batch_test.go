@@ -0,0 +1,88 @@
+package comfyUIclient_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/XdpCs/comfyUIclient/comfytest"
+)
+
+func TestSubmitBatch(t *testing.T) {
+	server, client := newTestServer(t)
+	server.Script(nil, &comfytest.Behavior{Cached: []string{"1", "2"}}, nil)
+
+	prompts := []comfyUIclient.Prompt{testPrompt(), testPrompt(), testPrompt()}
+	batch := client.SubmitBatch(context.Background(), prompts, &comfyUIclient.BatchOptions{Concurrency: 2})
+	summary, err := batch.Wait()
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if summary.Total != 3 || summary.Succeeded != 3 || summary.CachedPrompts != 1 {
+		t.Fatalf("summary = %+v", summary)
+	}
+	results := 0
+	for result := range batch.Results() {
+		results++
+		if result.Completed == nil || len(result.Completed.Outputs["2"]["images"]) != 1 {
+			t.Fatalf("result %d has no outputs: %+v", result.Index, result.Completed)
+		}
+	}
+	if results != 3 {
+		t.Fatalf("got %d results, want 3", results)
+	}
+}
+
+func TestSubmitBatchCancelsTimedOutPrompts(t *testing.T) {
+	server, client := newTestServer(t)
+	server.SetDefaultBehavior(&comfytest.Behavior{NodeDelay: 2 * time.Second})
+
+	prompts := []comfyUIclient.Prompt{testPrompt(), testPrompt()}
+	batch := client.SubmitBatch(context.Background(), prompts, &comfyUIclient.BatchOptions{
+		Concurrency:     2,
+		ContinueOnError: true,
+		PromptTimeout:   200 * time.Millisecond,
+	})
+	summary, err := batch.Wait()
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if summary.Failed != 2 {
+		t.Fatalf("summary = %+v, want 2 failed prompts", summary)
+	}
+	for result := range batch.Results() {
+		if !errors.Is(result.Err, context.DeadlineExceeded) {
+			t.Fatalf("result %d error = %v, want deadline exceeded", result.Index, result.Err)
+		}
+	}
+
+	// the running prompt is interrupted and the pending one deleted
+	waitFor(t, time.Second, "empty queue", func() bool {
+		queueInfo, err := client.GetQueueInfo()
+		return err == nil && len(queueInfo.QueueRunning) == 0 && len(queueInfo.QueuePending) == 0
+	})
+}
+
+func TestSubmitBatchFailsWithoutListening(t *testing.T) {
+	server := comfytest.NewServer()
+	defer server.Close()
+	client, err := comfyUIclient.New(server.URL)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	summary, err := client.SubmitBatch(context.Background(), []comfyUIclient.Prompt{testPrompt()}, nil).Wait()
+	if err == nil {
+		t.Fatal("Wait succeeded without ConnectAndListen")
+	}
+	if summary.Skipped != 1 {
+		t.Fatalf("summary = %+v, want 1 skipped prompt", summary)
+	}
+	for _, request := range server.Requests() {
+		if request.Path == "/prompt" {
+			t.Fatal("prompt was queued without ConnectAndListen")
+		}
+	}
+}
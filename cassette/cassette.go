@@ -0,0 +1,220 @@
+// Package cassette records the traffic of a comfyUIclient.Client to a file and replays it deterministically
+//
+// A cassette holds the http interactions and the frames received on every websocket connection, in order.
+// Record a session once against a real server with a Recorder, then replay it in CI with a Player:
+//
+//	recorder := cassette.NewRecorder()
+//	client, err := comfyUIclient.New(url, recorder.Options()...)
+//	...
+//	err = recorder.Save("testdata/txt2img.json")
+//
+//	c, err := cassette.Load("testdata/txt2img.json")
+//	player := cassette.NewPlayer(c)
+//	defer player.Close()
+//	client, err := comfyUIclient.New(url, player.Options()...)
+//
+// Prompt ids returned by the server are replayed as recorded, use fixed client and prompt ids
+// (WithClientID, QueueOptions.PromptID) when the code under test waits for the ids it generated.
+package cassette
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+// Version is the cassette format version written by Save
+const Version = 1
+
+// Redacted replaces the values of redacted headers, query parameters and body fields
+const Redacted = "REDACTED"
+
+// Cassette is a recorded session
+type Cassette struct {
+	Version      int                 `json:"version"`
+	Interactions []*Interaction      `json:"interactions"`
+	WebSockets   []*WebSocketSession `json:"websockets"`
+}
+
+// Interaction is an http request and its response, Error is set instead of Response when the transport failed
+type Interaction struct {
+	Request  *Request  `json:"request"`
+	Response *Response `json:"response,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Request is a recorded http request, URL is the path and query without scheme and host
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body
+}
+
+// Response is a recorded http response
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body
+}
+
+// Body holds text bodies as is and binary bodies, e.g. images, base64 encoded
+type Body struct {
+	Text   string `json:"body,omitempty"`
+	Base64 string `json:"body_base64,omitempty"`
+}
+
+func newBody(data []byte) Body {
+	if utf8.Valid(data) {
+		return Body{Text: string(data)}
+	}
+	return Body{Base64: base64.StdEncoding.EncodeToString(data)}
+}
+
+// Bytes returns the body
+func (b Body) Bytes() ([]byte, error) {
+	if b.Base64 != "" {
+		return base64.StdEncoding.DecodeString(b.Base64)
+	}
+	return []byte(b.Text), nil
+}
+
+// WebSocketSession is one websocket connection and the frames received on it
+type WebSocketSession struct {
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Frames []*Frame    `json:"frames"`
+}
+
+// Frame is a received websocket message
+// After is the number of http requests sent when it was received, a Player delivers it only after it answered as many
+type Frame struct {
+	After  int  `json:"after"`
+	Binary bool `json:"binary,omitempty"`
+	Body
+}
+
+// Load reads a cassette written by Save
+func Load(name string) (*Cassette, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: error: %w", err)
+	}
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: error: %w", err)
+	}
+	if c.Version != Version {
+		return nil, fmt.Errorf("unsupported cassette version %d", c.Version)
+	}
+	return &c, nil
+}
+
+// Save writes the cassette as indented json
+func (c *Cassette) Save(name string) error {
+	c.Version = Version
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent: error: %w", err)
+	}
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		return fmt.Errorf("os.WriteFile: error: %w", err)
+	}
+	return nil
+}
+
+// redactor removes credentials before they are written to a cassette
+type redactor struct {
+	headers map[string]bool
+	query   map[string]bool
+	fields  map[string]bool
+}
+
+func newRedactor() *redactor {
+	r := &redactor{headers: make(map[string]bool), query: make(map[string]bool), fields: make(map[string]bool)}
+	for _, name := range []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", comfyUIclient.ComfyUserHeader, "X-Api-Key"} {
+		r.headers[http.CanonicalHeaderKey(name)] = true
+	}
+	for _, name := range []string{"token", "api_key"} {
+		r.query[name] = true
+	}
+	for _, name := range []string{comfyUIclient.ExtraDataAPIKeyComfyOrg, comfyUIclient.ExtraDataAuthTokenComfyOrg} {
+		r.fields[name] = true
+	}
+	return r
+}
+
+func (r *redactor) header(header http.Header) http.Header {
+	if len(header) == 0 {
+		return nil
+	}
+	redacted := header.Clone()
+	for name, values := range redacted {
+		if r.headers[http.CanonicalHeaderKey(name)] {
+			for i := range values {
+				values[i] = Redacted
+			}
+		}
+	}
+	return redacted
+}
+
+// requestURI returns the path and query of rawURL with redacted query parameters
+func (r *redactor) requestURI(u *url.URL) string {
+	query := u.Query()
+	redacted := false
+	for name := range query {
+		if r.query[strings.ToLower(name)] {
+			query.Set(name, Redacted)
+			redacted = true
+		}
+	}
+	uri := *u
+	if redacted {
+		uri.RawQuery = query.Encode()
+	}
+	return uri.RequestURI()
+}
+
+// body redacts credential fields of json bodies, e.g. the comfy.org api key in extra_data
+func (r *redactor) body(data []byte) []byte {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return data
+	}
+	if !r.redactFields(value) {
+		return data
+	}
+	redacted, err := json.Marshal(value)
+	if err != nil {
+		return data
+	}
+	return redacted
+}
+
+func (r *redactor) redactFields(value interface{}) bool {
+	redacted := false
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if r.fields[key] {
+				v[key] = Redacted
+				redacted = true
+				continue
+			}
+			redacted = r.redactFields(field) || redacted
+		}
+	case []interface{}:
+		for _, item := range v {
+			redacted = r.redactFields(item) || redacted
+		}
+	}
+	return redacted
+}
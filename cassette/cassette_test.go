@@ -0,0 +1,280 @@
+package cassette_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/XdpCs/comfyUIclient/cassette"
+	"github.com/XdpCs/comfyUIclient/comfytest"
+)
+
+const (
+	clientID = "cassette-client"
+	promptID = "cassette-prompt"
+)
+
+func testPrompt() comfyUIclient.Prompt {
+	return comfyUIclient.Prompt{
+		"1": {ClassType: "EmptyLatentImage", Inputs: map[string]interface{}{"width": 64, "height": 64, "batch_size": 1}},
+		"2": {ClassType: "SaveImage", Inputs: map[string]interface{}{"images": []interface{}{"1", 0}, "filename_prefix": "cassette"}},
+	}
+}
+
+// session is what a client saw of a prompt, recording and replaying it must see the same
+type session struct {
+	messages []comfyUIclient.WsMessageType
+	history  *comfyUIclient.PromptHistoryItem
+	image    []byte
+}
+
+// run queues the test prompt with fixed ids and waits for it to complete
+func run(t *testing.T, client *comfyUIclient.Client) *session {
+	t.Helper()
+	messages := make(chan comfyUIclient.WsMessageType, 64)
+	completed := make(chan struct{})
+	remove := client.AddListener(func(message *comfyUIclient.WSMessage) {
+		// status messages aren't tied to the prompt, their timing differs between runs
+		if message.Type != comfyUIclient.Status {
+			messages <- message.Type
+		}
+		if message.Type == comfyUIclient.Completed {
+			close(completed)
+		}
+	})
+	defer remove()
+
+	_, err := client.QueuePromptByNodesWithOptions(context.Background(), testPrompt(), &comfyUIclient.QueueOptions{PromptID: promptID})
+	if err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	select {
+	case <-completed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the prompt to complete")
+	}
+
+	s := &session{}
+	for len(messages) > 0 {
+		s.messages = append(s.messages, <-messages)
+	}
+	if s.history, err = client.GetHistoryByPromptID(promptID); err != nil {
+		t.Fatalf("GetHistoryByPromptID: %v", err)
+	}
+	images := s.history.Outputs["2"].Images
+	if images == nil || len(*images) != 1 {
+		t.Fatalf("outputs = %+v, want one image", s.history.Outputs)
+	}
+	image, err := client.GetFile(&(*images)[0])
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	s.image = *image
+	return s
+}
+
+func record(t *testing.T) (*cassette.Cassette, *session) {
+	t.Helper()
+	server := comfytest.NewServer()
+	defer server.Close()
+	recorder := cassette.NewRecorder()
+	client, err := server.Client(append(recorder.Options(), comfyUIclient.WithClientID(clientID))...)
+	if err != nil {
+		t.Fatalf("server.Client: %v", err)
+	}
+	defer client.Close()
+	recorded := run(t, client)
+
+	name := filepath.Join(t.TempDir(), "cassette.json")
+	if err := recorder.Save(name); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	c, err := cassette.Load(name)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return c, recorded
+}
+
+func replayClient(t *testing.T, player *cassette.Player) *comfyUIclient.Client {
+	t.Helper()
+	opts := append(player.Options(),
+		comfyUIclient.WithClientID(clientID),
+		comfyUIclient.WithReconnectPolicy(comfyUIclient.ReconnectPolicy{MaxRetry: 3, Interval: 50 * time.Millisecond}),
+	)
+	// nothing listens on the address, every request is answered by the player
+	client, err := comfyUIclient.New("http://127.0.0.1:1", opts...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	client.ConnectAndListen()
+	deadline := time.Now().Add(5 * time.Second)
+	for !client.IsInitialized() {
+		if time.Now().After(deadline) {
+			t.Fatal("replayed client not initialized after 5s")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return client
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	c, recorded := record(t)
+	if len(c.Interactions) == 0 || len(c.WebSockets) != 1 || len(c.WebSockets[0].Frames) == 0 {
+		t.Fatalf("cassette has %d interactions and %d websockets, want both", len(c.Interactions), len(c.WebSockets))
+	}
+	if !strings.Contains(c.WebSockets[0].URL, "clientId="+clientID) {
+		t.Fatalf("websocket url = %q, want the client id", c.WebSockets[0].URL)
+	}
+
+	player := cassette.NewPlayer(c)
+	defer player.Close()
+	replayed := run(t, replayClient(t, player))
+
+	if !reflect.DeepEqual(replayed.messages, recorded.messages) {
+		t.Fatalf("replayed messages %v, recorded %v", replayed.messages, recorded.messages)
+	}
+	if !reflect.DeepEqual(replayed.history, recorded.history) {
+		t.Fatalf("replayed history %+v, recorded %+v", replayed.history, recorded.history)
+	}
+	// the png is binary and round trips base64 encoded
+	if !bytes.Equal(replayed.image, recorded.image) {
+		t.Fatalf("replayed image has %d bytes, recorded %d", len(replayed.image), len(recorded.image))
+	}
+}
+
+func TestRecorderRedactsCredentials(t *testing.T) {
+	server := comfytest.NewServer()
+	defer server.Close()
+	recorder := cassette.NewRecorder()
+	client, err := server.Client(append(recorder.Options(), comfyUIclient.WithAuthenticator(comfyUIclient.NewBearerAuth("secret-token")))...)
+	if err != nil {
+		t.Fatalf("server.Client: %v", err)
+	}
+	defer client.Close()
+	_, err = client.QueuePromptByNodesWithOptions(context.Background(), testPrompt(), &comfyUIclient.QueueOptions{
+		ExtraData: map[string]interface{}{comfyUIclient.ExtraDataAPIKeyComfyOrg: "secret-key"},
+	})
+	if err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+
+	name := filepath.Join(t.TempDir(), "cassette.json")
+	if err := recorder.Save(name); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	c, err := cassette.Load(name)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for _, interaction := range c.Interactions {
+		if strings.Contains(interaction.Request.Header.Get("Authorization"), "secret") || strings.Contains(interaction.Request.Text, "secret") {
+			t.Fatalf("%s %s recorded a credential", interaction.Request.Method, interaction.Request.URL)
+		}
+	}
+	for _, session := range c.WebSockets {
+		if strings.Contains(session.Header.Get("Authorization"), "secret") {
+			t.Fatalf("websocket %s recorded a credential", session.URL)
+		}
+	}
+}
+
+func TestPlayerMismatch(t *testing.T) {
+	c := &cassette.Cassette{
+		Version: cassette.Version,
+		Interactions: []*cassette.Interaction{{
+			Request:  &cassette.Request{Method: http.MethodGet, URL: "/queue"},
+			Response: &cassette.Response{StatusCode: http.StatusOK, Body: cassette.Body{Text: `{"queue_running": [], "queue_pending": []}`}},
+		}},
+	}
+	player := cassette.NewPlayer(c)
+	defer player.Close()
+	client := &http.Client{Transport: player.Transport(nil)}
+
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		wantErr bool
+	}{
+		{name: "recorded", method: http.MethodGet, url: "http://comfyui/queue"},
+		{name: "repeated", method: http.MethodGet, url: "http://comfyui/queue"},
+		{name: "other method", method: http.MethodPost, url: "http://comfyui/queue", wantErr: true},
+		{name: "other path", method: http.MethodGet, url: "http://comfyui/history", wantErr: true},
+		{name: "other query", method: http.MethodGet, url: "http://comfyui/queue?max_items=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, nil)
+			if err != nil {
+				t.Fatalf("http.NewRequest: %v", err)
+			}
+			resp, err := client.Do(req)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "no recorded response") {
+					t.Fatalf("err = %v, want no recorded response", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want 200", resp.StatusCode)
+			}
+		})
+	}
+	if unused := player.Unused(); len(unused) != 0 {
+		t.Fatalf("%d interactions unused, want none", len(unused))
+	}
+
+	dialer := player.Dialer(nil)
+	if _, _, err := dialer.Dial("ws://comfyui/ws", nil); err == nil {
+		t.Fatal("Dial succeeded without a recorded connection")
+	}
+}
+
+func TestPlayerCloseUnblocksRead(t *testing.T) {
+	c := &cassette.Cassette{
+		Version:    cassette.Version,
+		WebSockets: []*cassette.WebSocketSession{{URL: "/ws", Frames: []*cassette.Frame{{After: 1, Body: cassette.Body{Text: "{}"}}}}},
+	}
+	player := cassette.NewPlayer(c)
+	conn, _, err := player.Dialer(nil).Dial("ws://comfyui/ws", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	// the frame waits for an interaction that never happens
+	read := make(chan error, 1)
+	go func() {
+		_, _, err := conn.ReadMessage()
+		read <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	player.Close()
+	select {
+	case err := <-read:
+		if !errors.Is(err, cassette.ErrPlayerClosed) || !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("ReadMessage error = %v, want ErrPlayerClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ReadMessage still blocked after Close")
+	}
+	if _, _, err := conn.ReadMessage(); !errors.Is(err, cassette.ErrPlayerClosed) {
+		t.Fatalf("ReadMessage after Close error = %v, want ErrPlayerClosed", err)
+	}
+	if _, _, err := player.Dialer(nil).Dial("ws://comfyui/ws", nil); !errors.Is(err, cassette.ErrPlayerClosed) {
+		t.Fatalf("Dial error = %v, want ErrPlayerClosed", err)
+	}
+}
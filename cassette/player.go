@@ -0,0 +1,206 @@
+package cassette
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/gorilla/websocket"
+)
+
+// ErrPlayerClosed is returned by replayed connections after they or the player were closed
+// It wraps net.ErrClosed so the client takes it for a connection it closed itself
+var ErrPlayerClosed = fmt.Errorf("cassette: player closed: %w", net.ErrClosed)
+
+// Player replays a cassette, it is safe for concurrent use
+//
+// A request is answered with the first unused interaction with the same method, path and query,
+// the request body is not compared. When a request is made more often than recorded, e.g. by polling,
+// the last matching response is repeated. The n-th websocket dial replays the n-th recorded connection,
+// each frame once as many requests were answered as had been sent when it was recorded.
+// Connections followed by another recorded connection are closed after their last frame, the last one stays open.
+type Player struct {
+	mu       sync.Mutex
+	changed  *sync.Cond
+	cassette *Cassette
+	redactor *redactor
+	used     []bool
+	served   int
+	dialed   int
+	closed   bool
+}
+
+// NewPlayer returns a player for c
+func NewPlayer(c *Cassette) *Player {
+	p := &Player{cassette: c, redactor: newRedactor(), used: make([]bool, len(c.Interactions))}
+	p.changed = sync.NewCond(&p.mu)
+	return p
+}
+
+// Options returns the client options that replay the cassette instead of connecting to a server
+func (p *Player) Options() []comfyUIclient.Option {
+	return []comfyUIclient.Option{
+		comfyUIclient.WithHTTPTransport(p.Transport),
+		comfyUIclient.WithWebSocketTransport(p.Dialer),
+	}
+}
+
+// Transport returns a transport answering requests from the cassette, next is never used
+func (p *Player) Transport(next http.RoundTripper) http.RoundTripper {
+	return playerTransport{player: p}
+}
+
+// Dialer returns a dialer replaying the websocket connections of the cassette, next is never used
+func (p *Player) Dialer(next comfyUIclient.WebSocketDialer) comfyUIclient.WebSocketDialer {
+	return playerDialer{player: p}
+}
+
+// Close unblocks and closes the replayed websocket connections
+func (p *Player) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.changed.Broadcast()
+}
+
+// Unused returns the interactions that were not replayed
+func (p *Player) Unused() []*Interaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var unused []*Interaction
+	for i, used := range p.used {
+		if !used {
+			unused = append(unused, p.cassette.Interactions[i])
+		}
+	}
+	return unused
+}
+
+// match returns the interaction answering method and uri, p.mu must be held
+func (p *Player) match(method, uri string) *Interaction {
+	last := -1
+	for i, interaction := range p.cassette.Interactions {
+		if interaction.Request.Method != method || interaction.Request.URL != uri {
+			continue
+		}
+		if !p.used[i] {
+			p.used[i] = true
+			p.served++
+			p.changed.Broadcast()
+			return interaction
+		}
+		last = i
+	}
+	if last < 0 {
+		return nil
+	}
+	return p.cassette.Interactions[last]
+}
+
+type playerTransport struct {
+	player *Player
+}
+
+func (t playerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_, _ = io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+
+	p := t.player
+	p.mu.Lock()
+	interaction := p.match(req.Method, p.redactor.requestURI(req.URL))
+	p.mu.Unlock()
+	if interaction == nil {
+		return nil, fmt.Errorf("cassette: no recorded response for %s %s", req.Method, req.URL.RequestURI())
+	}
+	if interaction.Response == nil {
+		return nil, errors.New(interaction.Error)
+	}
+
+	body, err := interaction.Response.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("cassette: decode body: %w", err)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Response.StatusCode, http.StatusText(interaction.Response.StatusCode)),
+		StatusCode:    interaction.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        interaction.Response.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+type playerDialer struct {
+	player *Player
+}
+
+func (d playerDialer) Dial(url string, header http.Header) (comfyUIclient.WebSocketConn, *http.Response, error) {
+	p := d.player
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, nil, ErrPlayerClosed
+	}
+	if p.dialed >= len(p.cassette.WebSockets) {
+		return nil, nil, errors.New("cassette: no recorded websocket connection left")
+	}
+	index := p.dialed
+	p.dialed++
+	return &playerConn{player: p, session: p.cassette.WebSockets[index], last: index == len(p.cassette.WebSockets)-1}, nil, nil
+}
+
+type playerConn struct {
+	player  *Player
+	session *WebSocketSession
+	last    bool
+	next    int
+	closed  bool
+}
+
+func (c *playerConn) ReadMessage() (int, []byte, error) {
+	p := c.player
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c.closed || p.closed {
+		return 0, nil, ErrPlayerClosed
+	}
+	if c.next >= len(c.session.Frames) && !c.last {
+		return 0, nil, &websocket.CloseError{Code: websocket.CloseNormalClosure, Text: "end of recorded connection"}
+	}
+	for !c.closed && !p.closed && (c.next >= len(c.session.Frames) || p.served < c.session.Frames[c.next].After) {
+		p.changed.Wait()
+	}
+	if c.closed || p.closed {
+		return 0, nil, ErrPlayerClosed
+	}
+
+	frame := c.session.Frames[c.next]
+	c.next++
+	data, err := frame.Bytes()
+	if err != nil {
+		return 0, nil, fmt.Errorf("cassette: decode frame: %w", err)
+	}
+	if frame.Binary {
+		return websocket.BinaryMessage, data, nil
+	}
+	return websocket.TextMessage, data, nil
+}
+
+func (c *playerConn) Close() error {
+	p := c.player
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c.closed = true
+	p.changed.Broadcast()
+	return nil
+}
@@ -0,0 +1,179 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/gorilla/websocket"
+)
+
+// Recorder records the traffic of a client, it is safe for concurrent use
+type Recorder struct {
+	mu       sync.Mutex
+	cassette Cassette
+	redactor *redactor
+	// sent counts the requests sent, frames received during a request are replayed once it was sent
+	sent int
+}
+
+// NewRecorder returns a recorder that redacts credential headers, query parameters and comfy.org keys in extra_data
+func NewRecorder() *Recorder {
+	return &Recorder{cassette: Cassette{Version: Version}, redactor: newRedactor()}
+}
+
+// RedactHeader adds headers whose values are not recorded
+func (r *Recorder) RedactHeader(names ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, name := range names {
+		r.redactor.headers[http.CanonicalHeaderKey(name)] = true
+	}
+}
+
+// Options returns the client options that record http requests and websocket frames
+func (r *Recorder) Options() []comfyUIclient.Option {
+	return []comfyUIclient.Option{
+		comfyUIclient.WithHTTPTransport(r.Transport),
+		comfyUIclient.WithWebSocketTransport(r.Dialer),
+	}
+}
+
+// Transport returns a transport recording the requests sent through next
+func (r *Recorder) Transport(next http.RoundTripper) http.RoundTripper {
+	return &recordingTransport{recorder: r, next: next}
+}
+
+// Dialer returns a dialer recording the frames received on the connections of next
+func (r *Recorder) Dialer(next comfyUIclient.WebSocketDialer) comfyUIclient.WebSocketDialer {
+	return &recordingDialer{recorder: r, next: next}
+}
+
+// Cassette returns a copy of what was recorded so far
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := &Cassette{
+		Version:      r.cassette.Version,
+		Interactions: append([]*Interaction(nil), r.cassette.Interactions...),
+		WebSockets:   make([]*WebSocketSession, 0, len(r.cassette.WebSockets)),
+	}
+	for _, session := range r.cassette.WebSockets {
+		copied := *session
+		copied.Frames = append([]*Frame(nil), session.Frames...)
+		c.WebSockets = append(c.WebSockets, &copied)
+	}
+	return c
+}
+
+// Save writes what was recorded so far to name
+func (r *Recorder) Save(name string) error {
+	return r.Cassette().Save(name)
+}
+
+type recordingTransport struct {
+	recorder *Recorder
+	next     http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		requestBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(requestBody))
+	}
+
+	r := t.recorder
+	r.mu.Lock()
+	r.sent++
+	r.mu.Unlock()
+	resp, err := t.next.RoundTrip(req)
+	interaction := &Interaction{}
+	var responseBody []byte
+	if err == nil {
+		responseBody, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(responseBody))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	interaction.Request = &Request{
+		Method: req.Method,
+		URL:    r.redactor.requestURI(req.URL),
+		Header: r.redactor.header(req.Header),
+		Body:   newBody(r.redactor.body(requestBody)),
+	}
+	if err != nil {
+		interaction.Error = err.Error()
+	} else {
+		interaction.Response = &Response{
+			StatusCode: resp.StatusCode,
+			Header:     r.redactor.header(resp.Header),
+			Body:       newBody(responseBody),
+		}
+	}
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+type recordingDialer struct {
+	recorder *Recorder
+	next     comfyUIclient.WebSocketDialer
+}
+
+func (d *recordingDialer) Dial(rawURL string, header http.Header) (comfyUIclient.WebSocketConn, *http.Response, error) {
+	conn, resp, err := d.next.Dial(rawURL, header)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	r := d.recorder
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session := &WebSocketSession{URL: rawURL, Header: r.redactor.header(header), Frames: []*Frame{}}
+	if u, err := url.Parse(rawURL); err == nil {
+		session.URL = r.redactor.requestURI(u)
+	}
+	r.cassette.WebSockets = append(r.cassette.WebSockets, session)
+	return &recordingConn{WebSocketConn: conn, recorder: r, session: session}, resp, nil
+}
+
+type recordingConn struct {
+	comfyUIclient.WebSocketConn
+	recorder *Recorder
+	session  *WebSocketSession
+}
+
+func (c *recordingConn) ReadMessage() (int, []byte, error) {
+	messageType, data, err := c.WebSocketConn.ReadMessage()
+	if err != nil {
+		return messageType, data, err
+	}
+
+	r := c.recorder
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	frame := &Frame{After: r.sent, Binary: messageType == websocket.BinaryMessage}
+	if frame.Binary {
+		// binary frames stay base64 encoded even when they happen to be valid utf8
+		frame.Body = Body{Base64: base64.StdEncoding.EncodeToString(data)}
+	} else {
+		frame.Body = Body{Text: string(data)}
+	}
+	c.session.Frames = append(c.session.Frames, frame)
+	return messageType, data, nil
+}
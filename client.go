@@ -2,6 +2,7 @@ package comfyUIclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,24 +11,41 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
-
-	"github.com/google/uuid"
 )
 
 type Client struct {
 	ID         string
+	endPoint   EndPoint
 	baseURL    string
 	queueCount int
 	webSocket  *WebSocketConnection
 	ch         chan *WSMessage
 	httpClient *http.Client
+
+	userAgent     string
+	authenticator Authenticator
+	logger        Logger
+	retryPolicy   RetryPolicy
+	listeners     listeners
+	completions   completionTracker
+	tracer        Tracer
+	promptTraces  promptTracer
+	transportMode TransportMode
+	poller        *poller
+	droppedEvents atomic.Uint64
+	messages      messageQueue
 }
 
 type EndPoint struct {
 	Protocol string
 	Address  string
 	Port     string
+	// BasePath is the path ComfyUI is served under, e.g. "/comfy" behind a reverse proxy
+	BasePath string
+	// APIPrefix routes all requests through the "/api" prefix used by newer ComfyUI frontends
+	APIPrefix bool
 }
 
 func NewEndPoint(protocol, address, port string) *EndPoint {
@@ -38,11 +56,65 @@ func NewEndPoint(protocol, address, port string) *EndPoint {
 	}
 }
 
+// NewEndPointFromURL parses rawURL into an EndPoint, keeping its path as BasePath
+// A trailing "/api" segment is treated as APIPrefix
+func NewEndPointFromURL(rawURL string) (*EndPoint, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("url.Parse: error: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid url: %s", rawURL)
+	}
+	endPoint := NewEndPoint(u.Scheme, u.Hostname(), u.Port())
+	basePath := strings.TrimRight(u.Path, "/")
+	if strings.HasSuffix(basePath, "/api") {
+		basePath = strings.TrimSuffix(basePath, "/api")
+		endPoint.APIPrefix = true
+	}
+	endPoint.BasePath = basePath
+	return endPoint, nil
+}
+
+// String returns the endpoint url including BasePath
 func (e *EndPoint) String() string {
-	if e.Port == "" {
-		return e.Protocol + "://" + e.Address
+	host := e.Address
+	if e.Port != "" {
+		host += ":" + e.Port
+	}
+	return e.Protocol + "://" + host + e.basePath()
+}
+
+// URL returns the http url of router, with BasePath and the optional "/api" prefix applied
+func (e *EndPoint) URL(router string) string {
+	return e.String() + e.apiPrefix() + router
+}
+
+// WebSocketURL returns the websocket url for clientID
+func (e *EndPoint) WebSocketURL(clientID string) string {
+	ws := *e
+	switch e.Protocol {
+	case "https", "wss":
+		ws.Protocol = "wss"
+	default:
+		ws.Protocol = "ws"
+	}
+	return ws.URL("/ws") + "?clientId=" + url.QueryEscape(clientID)
+}
+
+func (e *EndPoint) basePath() string {
+	basePath := strings.Trim(e.BasePath, "/")
+	if basePath == "" {
+		return ""
 	}
-	return e.Protocol + "://" + e.Address + ":" + e.Port
+	return "/" + basePath
+}
+
+func (e *EndPoint) apiPrefix() string {
+	if e.APIPrefix {
+		return "/api"
+	}
+	return ""
 }
 
 func NewDefaultClient(endPoint *EndPoint) *Client {
@@ -50,37 +122,51 @@ func NewDefaultClient(endPoint *EndPoint) *Client {
 }
 
 func NewDefaultClientStr(baseURL string) (*Client, error) {
-	baseURLParsed, err := url.Parse(baseURL)
+	endPoint, err := NewEndPointFromURL(baseURL)
 	if err != nil {
-		return nil, fmt.Errorf("url.Parse: error: %w", err)
+		return nil, fmt.Errorf("NewEndPointFromURL: error: %w", err)
 	}
-	endPoint := NewEndPoint(baseURLParsed.Scheme, baseURLParsed.Hostname(), baseURLParsed.Port())
 	return NewDefaultClient(endPoint), nil
 }
 
+// NewClient creates a client for endPoint with httpClient, use New for more options
 func NewClient(endPoint *EndPoint, httpClient *http.Client) *Client {
-	c := &Client{
-		ID:         uuid.New().String(),
-		baseURL:    endPoint.String(),
-		httpClient: httpClient,
-		ch:         make(chan *WSMessage),
-	}
-
-	if strings.HasPrefix(c.baseURL, "https") {
-		endPoint.Protocol = "wss"
-	} else {
-		endPoint.Protocol = "ws"
-	}
-	c.webSocket = NewDefaultWebSocketConnection(endPoint.String()+"/ws?clientId="+c.ID, c)
+	// can't fail without WithTLSConfig or WithProxy
+	c, _ := NewWithEndPoint(endPoint, WithHTTPClient(httpClient))
 	return c
 }
 
+// SetAuthenticator sets the Authenticator used for http requests and the websocket handshake
+func (c *Client) SetAuthenticator(authenticator Authenticator) {
+	c.authenticator = authenticator
+	c.webSocket.Authenticator = authenticator
+}
+
 func (c *Client) IsInitialized() bool {
-	return c.webSocket.GetIsConnected()
+	return c.webSocket.GetIsConnected() || c.IsPolling()
 }
 
+// ConnectAndListen starts receiving events in the background according to the transport mode
+// With TransportAuto the client polls whenever the websocket is down, starting with a failed first connection,
+// and returns to the websocket once it reconnected
 func (c *Client) ConnectAndListen() {
-	go c.webSocket.ConnectAndListen()
+	c.poller.start()
+	switch c.transportMode {
+	case TransportPolling:
+		c.poller.active.Store(true)
+		c.logger.Info("tracking prompts by polling", "min_interval", c.poller.minInterval, "max_interval", c.poller.maxInterval)
+	case TransportAuto:
+		go func() {
+			if err := c.webSocket.connectAndStartListening(); err != nil {
+				c.logger.Warn("websocket unavailable, falling back to polling", "error", err)
+				c.poller.active.Store(true)
+				notify(c.poller.wake)
+			}
+			c.webSocket.ConnectAndListen()
+		}()
+	default:
+		go c.webSocket.ConnectAndListen()
+	}
 }
 
 func (c *Client) SendTaskStatus(w *WSMessage) error {
@@ -91,6 +177,11 @@ func (c *Client) SendTaskStatus(w *WSMessage) error {
 	return errors.New("client not initialized, ch is nil")
 }
 
+// GetTaskStatus returns the channel of task messages
+// Status messages and binary frames such as previews are only passed to listeners
+// Without listeners it must be drained or the websocket stops being read
+// Once a listener is added, messages that don't fit into the channel are dropped instead, see DroppedEvents
+// It is nil when the client was created with a negative WithEventBufferSize
 func (c *Client) GetTaskStatus() chan *WSMessage {
 	return c.ch
 }
@@ -99,30 +190,93 @@ func (c *Client) GetQueueCount() int {
 	return c.queueCount
 }
 
+// Handle decodes a websocket message and queues it for delivery to listeners and the task status channel
 func (c *Client) Handle(msg string) error {
 	message := &WSMessage{}
 	if err := json.Unmarshal([]byte(msg), message); err != nil {
 		return fmt.Errorf("json.Unmarshal: error: %w", err)
 	}
+	c.enqueue(message)
+	return nil
+}
+
+// HandleBinary handles binary websocket frames such as preview images
+func (c *Client) HandleBinary(data []byte) error {
+	message, err := decodeBinaryMessage(data)
+	if err != nil {
+		return fmt.Errorf("decodeBinaryMessage: error: %w", err)
+	}
+	c.enqueue(message)
+	return nil
+}
+
+func (c *Client) handleMessage(message *WSMessage) error {
+	if message.Type == Queued {
+		c.dispatch(message)
+		return nil
+	}
+	if err := c.deliver(message); err != nil {
+		return err
+	}
+
+	c.poller.observe(message)
+	completed := c.trackCompletion(message)
+	if completed == nil {
+		c.tracePrompt(message, nil)
+		return nil
+	}
+	data := completed.Data.(*WSMessageDataCompleted)
+	c.tracePrompt(message, data)
+	c.backfillOutputs(context.Background(), data)
+	c.logger.Debug("prompt completed", "prompt_id", data.PromptID, "status", data.Status)
+	c.poller.observe(completed)
+	return c.deliver(completed)
+}
+
+func (c *Client) deliver(message *WSMessage) error {
+	c.logger.Debug("websocket message received", messageFields(message)...)
+	c.dispatch(message)
 
 	switch message.Type {
 	case Status:
-		s := message.Data.(*WSMessageDataStatus)
-		c.queueCount = s.Status.ExecInfo.QueueRemaining
-	case ExecutionStart, ExecutionCached, Executing,
-		Progress, Executed, ExecutionInterrupted, ExecutionError:
+		// a decoder registered for status may return another type
+		if s, ok := message.Data.(*WSMessageDataStatus); ok {
+			c.queueCount = s.Status.ExecInfo.QueueRemaining
+		}
+		return nil
+	case PreviewImage, ProgressText, Binary:
+		// binary frames come with every sampling step, they are only passed to listeners
+		return nil
+	}
+	// unknown types are delivered too, their Data is json.RawMessage
+	if c.ch == nil {
+		return nil
+	}
+	if !c.hasListeners() {
 		if err := c.SendTaskStatus(message); err != nil {
 			return fmt.Errorf("SendTaskStatus: error: %w", err)
 		}
+		return nil
+	}
+	// listeners must keep receiving messages when nobody reads the channel
+	select {
+	case c.ch <- message:
 	default:
-		return fmt.Errorf("unknown message type: %s, message: %v", message.Type, message)
+		if dropped := c.droppedEvents.Add(1); dropped == 1 || dropped%1000 == 0 {
+			c.logger.Warn("task status channel is full, dropping messages", "dropped", dropped)
+		}
 	}
 	return nil
 }
 
+// DroppedEvents returns the number of messages that weren't sent to the GetTaskStatus channel because it was full
+func (c *Client) DroppedEvents() uint64 {
+	return c.droppedEvents.Load()
+}
+
 // QueuePromptByString queues a prompt and starts execution by workflow which type is string
 // workflow must be a json string
-// extraDataString must be a json string
+// extraDataString must be a json string, {} is sent when it is empty
 func (c *Client) QueuePromptByString(workflow string, extraDataString string) (*QueuePromptResp, error) {
 	if !c.IsInitialized() {
 		return nil, errors.New("client not initialized")
@@ -132,84 +286,26 @@ func (c *Client) QueuePromptByString(workflow string, extraDataString string) (*
 		return nil, errors.New("workflow is empty")
 	}
 
-	if extraDataString == "" {
-		extraDataString = "{}"
-	}
-
-	temp := struct {
-		ClientID  string          `json:"client_id"`
-		Prompt    json.RawMessage `json:"prompt"`
-		ExtraData *extraData      `json:"extra_data"`
-	}{
-		Prompt:   []byte(workflow),
-		ClientID: c.ID,
-		ExtraData: &extraData{
-			ExtraPngInfo: []byte(extraDataString),
-		},
-	}
-
-	resp, err := c.postJSONUsesRouter(PromptRouter, temp, nil)
-	if err != nil {
-		return nil, fmt.Errorf("httpClient.Post: error: %w", err)
-	}
-	defer resp.Body.Close()
-	q := &QueuePromptResp{}
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("io.ReadAll: error: %w", err)
-	}
-
-	if err := json.Unmarshal(body, &q); err != nil {
-		return nil, fmt.Errorf("json.NewDecoder: error: %w, resp.Body: %v", err, string(body))
-	}
-
-	return q, nil
+	return c.QueuePromptByStringWithOptions(context.Background(), workflow, &QueueOptions{
+		ExtraPngInfo: []byte(extraDataString),
+	})
 }
 
 // QueuePromptByNodes queues a prompt and starts execution by workflow which type is map[string]PromptNode
-// extraData must be a json string
+// extraDataString must be a json string, {} is sent when it is empty like QueuePromptByString does
 func (c *Client) QueuePromptByNodes(nodes map[string]PromptNode, extraDataString string) (*QueuePromptResp, error) {
 	if len(nodes) == 0 {
 		return nil, errors.New("nodes is empty")
 	}
 
-	temp := struct {
-		ClientID  string                `json:"client_id"`
-		Prompt    map[string]PromptNode `json:"prompt"`
-		ExtraData *extraData            `json:"extra_data"`
-	}{
-		Prompt:   nodes,
-		ClientID: c.ID,
-		ExtraData: &extraData{
-			ExtraPngInfo: []byte(extraDataString),
-		},
-	}
-	return c.queuePrompt(temp)
-}
-
-func (c *Client) queuePrompt(temp interface{}) (*QueuePromptResp, error) {
-	resp, err := c.postJSONUsesRouter(PromptRouter, temp, nil)
-	if err != nil {
-		return nil, fmt.Errorf("c.postJSONUsesRouter: error: %w", err)
-	}
-	defer resp.Body.Close()
-
-	q := &QueuePromptResp{}
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("io.ReadAll: error: %w", err)
-	}
-
-	if err := json.Unmarshal(body, &q); err != nil {
-		return nil, fmt.Errorf("json.Unmarshal: error: %w, resp.Body: %v", err, string(body))
-	}
-
-	return q, nil
+	return c.QueuePromptByNodesWithOptions(context.Background(), nodes, &QueueOptions{
+		ExtraPngInfo: []byte(extraDataString),
+	})
 }
 
 // GetQueueRemaining returns queue remaining
 func (c *Client) GetQueueRemaining() (uint64, error) {
-	resp, err := c.getJsonUsesRouter(PromptRouter, nil, nil)
+	resp, err := c.getJsonUsesRouter(context.Background(), PromptRouter, nil, nil)
 	if err != nil {
 		return 0, fmt.Errorf("c.getJsonUsesRouter: error: %w", err)
 	}
@@ -227,7 +323,7 @@ func (c *Client) GetQueueRemaining() (uint64, error) {
 
 // GetEmbeddings returns embeddings
 func (c *Client) GetEmbeddings() ([]string, error) {
-	resp, err := c.getJsonUsesRouter(EmbeddingsRouter, nil, nil)
+	resp, err := c.getJsonUsesRouter(context.Background(), EmbeddingsRouter, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("c.getJsonUsesRouter: error: %w", err)
 	}
@@ -245,7 +341,7 @@ func (c *Client) GetEmbeddings() ([]string, error) {
 
 // GetExtensions returns extensions for frontend
 func (c *Client) GetExtensions() ([]string, error) {
-	resp, err := c.getJsonUsesRouter(ExtensionsRouter, nil, nil)
+	resp, err := c.getJsonUsesRouter(context.Background(), ExtensionsRouter, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("c.getJsonUsesRouter: error: %w", err)
 	}
@@ -263,7 +359,7 @@ func (c *Client) GetExtensions() ([]string, error) {
 
 // GetAllHistories returns all histories
 func (c *Client) GetAllHistories() ([]*PromptHistoryItem, error) {
-	resp, err := c.getJsonUsesRouter(HistoryRouter, nil, nil)
+	resp, err := c.getJsonUsesRouter(context.Background(), HistoryRouter, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("c.getJsonUsesRouter: error: %w", err)
 	}
@@ -272,7 +368,11 @@ func (c *Client) GetAllHistories() ([]*PromptHistoryItem, error) {
 
 // GetHistoryByPromptID returns history info by promptID
 func (c *Client) GetHistoryByPromptID(promptID string) (*PromptHistoryItem, error) {
-	resp, err := c.getJson(string(HistoryRouter)+"/"+promptID, nil, nil)
+	return c.getHistoryByPromptID(context.Background(), promptID)
+}
+
+func (c *Client) getHistoryByPromptID(ctx context.Context, promptID string) (*PromptHistoryItem, error) {
+	resp, err := c.getJson(ctx, string(HistoryRouter)+"/"+promptID, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("c.getJsonUsesRouter: error: %w", err)
 	}
@@ -309,7 +409,7 @@ func getHistorySlices(resp *http.Response) ([]*PromptHistoryItem, error) {
 // DeleteAllHistories deletes all histories
 func (c *Client) DeleteAllHistories() error {
 	data := map[string]string{"clear": "clear"}
-	_, err := c.postJSONUsesRouter(HistoryRouter, data, nil)
+	_, err := c.postJSONUsesRouter(context.Background(), HistoryRouter, data, nil)
 	if err != nil {
 		return fmt.Errorf("http.Post: error: %w", err)
 	}
@@ -319,7 +419,7 @@ func (c *Client) DeleteAllHistories() error {
 // DeleteHistoryByPromptID deletes history by promptID
 func (c *Client) DeleteHistoryByPromptID(promptID string) error {
 	data := map[string][]string{"delete": {promptID}}
-	_, err := c.postJSONUsesRouter(HistoryRouter, data, nil)
+	_, err := c.postJSONUsesRouter(context.Background(), HistoryRouter, data, nil)
 	if err != nil {
 		return fmt.Errorf("http.Post: error: %w", err)
 	}
@@ -329,11 +429,7 @@ func (c *Client) DeleteHistoryByPromptID(promptID string) error {
 
 // GetFile returns file byte data
 func (c *Client) GetFile(image *DataOutputFile) (*[]byte, error) {
-	params := url.Values{}
-	params.Add("filename", image.Filename)
-	params.Add("subfolder", image.SubFolder)
-	params.Add("type", image.Type)
-	resp, err := c.getJsonUsesRouter(ViewRouter, params, nil)
+	resp, err := c.getJsonUsesRouter(context.Background(), ViewRouter, viewParams(image), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -345,6 +441,19 @@ func (c *Client) GetFile(image *DataOutputFile) (*[]byte, error) {
 	return &body, nil
 }
 
+func viewParams(file *DataOutputFile) url.Values {
+	params := url.Values{}
+	params.Add("filename", file.Filename)
+	params.Add("subfolder", file.SubFolder)
+	params.Add("type", file.Type)
+	return params
+}
+
+// viewURL returns the url GetFile downloads file from
+func (c *Client) viewURL(file *DataOutputFile) string {
+	return c.baseURL + string(ViewRouter) + "?" + viewParams(file).Encode()
+}
+
 // GetViewMetadata returns view metadata
 func (c *Client) GetViewMetadata(folderName string, fileName string) ([]byte, error) {
 	if folderName == "" {
@@ -355,7 +464,7 @@ func (c *Client) GetViewMetadata(folderName string, fileName string) ([]byte, er
 		folderName = "/" + folderName
 	}
 
-	resp, err := c.getJson(string(ViewMetadataRouter)+folderName, url.Values{"filename": {fileName}}, nil)
+	resp, err := c.getJson(context.Background(), string(ViewMetadataRouter)+folderName, url.Values{"filename": {fileName}}, nil)
 	if err != nil {
 		return nil, fmt.Errorf("c.getJsonUsesRouter: error: %w", err)
 	}
@@ -369,7 +478,7 @@ func (c *Client) GetViewMetadata(folderName string, fileName string) ([]byte, er
 
 // GetSystemStats returns system stats
 func (c *Client) GetSystemStats() (*SystemStats, error) {
-	resp, err := c.getJsonUsesRouter(SystemStatsRouter, nil, nil)
+	resp, err := c.getJsonUsesRouter(context.Background(), SystemStatsRouter, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("c.getJsonUsesRouter: error: %w", err)
 	}
@@ -387,7 +496,7 @@ func (c *Client) GetSystemStats() (*SystemStats, error) {
 
 // InterruptExecution interrupts execution
 func (c *Client) InterruptExecution() error {
-	_, err := c.postJSONUsesRouter(InterruptRouter, nil, nil)
+	_, err := c.postJSONUsesRouter(context.Background(), InterruptRouter, nil, nil)
 	if err != nil {
 		return fmt.Errorf("c.postJSONUsesRouter: error: %w", err)
 	}
@@ -396,29 +505,33 @@ func (c *Client) InterruptExecution() error {
 
 // DeleteAllQueues deletes all prompts in queue
 // Delete all prompts in queue with this client sent, or it will not work
+// Deleted prompts watched by the client complete as interrupted once they are neither queued nor in the history, see WithResync
 func (c *Client) DeleteAllQueues() error {
 	data := map[string]string{"clear": "clear"}
-	_, err := c.postJSONUsesRouter(QueueRouter, data, nil)
+	_, err := c.postJSONUsesRouter(context.Background(), QueueRouter, data, nil)
 	if err != nil {
 		return fmt.Errorf("c.postJSONUsesRouter: error: %w", err)
 	}
+	notify(c.poller.resync)
 	return nil
 }
 
 // DeleteQueueByPromptID deletes prompt in queue by promptID
 // You must input promptID with this client sent, or it will not work
+// A deleted prompt watched by the client completes as interrupted once it is neither queued nor in the history, see WithResync
 func (c *Client) DeleteQueueByPromptID(promptID string) error {
-	data := map[string]string{"delete": promptID}
-	_, err := c.postJSONUsesRouter(QueueRouter, data, nil)
+	data := map[string][]string{"delete": {promptID}}
+	_, err := c.postJSONUsesRouter(context.Background(), QueueRouter, data, nil)
 	if err != nil {
 		return fmt.Errorf("c.postJSONUsesRouter: error: %w", err)
 	}
+	notify(c.poller.resync)
 	return nil
 }
 
 // GetObjectInfos returns node infos in workflow
 func (c *Client) GetObjectInfos() (map[string]*NodeObject, error) {
-	resp, err := c.getJsonUsesRouter(ObjectInfoRouter, nil, nil)
+	resp, err := c.getJsonUsesRouter(context.Background(), ObjectInfoRouter, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("c.getJsonUsesRouter: error: %w", err)
 	}
@@ -436,7 +549,7 @@ func (c *Client) GetObjectInfos() (map[string]*NodeObject, error) {
 
 // GetObjectInfoByNodeName returns node info by nodeName
 func (c *Client) GetObjectInfoByNodeName(name string) (*NodeObject, error) {
-	resp, err := c.getJson(string(ObjectInfoRouter)+"/"+name, nil, nil)
+	resp, err := c.getJson(context.Background(), string(ObjectInfoRouter)+"/"+name, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("c.getJson: error: %w", err)
 	}
@@ -452,9 +565,43 @@ func (c *Client) GetObjectInfoByNodeName(name string) (*NodeObject, error) {
 	return objectInfos[name], nil
 }
 
+// GetModelFolders returns the names of the model folders, e.g. checkpoints and loras
+func (c *Client) GetModelFolders() ([]string, error) {
+	return c.getStrings(string(ModelsRouter))
+}
+
+// GetModels returns the model files in folder
+func (c *Client) GetModels(folder string) ([]string, error) {
+	if folder == "" {
+		return nil, errors.New("folder is empty")
+	}
+	return c.getStrings(string(ModelsRouter) + "/" + url.PathEscape(folder))
+}
+
+func (c *Client) getStrings(router string) ([]string, error) {
+	resp, err := c.getJson(context.Background(), router, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("c.getJson: error: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("io.ReadAll: error: %w", err)
+	}
+	var values []string
+	if err := json.Unmarshal(body, &values); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: error: %w, resp.Body: %v", err, string(body))
+	}
+	return values, nil
+}
+
 // GetQueueInfo returns queue info
 func (c *Client) GetQueueInfo() (*QueueInfo, error) {
-	resp, err := c.getJsonUsesRouter(QueueRouter, nil, nil)
+	return c.getQueueInfo(context.Background())
+}
+
+func (c *Client) getQueueInfo(ctx context.Context) (*QueueInfo, error) {
+	resp, err := c.getJsonUsesRouter(ctx, QueueRouter, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("c.getJsonUsesRouter: error: %w", err)
 	}
@@ -476,7 +623,7 @@ func (c *Client) uploadFile(router Router, reader io.Reader, fileName string, ov
 		return nil, fmt.Errorf("createUploadRequest: error: %w", err)
 	}
 
-	resp, err := c.postMultiPartUsesRouter(router, requestBody, headers)
+	resp, err := c.postMultiPartUsesRouter(context.Background(), router, requestBody, headers)
 	if err != nil {
 		return nil, fmt.Errorf("c.postJSONUsesRouter: error: %w", err)
 	}
@@ -538,15 +685,13 @@ func createUploadRequest(reader io.Reader, fileName string, overwrite bool, file
 	return &requestBody, headers, nil
 }
 
-func (c *Client) makeRequest(method, router string, values url.Values, data interface{}, headers map[string]string, contentType string) (*http.Response, error) {
-	var req *http.Request
-	var err error
-
+func (c *Client) makeRequest(ctx context.Context, method, router string, values url.Values, data interface{}, headers map[string]string, contentType string) (*http.Response, error) {
 	rawURL := c.baseURL + router
 	if len(values) != 0 {
 		rawURL += "?" + values.Encode()
 	}
 
+	var body []byte
 	if data != nil {
 		switch contentType {
 		case "application/json":
@@ -554,24 +699,50 @@ func (c *Client) makeRequest(method, router string, values url.Values, data inte
 			if err != nil {
 				return nil, fmt.Errorf("json.Marshal: %w", err)
 			}
-			req, err = http.NewRequest(method, rawURL, io.NopCloser(bytes.NewReader(jsonData)))
-			if err != nil {
-				return nil, fmt.Errorf("http.NewRequest: %w", err)
-			}
+			body = jsonData
 		case "multipart/form-data":
-			buf := data.(*bytes.Buffer)
-			req, err = http.NewRequest(method, rawURL, io.NopCloser(buf))
-			if err != nil {
-				return nil, fmt.Errorf("http.NewRequest: %w", err)
-			}
+			body = data.(*bytes.Buffer).Bytes()
 		default:
 			return nil, fmt.Errorf("unsupported content type: %s", contentType)
 		}
-	} else {
-		req, err = http.NewRequest(method, rawURL, nil)
-		if err != nil {
-			return nil, fmt.Errorf("http.NewRequest: %w", err)
+	}
+
+	resp, err := c.doRequest(ctx, method, rawURL, body, headers, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	// credentials may have expired, refresh them and retry once
+	if resp.StatusCode == http.StatusUnauthorized && c.authenticator != nil {
+		if err := c.authenticator.Refresh(); err != nil {
+			c.logger.Warn("refresh credentials failed", "method", method, "router", router, "error", err)
+			return resp, nil
 		}
+		c.logger.Info("credentials refreshed, retrying request", "method", method, "router", router)
+		resp.Body.Close()
+		return c.doRequest(ctx, method, rawURL, body, headers, contentType)
+	}
+	return resp, nil
+}
+
+func (c *Client) doRequest(ctx context.Context, method, rawURL string, body []byte, headers map[string]string, contentType string) (*http.Response, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bodyReader)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+
+	if c.authenticator != nil {
+		if err := c.authenticator.Authenticate(req.Header); err != nil {
+			return nil, fmt.Errorf("c.authenticator.Authenticate: %w", err)
+		}
+	}
+
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
 	}
 
 	// Don't change the order
@@ -579,38 +750,40 @@ func (c *Client) makeRequest(method, router string, values url.Values, data inte
 	for key, value := range headers {
 		req.Header.Set(key, value)
 	}
+	c.injectTrace(ctx, req.Header)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("c.httpClient.Do: %w", err)
 	}
+	c.logger.Debug("http request done", "method", method, "url", req.URL.Redacted(), "status", resp.StatusCode)
 	return resp, nil
 }
 
-func (c *Client) requestJson(method, router string, values url.Values, data interface{}, headers map[string]string) (*http.Response, error) {
-	return c.makeRequest(method, router, values, data, headers, "application/json")
+func (c *Client) requestJson(ctx context.Context, method, router string, values url.Values, data interface{}, headers map[string]string) (*http.Response, error) {
+	return c.requestWithRetry(ctx, method, router, values, data, headers, "application/json")
 }
 
-func (c *Client) requestMultiPart(method, router string, values url.Values, data *bytes.Buffer, headers map[string]string) (*http.Response, error) {
-	return c.makeRequest(method, router, values, data, headers, "multipart/form-data")
+func (c *Client) requestMultiPart(ctx context.Context, method, router string, values url.Values, data *bytes.Buffer, headers map[string]string) (*http.Response, error) {
+	return c.requestWithRetry(ctx, method, router, values, data, headers, "multipart/form-data")
 }
 
-func (c *Client) postMultiPartUsesRouter(router Router, data *bytes.Buffer, headers map[string]string) (*http.Response, error) {
-	return c.requestMultiPart(http.MethodPost, string(router), nil, data, headers)
+func (c *Client) postMultiPartUsesRouter(ctx context.Context, router Router, data *bytes.Buffer, headers map[string]string) (*http.Response, error) {
+	return c.requestMultiPart(ctx, http.MethodPost, string(router), nil, data, headers)
 }
 
-func (c *Client) postJSONUsesRouter(router Router, data interface{}, headers map[string]string) (*http.Response, error) {
-	return c.postJson(string(router), data, headers)
+func (c *Client) postJSONUsesRouter(ctx context.Context, router Router, data interface{}, headers map[string]string) (*http.Response, error) {
+	return c.postJson(ctx, string(router), data, headers)
 }
 
-func (c *Client) postJson(router string, data interface{}, headers map[string]string) (*http.Response, error) {
-	return c.requestJson(http.MethodPost, router, nil, data, headers)
+func (c *Client) postJson(ctx context.Context, router string, data interface{}, headers map[string]string) (*http.Response, error) {
+	return c.requestJson(ctx, http.MethodPost, router, nil, data, headers)
 }
 
-func (c *Client) getJsonUsesRouter(router Router, values url.Values, headers map[string]string) (*http.Response, error) {
-	return c.getJson(string(router), values, headers)
+func (c *Client) getJsonUsesRouter(ctx context.Context, router Router, values url.Values, headers map[string]string) (*http.Response, error) {
+	return c.getJson(ctx, string(router), values, headers)
 }
 
-func (c *Client) getJson(router string, values url.Values, headers map[string]string) (*http.Response, error) {
-	return c.requestJson(http.MethodGet, router, values, nil, headers)
+func (c *Client) getJson(ctx context.Context, router string, values url.Values, headers map[string]string) (*http.Response, error) {
+	return c.requestJson(ctx, http.MethodGet, router, values, nil, headers)
 }
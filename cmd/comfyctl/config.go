@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const defaultServer = "http://127.0.0.1:8188"
+
+// config is a profile of the config file, e.g. ~/.config/comfyctl/config.json:
+//
+//	{
+//	  "default_profile": "prod",
+//	  "profiles": {
+//	    "prod": {"server": "https://comfy.example.com", "token": "...", "output": "table"},
+//	    "local": {"server": "http://127.0.0.1:8188"}
+//	  }
+//	}
+type config struct {
+	Server string `json:"server"`
+	Token  string `json:"token,omitempty"`
+	User   string `json:"user,omitempty"`
+	Output string `json:"output,omitempty"`
+	// ClientID is the websocket client id, a new one is generated per run when empty
+	ClientID string `json:"client_id,omitempty"`
+	// Timeout is the http timeout, e.g. "30s"
+	Timeout string `json:"timeout,omitempty"`
+}
+
+type configFile struct {
+	DefaultProfile string             `json:"default_profile"`
+	Profiles       map[string]*config `json:"profiles"`
+}
+
+// configPath returns COMFYCTL_CONFIG or config.json in the comfyctl user config directory
+func configPath() string {
+	if path := os.Getenv("COMFYCTL_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "comfyctl", "config.json")
+}
+
+// loadConfig merges flags over the environment over the profile
+func (a *app) loadConfig() error {
+	if a.config != nil {
+		return nil
+	}
+	profile, err := loadProfile(configPath(), firstNonEmpty(a.flags.profile, os.Getenv("COMFYCTL_PROFILE")))
+	if err != nil {
+		return err
+	}
+
+	c := &config{
+		Server:   firstNonEmpty(a.flags.server, os.Getenv("COMFY_URL"), profile.Server, defaultServer),
+		Token:    firstNonEmpty(a.flags.token, os.Getenv("COMFY_TOKEN"), profile.Token),
+		User:     firstNonEmpty(a.flags.user, os.Getenv("COMFY_USER"), profile.User),
+		Output:   firstNonEmpty(a.flags.output, os.Getenv("COMFYCTL_OUTPUT"), profile.Output, "table"),
+		ClientID: firstNonEmpty(a.flags.clientID, os.Getenv("COMFY_CLIENT_ID"), profile.ClientID),
+		Timeout:  firstNonEmpty(os.Getenv("COMFYCTL_TIMEOUT"), profile.Timeout),
+	}
+	if c.Output != "table" && c.Output != "json" {
+		return fmt.Errorf("unknown output format %q, use table or json", c.Output)
+	}
+	if c.Timeout != "" {
+		if _, err := time.ParseDuration(c.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout %q: %w", c.Timeout, err)
+		}
+	}
+	a.config = c
+	return nil
+}
+
+// loadProfile returns the profile name of the config file at path, or its default profile when name is empty
+// A missing file is an empty profile unless a profile was asked for
+func loadProfile(path, name string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) || path == "" {
+		if name != "" {
+			return nil, fmt.Errorf("profile %q not found, no config file at %s", name, path)
+		}
+		return &config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: error: %w", err)
+	}
+
+	var file configFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if name == "" {
+		name = file.DefaultProfile
+	}
+	if name == "" {
+		name = "default"
+		if _, ok := file.Profiles[name]; !ok {
+			return &config{}, nil
+		}
+	}
+	profile, ok := file.Profiles[name]
+	if !ok || profile == nil {
+		return nil, fmt.Errorf("profile %q not found in %s", name, path)
+	}
+	return profile, nil
+}
+
+func (c *config) timeout() time.Duration {
+	if timeout, err := time.ParseDuration(c.Timeout); err == nil && timeout > 0 {
+		return timeout
+	}
+	return 30 * time.Second
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
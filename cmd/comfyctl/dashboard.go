@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/XdpCs/comfyUIclient/graph"
+)
+
+// dashboard is the state shown by the dashboard command, every field is guarded by mu
+type dashboard struct {
+	mu      sync.Mutex
+	a       *app
+	client  *comfyUIclient.Client
+	changed chan struct{}
+
+	queue    *comfyUIclient.QueueInfo
+	stats    *comfyUIclient.SystemStats
+	history  []*historySummary
+	running  *runningPrompt
+	selected int
+	err      error
+	message  string
+	updated  time.Time
+	// confirm is the key that has to be pressed again to confirm an action, until confirmUntil
+	confirm      byte
+	confirmUntil time.Time
+}
+
+// runningPrompt is what the events tell about the running prompt
+// Events of prompts queued by other clients aren't sent to the dashboard, only the queue shows them
+type runningPrompt struct {
+	promptID  string
+	startedAt time.Time
+	node      string
+	classType string
+	value     int
+	max       int
+	executed  map[string]bool
+	cached    int
+	total     int
+}
+
+func runDashboard(a *app, args []string) error {
+	fs := a.flagSet("dashboard")
+	refresh := fs.Duration("refresh", time.Second, "interval the queue is polled at")
+	if _, err := a.parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	stdin, ok := a.stdin.(*os.File)
+	if !ok {
+		return errors.New("the dashboard needs a terminal")
+	}
+	state, err := makeRaw(stdin)
+	if err != nil {
+		return fmt.Errorf("the dashboard needs a terminal: %w", err)
+	}
+	defer restore(stdin, state)
+
+	client, err := a.newClient()
+	if err != nil {
+		return err
+	}
+	d := &dashboard{a: a, client: client, changed: make(chan struct{}, 1)}
+	client.AddListener(d.handle)
+	client.ConnectAndListen()
+
+	// alternate screen, hidden cursor
+	fmt.Fprint(a.stdout, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(a.stdout, "\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan byte, 16)
+	go readKeys(stdin, keys)
+	go d.poll(*refresh)
+
+	render := time.NewTicker(250 * time.Millisecond)
+	defer render.Stop()
+	d.render(stdin)
+	for {
+		select {
+		case <-a.ctx.Done():
+			return nil
+		case key, ok := <-keys:
+			if !ok || key == 'q' || key == 3 {
+				return nil
+			}
+			d.key(key)
+			d.render(stdin)
+		case <-d.changed:
+			d.render(stdin)
+		case <-render.C:
+			d.render(stdin)
+		}
+	}
+}
+
+// key codes of the arrow keys, escape sequences are mapped to them by readKeys
+const (
+	keyUp   = 0x80
+	keyDown = 0x81
+)
+
+// readKeys sends key presses to keys, arrow keys are translated to keyUp and keyDown
+func readKeys(r io.Reader, keys chan<- byte) {
+	defer close(keys)
+	reader := bufio.NewReader(r)
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		if b == 0x1b && reader.Buffered() >= 2 {
+			sequence := make([]byte, 2)
+			_, _ = io.ReadFull(reader, sequence)
+			switch string(sequence) {
+			case "[A":
+				b = keyUp
+			case "[B":
+				b = keyDown
+			default:
+				continue
+			}
+		}
+		keys <- b
+	}
+}
+
+func (d *dashboard) notify() {
+	select {
+	case d.changed <- struct{}{}:
+	default:
+	}
+}
+
+// handle updates the running prompt from websocket events
+func (d *dashboard) handle(message *comfyUIclient.WSMessage) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer d.notify()
+
+	switch data := message.Data.(type) {
+	case *comfyUIclient.WSMessageDataStatus:
+		go d.refreshQueue()
+	case *comfyUIclient.WSMessageDataExecutionStart:
+		d.running = &runningPrompt{promptID: data.PromptID, startedAt: time.Now(), executed: make(map[string]bool)}
+		d.running.total = d.expectedNodes(data.PromptID, nil)
+	case *comfyUIclient.WSMessageDataExecutionCached:
+		if run := d.run(data.PromptID); run != nil {
+			run.cached = len(data.Nodes)
+			run.total = d.expectedNodes(data.PromptID, data.Nodes)
+		}
+	case *comfyUIclient.WSMessageDataExecuting:
+		// the executing message without a node follows the completion
+		if data.Node == "" {
+			break
+		}
+		if run := d.run(data.PromptID); run != nil {
+			run.node, run.value, run.max = data.Node, 0, 0
+			run.classType = d.classType(data.PromptID, data.Node)
+			run.executed[data.Node] = true
+		}
+	case *comfyUIclient.WSMessageDataProgress:
+		if run := d.run(data.PromptID); run != nil {
+			run.value, run.max = data.Value, data.Max
+		}
+	case *comfyUIclient.WSMessageDataCompleted:
+		if d.running != nil && d.running.promptID == data.PromptID {
+			d.running = nil
+		}
+		go d.refreshHistory()
+	}
+}
+
+// run returns the running prompt when it is promptID, starting to track it when the start was missed
+func (d *dashboard) run(promptID string) *runningPrompt {
+	if d.running == nil || d.running.promptID != promptID {
+		d.running = &runningPrompt{promptID: promptID, startedAt: time.Now(), executed: make(map[string]bool)}
+		d.running.total = d.expectedNodes(promptID, nil)
+	}
+	return d.running
+}
+
+// queuedPrompt returns promptID from the last queue info, d.mu must be held
+func (d *dashboard) queuedPrompt(promptID string) *comfyUIclient.NodeInfo {
+	if d.queue == nil {
+		return nil
+	}
+	for _, items := range [][]*comfyUIclient.NodeInfo{d.queue.QueueRunning, d.queue.QueuePending} {
+		for _, item := range items {
+			if item.PromptID == promptID {
+				return item
+			}
+		}
+	}
+	return nil
+}
+
+func (d *dashboard) classType(promptID, node string) string {
+	if item := d.queuedPrompt(promptID); item != nil {
+		return item.Prompt[node].ClassType
+	}
+	return ""
+}
+
+// expectedNodes estimates the number of nodes promptID executes, 0 when unknown
+func (d *dashboard) expectedNodes(promptID string, cached []string) int {
+	item := d.queuedPrompt(promptID)
+	if item == nil {
+		return 0
+	}
+	g, err := graph.New(item.Prompt)
+	if err != nil {
+		return 0
+	}
+	return g.ExpectedExecutingStepsFor(item.OutputNodeIDs, cached)
+}
+
+// poll refreshes the queue every interval, stats and history less often
+func (d *dashboard) poll(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for i := 0; ; i++ {
+		d.refreshQueue()
+		if i%3 == 0 {
+			d.refreshStats()
+		}
+		if i%10 == 0 {
+			d.refreshHistory()
+		}
+		select {
+		case <-d.a.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *dashboard) refreshQueue() {
+	queue, err := d.client.GetQueueInfo()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer d.notify()
+	if err != nil {
+		d.err = err
+		return
+	}
+	sort.SliceStable(queue.QueuePending, func(i, j int) bool { return queue.QueuePending[i].Number < queue.QueuePending[j].Number })
+	d.queue, d.err, d.updated = queue, nil, time.Now()
+	if d.selected >= len(queue.QueuePending) {
+		d.selected = max(len(queue.QueuePending)-1, 0)
+	}
+	if d.running != nil && d.running.total == 0 {
+		d.running.total = d.expectedNodes(d.running.promptID, nil)
+	}
+	if d.running != nil && (len(queue.QueueRunning) == 0 || queue.QueueRunning[0].PromptID != d.running.promptID) {
+		// the completion was missed
+		d.running = nil
+	}
+}
+
+func (d *dashboard) refreshStats() {
+	stats, err := d.client.GetSystemStats()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer d.notify()
+	if err != nil {
+		d.err = err
+		return
+	}
+	d.stats = stats
+}
+
+func (d *dashboard) refreshHistory() {
+	history, err := loadHistory(d.client)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer d.notify()
+	if err != nil {
+		d.err = err
+		return
+	}
+	d.history = history
+}
+
+// key handles a key press, destructive actions have to be confirmed by pressing the key again
+func (d *dashboard) key(key byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	pending := 0
+	if d.queue != nil {
+		pending = len(d.queue.QueuePending)
+	}
+
+	switch key {
+	case keyUp, 'k':
+		d.selected = max(d.selected-1, 0)
+	case keyDown, 'j':
+		d.selected = min(d.selected+1, max(pending-1, 0))
+	case 'r':
+		d.message = "refreshing"
+		go func() {
+			d.refreshQueue()
+			d.refreshStats()
+			d.refreshHistory()
+		}()
+	case 'x', 'i':
+		if d.confirm != key || time.Now().After(d.confirmUntil) {
+			d.confirm, d.confirmUntil = key, time.Now().Add(3*time.Second)
+			if key == 'x' {
+				d.message = "press x again to cancel the selected prompt"
+			} else {
+				d.message = "press i again to interrupt the running prompt"
+			}
+			return
+		}
+		d.confirm = 0
+		if key == 'x' {
+			if item := d.selectedPrompt(); item != nil {
+				d.message = "cancelling " + item.PromptID
+				go d.action(func() error { return d.client.DeleteQueueByPromptID(item.PromptID) }, "cancelled "+item.PromptID)
+			}
+			return
+		}
+		d.message = "interrupting"
+		go d.action(d.client.InterruptExecution, "interrupted")
+	case 'K', 'J', 'f':
+		item := d.selectedPrompt()
+		if item == nil {
+			return
+		}
+		number, ok := d.reorderNumber(key)
+		if !ok {
+			return
+		}
+		if key == 'K' || key == 'f' {
+			d.selected = max(d.selected-1, 0)
+			if key == 'f' {
+				d.selected = 0
+			}
+		} else {
+			d.selected = min(d.selected+1, pending-1)
+		}
+		d.message = "moving " + item.PromptID
+		go d.action(func() error { return d.move(item, number) }, "moved "+item.PromptID)
+	}
+}
+
+func (d *dashboard) selectedPrompt() *comfyUIclient.NodeInfo {
+	if d.queue == nil || d.selected >= len(d.queue.QueuePending) {
+		return nil
+	}
+	return d.queue.QueuePending[d.selected]
+}
+
+// reorderNumber returns the queue number that moves the selected prompt one up (K), down (J) or to the front (f)
+func (d *dashboard) reorderNumber(key byte) (float64, bool) {
+	pending := d.queue.QueuePending
+	i := d.selected
+	switch {
+	case key == 'f' && i > 0:
+		return pending[0].Number - 1, true
+	case key == 'K' && i == 1:
+		return pending[0].Number - 1, true
+	case key == 'K' && i > 1:
+		return (pending[i-2].Number + pending[i-1].Number) / 2, true
+	case key == 'J' && i == len(pending)-2:
+		return pending[i+1].Number + 1, true
+	case key == 'J' && i < len(pending)-2:
+		return (pending[i+1].Number + pending[i+2].Number) / 2, true
+	}
+	return 0, false
+}
+
+// move requeues item with number, ComfyUI can't reorder the queue, so the prompt is removed and queued again
+// with the same prompt id and client id, it is only queued again when it is neither queued nor started after removing it
+func (d *dashboard) move(item *comfyUIclient.NodeInfo, number float64) error {
+	if err := d.client.DeleteQueueByPromptID(item.PromptID); err != nil {
+		return err
+	}
+	exists, err := d.client.PromptExists(d.a.ctx, item.PromptID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("prompt %s started or couldn't be removed, not moved", item.PromptID)
+	}
+
+	var extraData map[string]interface{}
+	if len(item.ExtraData) != 0 {
+		if err := json.Unmarshal(item.ExtraData, &extraData); err != nil {
+			return fmt.Errorf("json.Unmarshal: error: %w", err)
+		}
+	}
+	clientID, _ := extraData["client_id"].(string)
+	delete(extraData, "client_id")
+	_, err = d.client.QueuePromptByNodesWithOptions(d.a.ctx, item.Prompt, &comfyUIclient.QueueOptions{
+		PromptID:                item.PromptID,
+		ClientID:                clientID,
+		Number:                  &number,
+		ExtraData:               extraData,
+		PartialExecutionTargets: item.OutputNodeIDs,
+	})
+	return err
+}
+
+// action runs f and shows done or its error
+func (d *dashboard) action(f func() error, done string) {
+	err := f()
+	d.refreshQueue()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer d.notify()
+	if err != nil {
+		d.message = "error: " + err.Error()
+		return
+	}
+	d.message = done
+}
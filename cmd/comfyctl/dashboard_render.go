@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+// render redraws the whole screen, lines are cut to the terminal size
+func (d *dashboard) render(terminal *os.File) {
+	width, height, err := terminalSize(terminal)
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+
+	d.mu.Lock()
+	lines := d.lines(width)
+	d.mu.Unlock()
+
+	// the help line stays at the bottom, the history is cut first
+	help := lines[len(lines)-1]
+	lines = lines[:len(lines)-1]
+	if len(lines) > height-1 {
+		lines = lines[:max(height-1, 0)]
+	}
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, help)
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, line := range lines {
+		b.WriteString(cut(line, width))
+		b.WriteString("\x1b[K")
+		if i < len(lines)-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	b.WriteString("\x1b[J")
+	fmt.Fprint(d.a.stdout, b.String())
+}
+
+// lines returns the screen content, the last line is the key help, d.mu must be held
+func (d *dashboard) lines(width int) []string {
+	var lines []string
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	running, pending := 0, 0
+	if d.queue != nil {
+		running, pending = len(d.queue.QueueRunning), len(d.queue.QueuePending)
+	}
+	add("\x1b[1mcomfyctl\x1b[0m %s  running %d  pending %d  %s", d.a.config.Server, running, pending, time.Now().Format("15:04:05"))
+	if d.err != nil {
+		add("\x1b[31merror: %v\x1b[0m", d.err)
+	}
+	add("")
+
+	add("\x1b[1mGPU\x1b[0m")
+	if d.stats == nil || len(d.stats.Devices) == 0 {
+		add("  -")
+	} else {
+		for _, device := range d.stats.Devices {
+			used := device.VRAMTotal - device.VRAMFree
+			add("  %d %s", device.Index, device.Name)
+			add("    vram  %s %s / %s", bar(float64(used), float64(device.VRAMTotal), 20), bytesString(used), bytesString(device.VRAMTotal))
+			if device.TorchVRAMTotal > 0 {
+				torchUsed := device.TorchVRAMTotal - device.TorchVRAMFree
+				add("    torch %s %s / %s", bar(float64(torchUsed), float64(device.TorchVRAMTotal), 20), bytesString(torchUsed), bytesString(device.TorchVRAMTotal))
+			}
+		}
+	}
+	add("")
+
+	add("\x1b[1mRUNNING\x1b[0m")
+	switch {
+	case d.running != nil:
+		run := d.running
+		nodes := fmt.Sprintf("%d", len(run.executed))
+		if run.total > 0 {
+			nodes = fmt.Sprintf("%d/%d", min(len(run.executed), run.total), run.total)
+		}
+		add("  %s  %s  nodes %s  cached %d", run.promptID, durationString(time.Since(run.startedAt)), nodes, run.cached)
+		if run.node != "" {
+			node := run.node
+			if run.classType != "" {
+				node += " " + run.classType
+			}
+			if run.max > 0 {
+				add("  node %s  %s %d/%d", node, bar(float64(run.value), float64(run.max), max(min(width-len(node)-24, 40), 10)), run.value, run.max)
+			} else {
+				add("  node %s", node)
+			}
+		}
+	case running != 0:
+		// prompts of other clients are only known from the queue
+		add("  %s", d.queue.QueueRunning[0].PromptID)
+	default:
+		add("  idle")
+	}
+	add("")
+
+	add("\x1b[1mPENDING\x1b[0m")
+	if pending == 0 {
+		add("  -")
+	}
+	for i, item := range d.queuePending() {
+		marker := "  "
+		if i == d.selected {
+			marker = "\x1b[7m>"
+		}
+		add("%s %-8s %s  %d nodes\x1b[0m", marker, fmt.Sprint(item.Number), item.PromptID, len(item.Prompt))
+	}
+	add("")
+
+	add("\x1b[1mHISTORY\x1b[0m")
+	if len(d.history) == 0 {
+		add("  -")
+	}
+	for _, summary := range d.history {
+		started := "-"
+		if !summary.StartedAt.IsZero() {
+			started = summary.StartedAt.Local().Format("01-02 15:04:05")
+		}
+		detail := fmt.Sprintf("%d outputs", summary.outputCount())
+		if summary.Error != "" {
+			detail = summary.Error
+		}
+		add("  %s %s  %-14s  %8s  %s", statusColor(summary.Status), summary.PromptID, started, durationString(summary.Duration), detail)
+	}
+
+	help := "q quit  ↑↓ select  x cancel  f front  K/J move  i interrupt  r refresh"
+	if d.message != "" {
+		help = d.message + "  |  " + help
+	}
+	lines = append(lines, help)
+	return lines
+}
+
+func (d *dashboard) queuePending() []*comfyUIclient.NodeInfo {
+	if d.queue == nil {
+		return nil
+	}
+	return d.queue.QueuePending
+}
+
+// bar draws a progress bar of value out of total that is width cells wide
+func bar(value, total float64, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = int(value / total * float64(width))
+	}
+	filled = min(max(filled, 0), width)
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", width-filled) + "]"
+}
+
+func statusColor(status string) string {
+	switch status {
+	case "success":
+		return "\x1b[32m" + fmt.Sprintf("%-11s", status) + "\x1b[0m"
+	case "error":
+		return "\x1b[31m" + fmt.Sprintf("%-11s", status) + "\x1b[0m"
+	case "interrupted":
+		return "\x1b[33m" + fmt.Sprintf("%-11s", status) + "\x1b[0m"
+	}
+	return fmt.Sprintf("%-11s", orDash(status))
+}
+
+// cut shortens line to width visible characters, escape sequences don't count
+func cut(line string, width int) string {
+	visible := 0
+	for i := 0; i < len(line); {
+		if line[i] == 0x1b {
+			end := strings.IndexFunc(line[i+1:], func(r rune) bool { return r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' })
+			if end < 0 {
+				return line
+			}
+			i += end + 2
+			continue
+		}
+		if visible == width {
+			return line[:i] + "\x1b[0m"
+		}
+		_, size := utf8.DecodeRuneInString(line[i:])
+		i += size
+		visible++
+	}
+	return line
+}
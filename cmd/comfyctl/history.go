@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+// historySummary is a prompt of the history as printed by comfyctl
+type historySummary struct {
+	PromptID  string                                                `json:"prompt_id"`
+	Number    float64                                               `json:"number"`
+	Status    string                                                `json:"status"`
+	Completed bool                                                  `json:"completed"`
+	StartedAt time.Time                                             `json:"started_at,omitempty"`
+	Duration  time.Duration                                         `json:"duration_ns,omitempty"`
+	Error     string                                                `json:"error,omitempty"`
+	Outputs   map[string]map[string][]*comfyUIclient.DataOutputFile `json:"outputs"`
+}
+
+func newHistorySummary(item *comfyUIclient.PromptHistoryItem) *historySummary {
+	summary := &historySummary{PromptID: item.PromptID, Outputs: make(map[string]map[string][]*comfyUIclient.DataOutputFile)}
+	if item.NodeInfo != nil {
+		summary.Number = item.NodeInfo.Number
+	}
+	for node, output := range item.Outputs {
+		if len(output.Files) != 0 {
+			summary.Outputs[node] = output.Files
+		}
+	}
+	if item.Status == nil {
+		return summary
+	}
+
+	summary.Status = item.Status.StatusStr
+	summary.Completed = item.Status.Completed
+	var started, ended int64
+	for _, recorded := range item.Status.Messages {
+		message, err := recorded.Message()
+		if err != nil {
+			continue
+		}
+		switch data := message.Data.(type) {
+		case *comfyUIclient.WSMessageDataExecutionStart:
+			started = data.Timestamp
+		case *comfyUIclient.WSMessageDataExecutionSuccess:
+			ended = data.Timestamp
+		case *comfyUIclient.WSMessageExecutionError:
+			ended = data.Timestamp
+			summary.Error = fmt.Sprintf("%s in node %s (%s): %s", data.ExceptionType, data.Node, data.NodeType, strings.TrimSpace(data.ExceptionMessage))
+		case *comfyUIclient.WSMessageExecutionInterrupted:
+			ended = data.Timestamp
+			summary.Status = "interrupted"
+			summary.Error = fmt.Sprintf("interrupted in node %s (%s)", data.NodeID, data.NodeType)
+		}
+	}
+	if started > 0 {
+		summary.StartedAt = time.UnixMilli(started)
+		if ended >= started {
+			summary.Duration = time.Duration(ended-started) * time.Millisecond
+		}
+	}
+	return summary
+}
+
+func (s *historySummary) outputCount() int {
+	count := 0
+	for _, outputs := range s.Outputs {
+		for _, files := range outputs {
+			count += len(files)
+		}
+	}
+	return count
+}
+
+// loadHistory returns the history, most recent prompt first
+func loadHistory(client *comfyUIclient.Client) ([]*historySummary, error) {
+	items, err := client.GetAllHistories()
+	if err != nil {
+		return nil, err
+	}
+	summaries := make([]*historySummary, 0, len(items))
+	for _, item := range items {
+		summaries = append(summaries, newHistorySummary(item))
+	}
+	// numbers of prompts queued in front are negative, the start time is the better order when known
+	sort.SliceStable(summaries, func(i, j int) bool {
+		if !summaries[i].StartedAt.Equal(summaries[j].StartedAt) && !summaries[i].StartedAt.IsZero() && !summaries[j].StartedAt.IsZero() {
+			return summaries[i].StartedAt.After(summaries[j].StartedAt)
+		}
+		return summaries[i].Number > summaries[j].Number
+	})
+	return summaries, nil
+}
+
+func runHistoryList(a *app, args []string) error {
+	fs := a.flagSet("history ls")
+	limit := fs.Int("limit", 20, "number of prompts to list, 0 lists all")
+	if _, err := a.parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	client, err := a.newClient()
+	if err != nil {
+		return err
+	}
+	summaries, err := loadHistory(client)
+	if err != nil {
+		return err
+	}
+	if *limit > 0 && len(summaries) > *limit {
+		summaries = summaries[:*limit]
+	}
+	return a.print(summaries, func(w io.Writer) {
+		row(w, "NUMBER", "PROMPT_ID", "STATUS", "STARTED", "DURATION", "OUTPUTS")
+		for _, summary := range summaries {
+			started := "-"
+			if !summary.StartedAt.IsZero() {
+				started = summary.StartedAt.Format(time.DateTime)
+			}
+			row(w, summary.Number, summary.PromptID, orDash(summary.Status), started, durationString(summary.Duration), summary.outputCount())
+		}
+	})
+}
+
+func runHistoryShow(a *app, args []string) error {
+	fs := a.flagSet("history show")
+	ids, err := a.parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	client, err := a.newClient()
+	if err != nil {
+		return err
+	}
+	item, err := client.GetHistoryByPromptID(ids[0])
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return fmt.Errorf("prompt %s not found in history", ids[0])
+	}
+
+	summary := newHistorySummary(item)
+	return a.print(summary, func(w io.Writer) {
+		row(w, "PROMPT_ID:", summary.PromptID)
+		row(w, "NUMBER:", summary.Number)
+		row(w, "STATUS:", orDash(summary.Status))
+		if !summary.StartedAt.IsZero() {
+			row(w, "STARTED:", summary.StartedAt.Format(time.DateTime))
+		}
+		row(w, "DURATION:", durationString(summary.Duration))
+		if summary.Error != "" {
+			row(w, "ERROR:", summary.Error)
+		}
+		if len(summary.Outputs) != 0 {
+			row(w)
+			writeOutputs(w, summary.Outputs)
+		}
+	})
+}
+
+func runHistoryRemove(a *app, args []string) error {
+	fs := a.flagSet("history rm")
+	all := fs.Bool("all", false, "clear the history")
+	ids, err := a.parseArgs(fs, args, 0, -1)
+	if err != nil {
+		return err
+	}
+	if *all == (len(ids) != 0) {
+		fs.Usage()
+		return errUsage
+	}
+	client, err := a.newClient()
+	if err != nil {
+		return err
+	}
+
+	if *all {
+		if err := client.DeleteAllHistories(); err != nil {
+			return err
+		}
+		return a.printDone("cleared history", map[string]interface{}{"cleared": true})
+	}
+	for _, id := range ids {
+		if err := client.DeleteHistoryByPromptID(id); err != nil {
+			return err
+		}
+	}
+	return a.printDone("removed "+strings.Join(ids, ", "), map[string]interface{}{"removed": ids})
+}
+
+type downloadedFile struct {
+	Node   string                        `json:"node"`
+	Output string                        `json:"output"`
+	File   *comfyUIclient.DataOutputFile `json:"file"`
+	Path   string                        `json:"path"`
+	Size   int                           `json:"size"`
+}
+
+func runDownload(a *app, args []string) error {
+	fs := a.flagSet("download")
+	dir := fs.String("dir", ".", "directory the files are written to")
+	node := fs.String("node", "", "only download the outputs of this node")
+	temp := fs.Bool("temp", false, "include temp files, e.g. of PreviewImage nodes")
+	ids, err := a.parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	client, err := a.newClient()
+	if err != nil {
+		return err
+	}
+	item, err := client.GetHistoryByPromptID(ids[0])
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return fmt.Errorf("prompt %s not found in history", ids[0])
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+
+	summary := newHistorySummary(item)
+	var downloaded []*downloadedFile
+	for _, nodeID := range sortedKeys(summary.Outputs) {
+		if *node != "" && nodeID != *node {
+			continue
+		}
+		for _, name := range sortedKeys(summary.Outputs[nodeID]) {
+			for _, file := range summary.Outputs[nodeID][name] {
+				if file.Type == string(comfyUIclient.TempImageType) && !*temp {
+					continue
+				}
+				data, err := client.GetFile(file)
+				if err != nil {
+					return fmt.Errorf("download %s: %w", file.Filename, err)
+				}
+				path := filepath.Join(*dir, localSubFolder(file.SubFolder), filepath.Base(file.Filename))
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					return err
+				}
+				if err := os.WriteFile(path, *data, 0o644); err != nil {
+					return err
+				}
+				downloaded = append(downloaded, &downloadedFile{Node: nodeID, Output: name, File: file, Path: path, Size: len(*data)})
+			}
+		}
+	}
+	return a.print(downloaded, func(w io.Writer) {
+		row(w, "NODE", "OUTPUT", "PATH", "SIZE")
+		for _, file := range downloaded {
+			row(w, file.Node, file.Output, file.Path, bytesString(int64(file.Size)))
+		}
+	})
+}
+
+// localSubFolder returns subFolder as a relative local path, subfolders leaving the download directory are dropped
+func localSubFolder(subFolder string) string {
+	local := filepath.Clean(filepath.FromSlash(subFolder))
+	if filepath.IsAbs(local) || local == ".." || strings.HasPrefix(local, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return local
+}
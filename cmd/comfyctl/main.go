@@ -0,0 +1,260 @@
+// Command comfyctl manages a ComfyUI server from the command line
+//
+// Usage:
+//
+//	comfyctl <command> [flags] [args]
+//
+// The server and credentials are read from flags, then COMFY_URL, COMFY_TOKEN, COMFY_USER and COMFY_CLIENT_ID,
+// then the selected profile of the config file, see config.go.
+//
+// ComfyUI only sends the execution events of a prompt to the client id that queued it,
+// so watch sees the execution of prompts queued with the same client id and only the queue status of others.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(a *app, args []string) error
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"queue submit", "<workflow.json|-> [--set node.input=value]...", "queue an api format workflow", runQueueSubmit},
+		{"queue ls", "", "list running and pending prompts", runQueueList},
+		{"queue rm", "<prompt_id>... | --all", "remove pending prompts", runQueueRemove},
+		{"interrupt", "", "interrupt the running prompt", runInterrupt},
+		{"history ls", "[--limit n]", "list finished prompts", runHistoryList},
+		{"history show", "<prompt_id>", "show the status and outputs of a prompt", runHistoryShow},
+		{"history rm", "<prompt_id>... | --all", "remove prompts from the history", runHistoryRemove},
+		{"nodes ls", "[--category prefix]", "list node classes", runNodesList},
+		{"nodes show", "<class_type>", "show the inputs and outputs of a node class", runNodesShow},
+		{"models ls", "[folder]", "list model folders, or the models in folder", runModelsList},
+		{"stats", "", "show system and gpu stats", runStats},
+		{"upload", "<image> [--subfolder dir] [--type input] [--overwrite] [--mask]", "upload an image", runUpload},
+		{"download", "<prompt_id> [--dir dir] [--node id]", "download the outputs of a prompt", runDownload},
+		{"watch", "[--prompt prompt_id]", "stream websocket events", runWatch},
+		{"dashboard", "[--refresh interval]", "interactive view of the queue, progress, history and gpus", runDashboard},
+	}
+}
+
+// errUsage is returned for invalid arguments, the usage of the command was already printed
+var errUsage = errors.New("usage")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	a := &app{ctx: ctx, stdout: os.Stdout, stderr: os.Stderr, stdin: os.Stdin}
+	if err := a.run(os.Args[1:]); err != nil {
+		if !errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, "comfyctl:", err)
+		}
+		if errors.Is(err, errUsage) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// app holds the state of one invocation
+type app struct {
+	ctx    context.Context
+	stdout io.Writer
+	stderr io.Writer
+	stdin  io.Reader
+
+	flags  globalFlags
+	config *config
+	client *comfyUIclient.Client
+}
+
+type globalFlags struct {
+	server   string
+	token    string
+	user     string
+	profile  string
+	output   string
+	clientID string
+	verbose  bool
+}
+
+func (a *app) run(args []string) error {
+	cmd, rest := findCommand(args)
+	if cmd == nil {
+		a.usage()
+		if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+			return nil
+		}
+		return errUsage
+	}
+	return cmd.run(a, rest)
+}
+
+// findCommand returns the command named by the leading words of args and the remaining args
+func findCommand(args []string) (*command, []string) {
+	var found *command
+	words := 0
+	for _, cmd := range commands {
+		name := strings.Fields(cmd.name)
+		if len(name) > len(args) || len(name) <= words {
+			continue
+		}
+		match := true
+		for i, word := range name {
+			if args[i] != word {
+				match = false
+				break
+			}
+		}
+		if match {
+			found, words = cmd, len(name)
+		}
+	}
+	if found == nil {
+		return nil, args
+	}
+	return found, args[words:]
+}
+
+func (a *app) usage() {
+	fmt.Fprintln(a.stderr, "Usage: comfyctl <command> [flags] [args]")
+	fmt.Fprintln(a.stderr)
+	fmt.Fprintln(a.stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(a.stderr, "  %-14s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(a.stderr)
+	fmt.Fprintln(a.stderr, "Run comfyctl <command> -h for the flags of a command.")
+	fmt.Fprintln(a.stderr, "Environment: COMFY_URL, COMFY_TOKEN, COMFY_USER, COMFY_CLIENT_ID, COMFYCTL_OUTPUT, COMFYCTL_PROFILE, COMFYCTL_CONFIG")
+}
+
+// flagSet returns the flags of cmd including the global flags
+func (a *app) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("comfyctl "+name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	fs.StringVar(&a.flags.server, "server", "", "ComfyUI base url, e.g. http://127.0.0.1:8188")
+	fs.StringVar(&a.flags.token, "token", "", "bearer token")
+	fs.StringVar(&a.flags.user, "user", "", "ComfyUI user sent as Comfy-User")
+	fs.StringVar(&a.flags.profile, "profile", "", "profile of the config file")
+	fs.StringVar(&a.flags.output, "o", "", "output format, table or json")
+	fs.StringVar(&a.flags.clientID, "client-id", "", "websocket client id, prompts queued with it can be followed by watch with the same id")
+	fs.BoolVar(&a.flags.verbose, "v", false, "log requests and websocket events to stderr")
+	fs.Usage = func() {
+		for _, cmd := range commands {
+			if cmd.name == name {
+				fmt.Fprintf(a.stderr, "Usage: comfyctl %s [flags] %s\n\n%s\n\nFlags:\n", cmd.name, cmd.args, cmd.summary)
+			}
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses flags and positional args in any order, everything after "--" is positional
+func parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		// the flag package already printed the error and the usage
+		if err := fs.Parse(args); err != nil {
+			return nil, errUsage
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// parseArgs parses args and checks the number of positional args is between min and max, max < 0 is unlimited
+func (a *app) parseArgs(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	positional, err := parse(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(positional) < min || (max >= 0 && len(positional) > max) {
+		fs.Usage()
+		return nil, errUsage
+	}
+	if err := a.loadConfig(); err != nil {
+		return nil, err
+	}
+	return positional, nil
+}
+
+// newClient returns the client of the configured server, events are only received after connect
+func (a *app) newClient() (*comfyUIclient.Client, error) {
+	if a.client != nil {
+		return a.client, nil
+	}
+	var authenticators []comfyUIclient.Authenticator
+	if a.config.Token != "" {
+		authenticators = append(authenticators, comfyUIclient.NewBearerAuth(a.config.Token))
+	}
+	if a.config.User != "" {
+		authenticators = append(authenticators, comfyUIclient.NewComfyUserAuth(a.config.User))
+	}
+	opts := []comfyUIclient.Option{
+		comfyUIclient.WithUserAgent("comfyctl"),
+		comfyUIclient.WithTimeout(a.config.timeout()),
+		comfyUIclient.WithEventBufferSize(-1),
+		comfyUIclient.WithTransportMode(comfyUIclient.TransportAuto),
+		comfyUIclient.WithLogger(nil),
+	}
+	if a.config.ClientID != "" {
+		opts = append(opts, comfyUIclient.WithClientID(a.config.ClientID))
+	}
+	if len(authenticators) != 0 {
+		opts = append(opts, comfyUIclient.WithAuthenticator(comfyUIclient.ChainAuth(authenticators...)))
+	}
+	if a.flags.verbose {
+		opts = append(opts, comfyUIclient.WithLogger(slog.New(slog.NewTextHandler(a.stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	}
+
+	client, err := comfyUIclient.New(a.config.Server, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("comfyUIclient.New: error: %w", err)
+	}
+	a.client = client
+	return client, nil
+}
+
+// connect starts receiving events and waits until the client is connected
+func (a *app) connect(client *comfyUIclient.Client) error {
+	client.ConnectAndListen()
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(a.config.timeout())
+	for !client.IsInitialized() {
+		select {
+		case <-a.ctx.Done():
+			return a.ctx.Err()
+		case <-timeout:
+			return fmt.Errorf("can't connect to %s", a.config.Server)
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
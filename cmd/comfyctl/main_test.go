@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+// isolate clears the environment and points the config file at path
+func isolate(t *testing.T, path string) {
+	t.Helper()
+	for _, name := range []string{"COMFY_URL", "COMFY_TOKEN", "COMFY_USER", "COMFY_CLIENT_ID", "COMFYCTL_OUTPUT", "COMFYCTL_PROFILE", "COMFYCTL_TIMEOUT"} {
+		t.Setenv(name, "")
+	}
+	if path == "" {
+		path = filepath.Join(t.TempDir(), "missing.json")
+	}
+	t.Setenv("COMFYCTL_CONFIG", path)
+}
+
+// runApp runs comfyctl with args and returns what it printed
+func runApp(t *testing.T, args ...string) (stdout, stderr string, err error) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	a := &app{ctx: ctx, stdout: &out, stderr: &errOut, stdin: strings.NewReader("")}
+	err = a.run(args)
+	if a.client != nil {
+		a.client.Close()
+	}
+	return out.String(), errOut.String(), err
+}
+
+func TestFindCommand(t *testing.T) {
+	tests := []struct {
+		args []string
+		name string
+		rest []string
+	}{
+		{args: []string{"queue", "ls"}, name: "queue ls", rest: []string{}},
+		{args: []string{"queue", "rm", "a", "--all"}, name: "queue rm", rest: []string{"a", "--all"}},
+		{args: []string{"stats", "-o", "json"}, name: "stats", rest: []string{"-o", "json"}},
+		{args: []string{"history", "show", "ls"}, name: "history show", rest: []string{"ls"}},
+		{args: []string{"queue"}},
+		{args: []string{"queue", "list"}},
+		{args: []string{"--server", "x", "stats"}},
+		{},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			cmd, rest := findCommand(tt.args)
+			if tt.name == "" {
+				if cmd != nil {
+					t.Fatalf("found %q, want none", cmd.name)
+				}
+				return
+			}
+			if cmd == nil || cmd.name != tt.name {
+				t.Fatalf("found %v, want %q", cmd, tt.name)
+			}
+			if !reflect.DeepEqual(rest, tt.rest) {
+				t.Fatalf("rest = %q, want %q", rest, tt.rest)
+			}
+		})
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		min, max   int
+		positional []string
+		server     string
+		wantErr    bool
+	}{
+		{name: "flags first", args: []string{"--server", "http://a", "id"}, min: 1, max: 1, positional: []string{"id"}, server: "http://a"},
+		{name: "flags last", args: []string{"id", "-server=http://b"}, min: 1, max: 1, positional: []string{"id"}, server: "http://b"},
+		{name: "interleaved", args: []string{"a", "-v", "b"}, min: 0, max: -1, positional: []string{"a", "b"}},
+		{name: "after dashes", args: []string{"a", "--", "-v", "b"}, min: 0, max: -1, positional: []string{"a", "-v", "b"}},
+		{name: "too few", args: []string{}, min: 1, max: 1, wantErr: true},
+		{name: "too many", args: []string{"a", "b"}, min: 0, max: 1, wantErr: true},
+		{name: "unknown flag", args: []string{"--nope"}, min: 0, max: 0, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolate(t, "")
+			var stderr bytes.Buffer
+			a := &app{stderr: &stderr}
+			positional, err := a.parseArgs(a.flagSet("queue rm"), tt.args, tt.min, tt.max)
+			if tt.wantErr {
+				if !errors.Is(err, errUsage) {
+					t.Fatalf("err = %v, want errUsage", err)
+				}
+				if !strings.Contains(stderr.String(), "Usage: comfyctl queue rm") {
+					t.Fatalf("stderr = %q, want the usage", stderr.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseArgs: %v", err)
+			}
+			if len(positional) != 0 || len(tt.positional) != 0 {
+				if !reflect.DeepEqual(positional, tt.positional) {
+					t.Fatalf("positional = %q, want %q", positional, tt.positional)
+				}
+			}
+			if want := firstNonEmpty(tt.server, defaultServer); a.config.Server != want {
+				t.Fatalf("server = %q, want %q", a.config.Server, want)
+			}
+		})
+	}
+}
+
+func TestUsage(t *testing.T) {
+	isolate(t, "")
+	if _, stderr, err := runApp(t, "help"); err != nil || !strings.Contains(stderr, "queue submit") {
+		t.Fatalf("help: err %v, stderr %q", err, stderr)
+	}
+	if _, stderr, err := runApp(t, "nope"); !errors.Is(err, errUsage) || !strings.Contains(stderr, "Usage: comfyctl") {
+		t.Fatalf("unknown command: err %v, stderr %q", err, stderr)
+	}
+	// --all and ids exclude each other
+	if _, _, err := runApp(t, "queue", "rm", "id", "--all"); !errors.Is(err, errUsage) {
+		t.Fatalf("queue rm id --all: err %v, want errUsage", err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{
+		"default_profile": "prod",
+		"profiles": {
+			"prod": {"server": "https://prod", "token": "prod-token", "output": "json", "timeout": "5s"},
+			"local": {"server": "http://local"}
+		}
+	}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		flags   globalFlags
+		want    config
+		wantErr bool
+	}{
+		{name: "default profile", want: config{Server: "https://prod", Token: "prod-token", Output: "json", Timeout: "5s"}},
+		{name: "selected profile", env: map[string]string{"COMFYCTL_PROFILE": "local"}, want: config{Server: "http://local", Output: "table"}},
+		{name: "flag profile", env: map[string]string{"COMFYCTL_PROFILE": "prod"}, flags: globalFlags{profile: "local"}, want: config{Server: "http://local", Output: "table"}},
+		{name: "environment over profile", env: map[string]string{"COMFY_URL": "http://env", "COMFY_USER": "alice"}, want: config{Server: "http://env", Token: "prod-token", User: "alice", Output: "json", Timeout: "5s"}},
+		{name: "flags over environment", env: map[string]string{"COMFY_URL": "http://env"}, flags: globalFlags{server: "http://flag", output: "table"}, want: config{Server: "http://flag", Token: "prod-token", Output: "table", Timeout: "5s"}},
+		{name: "missing profile", flags: globalFlags{profile: "staging"}, wantErr: true},
+		{name: "unknown output", flags: globalFlags{output: "yaml"}, wantErr: true},
+		{name: "invalid timeout", env: map[string]string{"COMFYCTL_TIMEOUT": "soon"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolate(t, path)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			a := &app{flags: tt.flags}
+			err := a.loadConfig()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("loadConfig succeeded with %+v, want an error", a.config)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfig: %v", err)
+			}
+			if *a.config != tt.want {
+				t.Fatalf("config = %+v, want %+v", *a.config, tt.want)
+			}
+		})
+	}
+
+	isolate(t, "")
+	if _, err := loadProfile(filepath.Join(t.TempDir(), "missing.json"), "prod"); err == nil {
+		t.Fatal("loadProfile found a profile without a config file")
+	}
+}
+
+func TestApplySet(t *testing.T) {
+	tests := []struct {
+		set     string
+		input   string
+		want    interface{}
+		wantErr bool
+	}{
+		{set: "1.seed=42", input: "seed", want: float64(42)},
+		{set: "1.text=a cat", input: "text", want: "a cat"},
+		{set: `1.text="quoted"`, input: "text", want: "quoted"},
+		{set: `1.image=["2",0]`, input: "image", want: []interface{}{"2", float64(0)}},
+		{set: "1.empty=", input: "empty", want: ""},
+		{set: "1.seed", wantErr: true},
+		{set: "seed=1", wantErr: true},
+		{set: ".seed=1", wantErr: true},
+		{set: "9.seed=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.set, func(t *testing.T) {
+			prompt := comfyUIclient.Prompt{"1": {ClassType: "KSampler"}}
+			err := applySet(prompt, tt.set)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("applySet succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applySet: %v", err)
+			}
+			if got := prompt["1"].Inputs[tt.input]; !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("%s = %#v, want %#v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadWorkflow(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "api format", data: `{"1": {"class_type": "SaveImage", "inputs": {}}}`},
+		{name: "ui format", data: `{"nodes": [], "links": []}`, wantErr: "ui format"},
+		{name: "invalid json", data: `{`, wantErr: "parse workflow"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &app{stdin: strings.NewReader(tt.data)}
+			prompt, err := a.readWorkflow("-")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readWorkflow: %v", err)
+			}
+			if prompt["1"].ClassType != "SaveImage" {
+				t.Fatalf("prompt = %v", prompt)
+			}
+		})
+	}
+}
+
+func TestPrint(t *testing.T) {
+	v := map[string]interface{}{"prompt_id": "a", "number": 1}
+	table := func(w io.Writer) {
+		row(w, "PROMPT_ID", "NUMBER")
+		row(w, "a-long-prompt-id", 1)
+		row(w, "b", 20)
+	}
+
+	var out bytes.Buffer
+	a := &app{stdout: &out, config: &config{Output: "table"}}
+	if err := a.print(v, table); err != nil {
+		t.Fatalf("print: %v", err)
+	}
+	want := "PROMPT_ID         NUMBER\na-long-prompt-id  1\nb                 20\n"
+	if out.String() != want {
+		t.Fatalf("table:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	a.config.Output = "json"
+	if err := a.print(v, table); err != nil {
+		t.Fatalf("print: %v", err)
+	}
+	if want := "{\n  \"number\": 1,\n  \"prompt_id\": \"a\"\n}\n"; out.String() != want {
+		t.Fatalf("json:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	tests := []struct {
+		got, want string
+	}{
+		{got: bytesString(512), want: "512 B"},
+		{got: bytesString(1536), want: "1.5 KiB"},
+		{got: bytesString(8 << 30), want: "8.0 GiB"},
+		{got: durationString(0), want: "-"},
+		{got: durationString(1234567 * time.Microsecond), want: "1.2s"},
+		{got: durationString(12345 * time.Microsecond), want: "12ms"},
+		{got: orDash(""), want: "-"},
+		{got: orDash("input"), want: "input"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+}
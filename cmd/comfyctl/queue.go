@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+// stringList collects a repeated flag
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+func runQueueSubmit(a *app, args []string) error {
+	fs := a.flagSet("queue submit")
+	var sets stringList
+	fs.Var(&sets, "set", "set an input as node.input=value, the value is parsed as json when possible (repeatable)")
+	front := fs.Bool("front", false, "queue in front of the pending prompts")
+	promptID := fs.String("id", "", "prompt id, generated when empty")
+	wait := fs.Bool("wait", false, "wait until the prompt finished and print its outputs")
+	positional, err := a.parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	prompt, err := a.readWorkflow(positional[0])
+	if err != nil {
+		return err
+	}
+	for _, set := range sets {
+		if err := applySet(prompt, set); err != nil {
+			return err
+		}
+	}
+
+	client, err := a.newClient()
+	if err != nil {
+		return err
+	}
+	opts := &comfyUIclient.QueueOptions{PromptID: *promptID, Front: *front}
+	if opts.PromptID == "" {
+		opts.PromptID = comfyUIclient.NewPromptID()
+	}
+	var done chan *comfyUIclient.WSMessageDataCompleted
+	if *wait {
+		done = make(chan *comfyUIclient.WSMessageDataCompleted, 1)
+		client.AddListener(func(message *comfyUIclient.WSMessage) {
+			if data, ok := message.Data.(*comfyUIclient.WSMessageDataCompleted); ok && data.PromptID == opts.PromptID {
+				done <- data
+			}
+		})
+		if err := a.connect(client); err != nil {
+			return err
+		}
+	}
+
+	resp, err := client.QueuePromptByNodesWithOptions(a.ctx, prompt, opts)
+	var rejected *comfyUIclient.PromptRejectedError
+	if errors.As(err, &rejected) {
+		a.printNodeErrors(rejected.NodeErrors)
+		return fmt.Errorf("the server rejected the prompt: %s", orDash(rejected.Message))
+	}
+	if err != nil {
+		return err
+	}
+	if resp.PromptID == "" || len(resp.NodeErrors) != 0 {
+		a.printNodeErrors(resp.NodeErrors)
+		return errors.New("the server rejected the prompt")
+	}
+	if !*wait {
+		return a.print(resp, func(w io.Writer) {
+			row(w, "PROMPT_ID", "NUMBER")
+			row(w, resp.PromptID, resp.Number)
+		})
+	}
+
+	select {
+	case <-a.ctx.Done():
+		return a.ctx.Err()
+	case completed := <-done:
+		if err := a.printCompleted(completed); err != nil {
+			return err
+		}
+		if completed.Status != comfyUIclient.ExecutionSuccess {
+			return fmt.Errorf("prompt %s finished with %s", completed.PromptID, completed.Status)
+		}
+		return nil
+	}
+}
+
+func (a *app) printNodeErrors(nodeErrors map[string]interface{}) {
+	if len(nodeErrors) == 0 {
+		return
+	}
+	_ = a.print(nodeErrors, func(w io.Writer) {
+		row(w, "NODE", "ERRORS")
+		for _, id := range sortedKeys(nodeErrors) {
+			data, _ := json.Marshal(nodeErrors[id])
+			row(w, id, string(data))
+		}
+	})
+}
+
+func (a *app) printCompleted(completed *comfyUIclient.WSMessageDataCompleted) error {
+	return a.print(completed, func(w io.Writer) {
+		row(w, "PROMPT_ID", "STATUS", "DURATION", "EXECUTED", "CACHED")
+		row(w, completed.PromptID, completed.Status, durationString(completed.Duration()), len(completed.ExecutedNodes), len(completed.CachedNodes))
+		if completed.Error != nil {
+			row(w)
+			row(w, "ERROR_NODE", "TYPE", "EXCEPTION", "MESSAGE")
+			row(w, completed.Error.Node, completed.Error.NodeType, completed.Error.ExceptionType, strings.TrimSpace(completed.Error.ExceptionMessage))
+		}
+		if len(completed.Outputs) != 0 {
+			row(w)
+			writeOutputs(w, completed.Outputs)
+		}
+	})
+}
+
+func writeOutputs(w io.Writer, outputs map[string]map[string][]*comfyUIclient.DataOutputFile) {
+	row(w, "NODE", "OUTPUT", "FILENAME", "SUBFOLDER", "TYPE")
+	for _, node := range sortedKeys(outputs) {
+		for _, name := range sortedKeys(outputs[node]) {
+			for _, file := range outputs[node][name] {
+				row(w, node, name, file.Filename, orDash(file.SubFolder), file.Type)
+			}
+		}
+	}
+}
+
+// readWorkflow reads an api format workflow from name, or from stdin when name is "-"
+func (a *app) readWorkflow(name string) (comfyUIclient.Prompt, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(a.stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read workflow: %w", err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("parse workflow: %w", err)
+	}
+	if _, ok := fields["nodes"]; ok {
+		if _, ok := fields["links"]; ok {
+			return nil, errors.New("the workflow is in ui format, export it in api format instead")
+		}
+	}
+	var prompt comfyUIclient.Prompt
+	if err := json.Unmarshal(data, &prompt); err != nil {
+		return nil, fmt.Errorf("parse workflow: %w", err)
+	}
+	return prompt, nil
+}
+
+// applySet sets an input given as node.input=value, values that aren't valid json are used as strings
+func applySet(prompt comfyUIclient.Prompt, set string) error {
+	key, raw, ok := strings.Cut(set, "=")
+	if !ok {
+		return fmt.Errorf("invalid --set %q, want node.input=value", set)
+	}
+	nodeID, input, ok := strings.Cut(key, ".")
+	if !ok || nodeID == "" || input == "" {
+		return fmt.Errorf("invalid --set %q, want node.input=value", set)
+	}
+	node, ok := prompt[nodeID]
+	if !ok {
+		return fmt.Errorf("invalid --set %q: node %s not found in workflow", set, nodeID)
+	}
+
+	var value interface{} = raw
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(raw), &parsed); err == nil {
+		value = parsed
+	}
+	if node.Inputs == nil {
+		node.Inputs = make(map[string]interface{})
+	}
+	node.Inputs[input] = value
+	prompt[nodeID] = node
+	return nil
+}
+
+type queueEntry struct {
+	State       string   `json:"state"`
+	Number      float64  `json:"number"`
+	PromptID    string   `json:"prompt_id"`
+	ClientID    string   `json:"client_id,omitempty"`
+	Nodes       int      `json:"nodes"`
+	OutputNodes []string `json:"output_nodes"`
+}
+
+func newQueueEntry(state string, info *comfyUIclient.NodeInfo) *queueEntry {
+	var extraData struct {
+		ClientID string `json:"client_id"`
+	}
+	_ = json.Unmarshal(info.ExtraData, &extraData)
+	return &queueEntry{
+		State:       state,
+		Number:      info.Number,
+		PromptID:    info.PromptID,
+		ClientID:    extraData.ClientID,
+		Nodes:       len(info.Prompt),
+		OutputNodes: info.OutputNodeIDs,
+	}
+}
+
+func runQueueList(a *app, args []string) error {
+	fs := a.flagSet("queue ls")
+	if _, err := a.parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	client, err := a.newClient()
+	if err != nil {
+		return err
+	}
+	info, err := client.GetQueueInfo()
+	if err != nil {
+		return err
+	}
+
+	entries := make([]*queueEntry, 0, len(info.QueueRunning)+len(info.QueuePending))
+	for _, item := range info.QueueRunning {
+		entries = append(entries, newQueueEntry("running", item))
+	}
+	pending := append([]*comfyUIclient.NodeInfo(nil), info.QueuePending...)
+	sort.SliceStable(pending, func(i, j int) bool { return pending[i].Number < pending[j].Number })
+	for _, item := range pending {
+		entries = append(entries, newQueueEntry("pending", item))
+	}
+	return a.print(entries, func(w io.Writer) {
+		row(w, "STATE", "NUMBER", "PROMPT_ID", "NODES", "OUTPUT_NODES", "CLIENT_ID")
+		for _, entry := range entries {
+			row(w, entry.State, entry.Number, entry.PromptID, entry.Nodes, strings.Join(entry.OutputNodes, ","), orDash(entry.ClientID))
+		}
+	})
+}
+
+func runQueueRemove(a *app, args []string) error {
+	fs := a.flagSet("queue rm")
+	all := fs.Bool("all", false, "remove all pending prompts")
+	ids, err := a.parseArgs(fs, args, 0, -1)
+	if err != nil {
+		return err
+	}
+	if *all == (len(ids) != 0) {
+		fs.Usage()
+		return errUsage
+	}
+	client, err := a.newClient()
+	if err != nil {
+		return err
+	}
+
+	if *all {
+		if err := client.DeleteAllQueues(); err != nil {
+			return err
+		}
+		return a.printDone("cleared queue", map[string]interface{}{"cleared": true})
+	}
+	for _, id := range ids {
+		if err := client.DeleteQueueByPromptID(id); err != nil {
+			return err
+		}
+	}
+	return a.printDone("removed "+strings.Join(ids, ", "), map[string]interface{}{"removed": ids})
+}
+
+func runInterrupt(a *app, args []string) error {
+	fs := a.flagSet("interrupt")
+	if _, err := a.parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	client, err := a.newClient()
+	if err != nil {
+		return err
+	}
+	if err := client.InterruptExecution(); err != nil {
+		return err
+	}
+	return a.printDone("interrupted", map[string]interface{}{"interrupted": true})
+}
+
+// printDone prints message for the table format and v for json
+func (a *app) printDone(message string, v interface{}) error {
+	return a.print(v, func(w io.Writer) {
+		fmt.Fprintln(w, message)
+	})
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/XdpCs/comfyUIclient/comfytest"
+)
+
+const workflow = `{
+	"1": {"class_type": "EmptyLatentImage", "inputs": {"width": 64, "height": 64, "batch_size": 1}},
+	"2": {"class_type": "SaveImage", "inputs": {"images": ["1", 0], "filename_prefix": "comfyctl"}}
+}`
+
+// newServer starts a fake ComfyUI server and returns it with the path of the test workflow
+func newServer(t *testing.T) (*comfytest.Server, string) {
+	t.Helper()
+	isolate(t, "")
+	server := comfytest.NewServer()
+	t.Cleanup(server.Close)
+	path := filepath.Join(t.TempDir(), "workflow.json")
+	if err := os.WriteFile(path, []byte(workflow), 0o600); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	return server, path
+}
+
+// submit queues the test workflow and returns its prompt id
+func submit(t *testing.T, server *comfytest.Server, path string, args ...string) string {
+	t.Helper()
+	stdout, _, err := runApp(t, append([]string{"queue", "submit", path, "--server", server.URL, "-o", "json"}, args...)...)
+	if err != nil {
+		t.Fatalf("queue submit: %v", err)
+	}
+	var resp struct {
+		PromptID string `json:"prompt_id"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &resp); err != nil || resp.PromptID == "" {
+		t.Fatalf("queue submit printed %q", stdout)
+	}
+	return resp.PromptID
+}
+
+// pending returns the prompt ids listed by queue ls
+func pending(t *testing.T, server *comfytest.Server) []string {
+	t.Helper()
+	stdout, _, err := runApp(t, "queue", "ls", "--server", server.URL, "-o", "json")
+	if err != nil {
+		t.Fatalf("queue ls: %v", err)
+	}
+	var entries []*queueEntry
+	if err := json.Unmarshal([]byte(stdout), &entries); err != nil {
+		t.Fatalf("queue ls printed %q: %v", stdout, err)
+	}
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.State == "pending" {
+			ids = append(ids, entry.PromptID)
+		}
+	}
+	return ids
+}
+
+func TestQueueSubmit(t *testing.T) {
+	server, path := newServer(t)
+	server.Pause()
+	defer server.Resume()
+
+	id := submit(t, server, path, "--id", "fixed-id", "--set", "1.width=128", "--set", "2.filename_prefix=cat")
+	if id != "fixed-id" {
+		t.Fatalf("prompt id = %q, want fixed-id", id)
+	}
+	var body struct {
+		Prompt map[string]struct {
+			Inputs map[string]interface{} `json:"inputs"`
+		} `json:"prompt"`
+	}
+	for _, request := range server.Requests() {
+		if request.Method == "POST" && request.Path == "/prompt" {
+			if err := json.Unmarshal(request.Body, &body); err != nil {
+				t.Fatalf("json.Unmarshal: %v", err)
+			}
+		}
+	}
+	if body.Prompt["1"].Inputs["width"] != float64(128) || body.Prompt["2"].Inputs["filename_prefix"] != "cat" {
+		t.Fatalf("queued prompt = %+v, want the --set inputs", body.Prompt)
+	}
+}
+
+func TestQueueSubmitWait(t *testing.T) {
+	server, path := newServer(t)
+
+	stdout, _, err := runApp(t, "queue", "submit", path, "--wait", "--server", server.URL)
+	if err != nil {
+		t.Fatalf("queue submit --wait: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) < 5 || !strings.HasPrefix(lines[0], "PROMPT_ID") || !strings.Contains(lines[1], "success") {
+		t.Fatalf("queue submit --wait printed:\n%s", stdout)
+	}
+	if fields := strings.Fields(lines[len(lines)-1]); len(fields) != 5 || fields[0] != "2" || fields[1] != "images" || fields[3] != "-" || fields[4] != "output" {
+		t.Fatalf("output row = %q, want node 2 images with an empty subfolder", lines[len(lines)-1])
+	}
+
+	server.Script(&comfytest.Behavior{ErrorAt: "1", ExceptionType: "RuntimeError", ExceptionMessage: "out of memory"})
+	stdout, _, err = runApp(t, "queue", "submit", path, "--wait", "--server", server.URL)
+	if err == nil || !strings.Contains(err.Error(), "error") {
+		t.Fatalf("failed prompt: err = %v", err)
+	}
+	if !strings.Contains(stdout, "ERROR_NODE") || !strings.Contains(stdout, "out of memory") {
+		t.Fatalf("failed prompt printed:\n%s", stdout)
+	}
+}
+
+func TestQueueSubmitRejected(t *testing.T) {
+	server, path := newServer(t)
+	server.Script(&comfytest.Behavior{NodeErrors: map[string]interface{}{
+		"2": map[string]interface{}{"errors": []interface{}{map[string]interface{}{"type": "required_input_missing"}}},
+	}})
+
+	stdout, _, err := runApp(t, "queue", "submit", path, "--server", server.URL)
+	if err == nil {
+		t.Fatal("queue submit succeeded, want the rejection")
+	}
+	if !strings.HasPrefix(stdout, "NODE") || !strings.Contains(stdout, "required_input_missing") {
+		t.Fatalf("queue submit printed:\n%s", stdout)
+	}
+	unknown := filepath.Join(t.TempDir(), "unknown.json")
+	if err := os.WriteFile(unknown, []byte(`{"1": {"class_type": "NoSuchNode", "inputs": {}}}`), 0o600); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	if _, _, err := runApp(t, "queue", "submit", unknown, "--server", server.URL); err == nil || !strings.Contains(err.Error(), "NoSuchNode does not exist") {
+		t.Fatalf("unknown node: err = %v, want the message of the server", err)
+	}
+}
+
+func TestQueueListAndRemove(t *testing.T) {
+	server, path := newServer(t)
+	server.Pause()
+	defer server.Resume()
+	ids := []string{submit(t, server, path), submit(t, server, path), submit(t, server, path, "--front")}
+
+	// the prompt queued in front is listed first
+	if got := pending(t, server); len(got) != 3 || got[0] != ids[2] || got[1] != ids[0] || got[2] != ids[1] {
+		t.Fatalf("pending = %v, want %v", got, []string{ids[2], ids[0], ids[1]})
+	}
+	stdout, _, err := runApp(t, "queue", "ls", "--server", server.URL)
+	if err != nil {
+		t.Fatalf("queue ls: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 4 || strings.Join(strings.Fields(lines[0]), " ") != "STATE NUMBER PROMPT_ID NODES OUTPUT_NODES CLIENT_ID" {
+		t.Fatalf("queue ls printed:\n%s", stdout)
+	}
+	if fields := strings.Fields(lines[2]); fields[0] != "pending" || fields[2] != ids[0] || fields[3] != "2" || fields[4] != "2" {
+		t.Fatalf("row = %q", lines[2])
+	}
+
+	stdout, _, err = runApp(t, "queue", "rm", ids[0], ids[2], "--server", server.URL)
+	if err != nil {
+		t.Fatalf("queue rm: %v", err)
+	}
+	if want := "removed " + ids[0] + ", " + ids[2] + "\n"; stdout != want {
+		t.Fatalf("queue rm printed %q, want %q", stdout, want)
+	}
+	if got := pending(t, server); len(got) != 1 || got[0] != ids[1] {
+		t.Fatalf("pending = %v, want [%s]", got, ids[1])
+	}
+
+	stdout, _, err = runApp(t, "queue", "rm", "--all", "--server", server.URL, "-o", "json")
+	if err != nil {
+		t.Fatalf("queue rm --all: %v", err)
+	}
+	if strings.TrimSpace(stdout) != "{\n  \"cleared\": true\n}" {
+		t.Fatalf("queue rm --all printed %q", stdout)
+	}
+	if got := pending(t, server); len(got) != 0 {
+		t.Fatalf("pending = %v, want none", got)
+	}
+}
+
+func TestQueueUnreachableServer(t *testing.T) {
+	isolate(t, "")
+	_, _, err := runApp(t, "queue", "ls", "--server", "http://127.0.0.1:1")
+	if err == nil || errors.Is(err, errUsage) {
+		t.Fatalf("err = %v, want a connection error", err)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+type nodeSummary struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Category    string `json:"category"`
+	OutputNode  bool   `json:"output_node"`
+}
+
+func runNodesList(a *app, args []string) error {
+	fs := a.flagSet("nodes ls")
+	category := fs.String("category", "", "only list nodes whose category starts with this prefix")
+	if _, err := a.parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	client, err := a.newClient()
+	if err != nil {
+		return err
+	}
+	infos, err := client.GetObjectInfos()
+	if err != nil {
+		return err
+	}
+
+	nodes := make([]*nodeSummary, 0, len(infos))
+	for name, info := range infos {
+		if info == nil || !strings.HasPrefix(info.Category, *category) {
+			continue
+		}
+		nodes = append(nodes, &nodeSummary{Name: name, DisplayName: info.DisplayName, Category: info.Category, OutputNode: info.OutputNode})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Category != nodes[j].Category {
+			return nodes[i].Category < nodes[j].Category
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+	return a.print(nodes, func(w io.Writer) {
+		row(w, "NAME", "CATEGORY", "OUTPUT", "DISPLAY_NAME")
+		for _, node := range nodes {
+			output := ""
+			if node.OutputNode {
+				output = "yes"
+			}
+			row(w, node.Name, orDash(node.Category), orDash(output), node.DisplayName)
+		}
+	})
+}
+
+func runNodesShow(a *app, args []string) error {
+	fs := a.flagSet("nodes show")
+	names, err := a.parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	client, err := a.newClient()
+	if err != nil {
+		return err
+	}
+	info, err := client.GetObjectInfoByNodeName(names[0])
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return fmt.Errorf("node class %s not found", names[0])
+	}
+
+	return a.print(info, func(w io.Writer) {
+		row(w, "NAME:", info.Name)
+		row(w, "DISPLAY_NAME:", info.DisplayName)
+		row(w, "CATEGORY:", orDash(info.Category))
+		row(w, "OUTPUT_NODE:", info.OutputNode)
+		if info.Description != "" {
+			row(w, "DESCRIPTION:", info.Description)
+		}
+		row(w)
+		row(w, "INPUT", "REQUIRED", "TYPE")
+		if info.Input != nil {
+			for _, name := range sortedKeys(info.Input.Required) {
+				row(w, name, "yes", inputType(info.Input.Required[name]))
+			}
+			for _, name := range sortedKeys(info.Input.Optional) {
+				row(w, name, "no", inputType(info.Input.Optional[name]))
+			}
+		}
+		row(w)
+		row(w, "OUTPUT", "NAME", "LIST")
+		for i, output := range info.Output {
+			name, list := output, false
+			if i < len(info.OutputName) {
+				name = info.OutputName[i]
+			}
+			if i < len(info.OutputIsList) {
+				list = info.OutputIsList[i]
+			}
+			row(w, output, name, list)
+		}
+	})
+}
+
+// inputType describes an input spec like ["INT", {...}] or [["a", "b"]], a list of choices
+func inputType(spec interface{}) string {
+	list, ok := spec.([]interface{})
+	if !ok || len(list) == 0 {
+		return "-"
+	}
+	switch first := list[0].(type) {
+	case string:
+		return first
+	case []interface{}:
+		choices := make([]string, 0, len(first))
+		for _, choice := range first {
+			choices = append(choices, fmt.Sprint(choice))
+		}
+		const maxChoices = 5
+		if len(choices) > maxChoices {
+			return fmt.Sprintf("COMBO[%s, ... %d more]", strings.Join(choices[:maxChoices], ", "), len(choices)-maxChoices)
+		}
+		return "COMBO[" + strings.Join(choices, ", ") + "]"
+	}
+	return fmt.Sprint(list[0])
+}
+
+func runModelsList(a *app, args []string) error {
+	fs := a.flagSet("models ls")
+	folders, err := a.parseArgs(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+	client, err := a.newClient()
+	if err != nil {
+		return err
+	}
+
+	if len(folders) == 0 {
+		names, err := client.GetModelFolders()
+		if err != nil {
+			return err
+		}
+		return a.print(names, func(w io.Writer) {
+			row(w, "FOLDER")
+			for _, name := range names {
+				row(w, name)
+			}
+		})
+	}
+	models, err := client.GetModels(folders[0])
+	if err != nil {
+		return err
+	}
+	return a.print(models, func(w io.Writer) {
+		row(w, "MODEL")
+		for _, model := range models {
+			row(w, model)
+		}
+	})
+}
+
+func runStats(a *app, args []string) error {
+	fs := a.flagSet("stats")
+	if _, err := a.parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	client, err := a.newClient()
+	if err != nil {
+		return err
+	}
+	stats, err := client.GetSystemStats()
+	if err != nil {
+		return err
+	}
+
+	return a.print(stats, func(w io.Writer) {
+		if stats.System != nil {
+			row(w, "OS:", stats.System.OS)
+			row(w, "PYTHON:", strings.Fields(stats.System.PythonVersion + " -")[0])
+			row(w)
+		}
+		row(w, "INDEX", "DEVICE", "TYPE", "VRAM_USED", "VRAM_FREE", "VRAM_TOTAL", "TORCH_FREE", "TORCH_TOTAL")
+		for _, gpu := range stats.Devices {
+			row(w, gpu.Index, gpu.Name, gpu.Type, bytesString(gpu.VRAMTotal-gpu.VRAMFree), bytesString(gpu.VRAMFree), bytesString(gpu.VRAMTotal),
+				bytesString(gpu.TorchVRAMFree), bytesString(gpu.TorchVRAMTotal))
+		}
+	})
+}
+
+func runUpload(a *app, args []string) error {
+	fs := a.flagSet("upload")
+	subFolder := fs.String("subfolder", "", "subfolder on the server")
+	fileType := fs.String("type", string(comfyUIclient.InputImageType), "input, temp or output")
+	overwrite := fs.Bool("overwrite", false, "overwrite an existing file instead of renaming the upload")
+	mask := fs.Bool("mask", false, "upload as mask")
+	name := fs.String("name", "", "file name on the server, the local name when empty")
+	paths, err := a.parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	client, err := a.newClient()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(paths[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fileName := *name
+	if fileName == "" {
+		fileName = filepath.Base(paths[0])
+	}
+
+	upload := client.UploadImage
+	if *mask {
+		upload = client.UploadMask
+	}
+	uploaded, err := upload(f, fileName, *overwrite, comfyUIclient.ImageType(*fileType), *subFolder)
+	if err != nil {
+		return err
+	}
+	return a.print(uploaded, func(w io.Writer) {
+		row(w, "NAME", "SUBFOLDER", "TYPE")
+		row(w, uploaded.Filename, orDash(uploaded.SubFolder), uploaded.Type)
+	})
+}
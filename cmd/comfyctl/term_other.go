@@ -0,0 +1,24 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoTerminal = errors.New("the dashboard is not supported on this platform")
+
+type terminalState struct{}
+
+func makeRaw(f *os.File) (*terminalState, error) {
+	return nil, errNoTerminal
+}
+
+func restore(f *os.File, state *terminalState) error {
+	return errNoTerminal
+}
+
+func terminalSize(f *os.File) (int, int, error) {
+	return 0, 0, errNoTerminal
+}
@@ -0,0 +1,57 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// terminalState is the terminal mode to restore after makeRaw
+type terminalState struct {
+	termios syscall.Termios
+}
+
+// makeRaw puts the terminal f into raw mode, keys are read one by one without echo
+func makeRaw(f *os.File) (*terminalState, error) {
+	var termios syscall.Termios
+	if err := ioctl(f.Fd(), ioctlGetTermios, unsafe.Pointer(&termios)); err != nil {
+		return nil, err
+	}
+	state := &terminalState{termios: termios}
+
+	termios.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	termios.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	termios.Cflag &^= syscall.CSIZE | syscall.PARENB
+	termios.Cflag |= syscall.CS8
+	termios.Cc[syscall.VMIN] = 1
+	termios.Cc[syscall.VTIME] = 0
+	if err := ioctl(f.Fd(), ioctlSetTermios, unsafe.Pointer(&termios)); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// restore resets the terminal f to state
+func restore(f *os.File, state *terminalState) error {
+	return ioctl(f.Fd(), ioctlSetTermios, unsafe.Pointer(&state.termios))
+}
+
+// terminalSize returns the columns and rows of the terminal f
+func terminalSize(f *os.File) (int, int, error) {
+	var size struct {
+		rows, cols, x, y uint16
+	}
+	if err := ioctl(f.Fd(), syscall.TIOCGWINSZ, unsafe.Pointer(&size)); err != nil {
+		return 0, 0, err
+	}
+	return int(size.cols), int(size.rows), nil
+}
+
+func ioctl(fd uintptr, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+// watchEvent is a line of the json output of watch
+type watchEvent struct {
+	Time time.Time                   `json:"time"`
+	Type comfyUIclient.WsMessageType `json:"type"`
+	Data interface{}                 `json:"data"`
+}
+
+type previewSummary struct {
+	Format   string `json:"format"`
+	Size     int    `json:"size"`
+	PromptID string `json:"prompt_id,omitempty"`
+	NodeID   string `json:"node_id,omitempty"`
+}
+
+func runWatch(a *app, args []string) error {
+	fs := a.flagSet("watch")
+	promptID := fs.String("prompt", "", "only show events of this prompt and exit once it completed")
+	if _, err := a.parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	client, err := a.newClient()
+	if err != nil {
+		return err
+	}
+
+	events := make(chan *watchEvent, 256)
+	client.AddListener(func(message *comfyUIclient.WSMessage) {
+		if *promptID != "" && message.PromptID() != *promptID {
+			return
+		}
+		events <- &watchEvent{Time: time.Now(), Type: message.Type, Data: message.Data}
+	})
+	if err := a.connect(client); err != nil {
+		return err
+	}
+	if a.config.Output == "table" {
+		fmt.Fprintf(a.stderr, "watching %s, press ctrl-c to stop\n", a.config.Server)
+	}
+
+	encoder := json.NewEncoder(a.stdout)
+	for {
+		select {
+		case <-a.ctx.Done():
+			return nil
+		case event := <-events:
+			if preview, ok := event.Data.(*comfyUIclient.WSMessageDataPreviewImage); ok {
+				event.Data = &previewSummary{Format: preview.Format, Size: len(preview.Image), PromptID: preview.PromptID, NodeID: preview.NodeID}
+			}
+			if a.config.Output == "json" {
+				if err := encoder.Encode(event); err != nil {
+					return err
+				}
+			} else {
+				fmt.Fprintf(a.stdout, "%s  %-22s %s\n", event.Time.Format("15:04:05.000"), event.Type, describeEvent(event.Data))
+			}
+			if completed, ok := event.Data.(*comfyUIclient.WSMessageDataCompleted); ok && *promptID != "" && completed.PromptID == *promptID {
+				return nil
+			}
+		}
+	}
+}
+
+// describeEvent returns a one line description of the data of a message
+func describeEvent(data interface{}) string {
+	switch data := data.(type) {
+	case *comfyUIclient.WSMessageDataStatus:
+		return fmt.Sprintf("queue_remaining=%d", data.Status.ExecInfo.QueueRemaining)
+	case *comfyUIclient.WSMessageDataExecutionStart:
+		return "prompt=" + data.PromptID
+	case *comfyUIclient.WSMessageDataExecutionCached:
+		return fmt.Sprintf("prompt=%s nodes=%s", data.PromptID, strings.Join(data.Nodes, ","))
+	case *comfyUIclient.WSMessageDataExecuting:
+		if data.Node == "" {
+			return fmt.Sprintf("prompt=%s done", data.PromptID)
+		}
+		return fmt.Sprintf("prompt=%s node=%s", data.PromptID, data.Node)
+	case *comfyUIclient.WSMessageDataProgress:
+		return fmt.Sprintf("prompt=%s node=%s %d/%d", data.PromptID, data.Node, data.Value, data.Max)
+	case *comfyUIclient.WSMessageDataExecuted:
+		return fmt.Sprintf("prompt=%s node=%s outputs=%s", data.PromptID, data.Node, strings.Join(sortedKeys(data.Output), ","))
+	case *comfyUIclient.WSMessageExecutionInterrupted:
+		return fmt.Sprintf("prompt=%s node=%s", data.PromptID, data.NodeID)
+	case *comfyUIclient.WSMessageExecutionError:
+		return fmt.Sprintf("prompt=%s node=%s %s: %s", data.PromptID, data.Node, data.ExceptionType, strings.TrimSpace(data.ExceptionMessage))
+	case *comfyUIclient.WSMessageDataExecutionSuccess:
+		return "prompt=" + data.PromptID
+	case *comfyUIclient.WSMessageDataProgressState:
+		return fmt.Sprintf("prompt=%s nodes=%d", data.PromptID, len(data.Nodes))
+	case *previewSummary:
+		return fmt.Sprintf("prompt=%s node=%s %s %s", orDash(data.PromptID), orDash(data.NodeID), data.Format, bytesString(int64(data.Size)))
+	case *comfyUIclient.WSMessageDataProgressText:
+		return fmt.Sprintf("node=%s %s", data.NodeID, data.Text)
+	case *comfyUIclient.WSMessageDataCompleted:
+		return fmt.Sprintf("prompt=%s status=%s duration=%s executed=%d cached=%d", data.PromptID, data.Status, durationString(data.Duration()), len(data.ExecutedNodes), len(data.CachedNodes))
+	case json.RawMessage:
+		return string(data)
+	case []byte:
+		return bytesString(int64(len(data)))
+	}
+	return fmt.Sprint(data)
+}
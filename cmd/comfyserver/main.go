@@ -0,0 +1,225 @@
+// Command comfyserver serves the job api of package server in front of one or more ComfyUI servers
+//
+// Usage:
+//
+//	comfyserver -config comfyserver.json
+//
+// The config file looks like
+//
+//	{
+//	  "addr": ":8080",
+//	  "base_url": "https://api.example.com",
+//	  "backends": [{"url": "http://127.0.0.1:8188", "token": "", "user": ""}],
+//	  "templates": "templates",
+//	  "tenants": [{"id": "web", "api_keys": ["..."], "max_active_jobs": 8}],
+//	  "job_retention": "1h",
+//	  "metrics_addr": ":9090"
+//	}
+//
+// templates is a directory of template json files, relative paths are resolved from the directory of the config file.
+// metrics_addr serves the Prometheus metrics of package metrics at /metrics without authentication, it is disabled when empty.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/XdpCs/comfyUIclient/metrics"
+	"github.com/XdpCs/comfyUIclient/server"
+)
+
+type config struct {
+	Addr         string           `json:"addr"`
+	BaseURL      string           `json:"base_url"`
+	Backends     []backendConfig  `json:"backends"`
+	Templates    string           `json:"templates"`
+	Tenants      []*server.Tenant `json:"tenants"`
+	JobRetention string           `json:"job_retention"`
+	MetricsAddr  string           `json:"metrics_addr"`
+}
+
+type backendConfig struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+	User  string `json:"user"`
+}
+
+func main() {
+	configPath := flag.String("config", "comfyserver.json", "path of the config file")
+	addr := flag.String("addr", "", "listen address, overrides addr of the config file")
+	verbose := flag.Bool("v", false, "log debug messages")
+	flag.Parse()
+
+	level := slog.LevelInfo
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, *configPath, *addr, logger); err != nil {
+		logger.Error("comfyserver failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, configPath, addr string, logger *slog.Logger) error {
+	cfg, err := readConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if addr != "" {
+		cfg.Addr = addr
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = ":8080"
+	}
+
+	var retention time.Duration
+	if cfg.JobRetention != "" {
+		if retention, err = time.ParseDuration(cfg.JobRetention); err != nil {
+			return fmt.Errorf("job_retention: %w", err)
+		}
+	}
+
+	templateDir := cfg.Templates
+	if templateDir == "" {
+		templateDir = "templates"
+	}
+	if !filepath.IsAbs(templateDir) {
+		templateDir = filepath.Join(filepath.Dir(configPath), templateDir)
+	}
+	templates, err := server.LoadTemplates(templateDir)
+	if err != nil {
+		return err
+	}
+
+	var collector *metrics.Collector
+	if cfg.MetricsAddr != "" {
+		collector = metrics.New(nil)
+	}
+	var backends []*comfyUIclient.Client
+	for _, backend := range cfg.Backends {
+		var opts []comfyUIclient.Option
+		if collector != nil {
+			opts = collector.ClientOptions(backend.URL)
+		}
+		client, err := newClient(backend, logger, opts...)
+		if err != nil {
+			return err
+		}
+		if collector != nil {
+			collector.Register(backend.URL, client)
+		}
+		client.ConnectAndListen()
+		backends = append(backends, client)
+	}
+
+	s, err := server.New(&server.Options{
+		Backends:     backends,
+		Templates:    templates,
+		Tenants:      cfg.Tenants,
+		BaseURL:      cfg.BaseURL,
+		JobRetention: retention,
+		Logger:       logger,
+	})
+	if err != nil {
+		return fmt.Errorf("server.New: error: %w", err)
+	}
+	defer s.Close()
+
+	httpServer := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errCh := make(chan error, 2)
+	go func() {
+		errCh <- httpServer.ListenAndServe()
+	}()
+	var metricsServer *http.Server
+	if collector != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", collector)
+		metricsServer = &http.Server{
+			Addr:              cfg.MetricsAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			errCh <- metricsServer.ListenAndServe()
+		}()
+		logger.Info("serving metrics", "addr", cfg.MetricsAddr)
+	}
+	logger.Info("comfyserver listening", "addr", cfg.Addr, "backends", len(backends), "templates", len(templates), "tenants", len(cfg.Tenants))
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if metricsServer != nil {
+		_ = metricsServer.Shutdown(shutdownCtx)
+	}
+	if err := httpServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("httpServer.Shutdown: error: %w", err)
+	}
+	return nil
+}
+
+func readConfig(path string) (*config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: error: %w", err)
+	}
+	cfg := &config{}
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: error: %w, file: %s", err, path)
+	}
+	if len(cfg.Backends) == 0 {
+		return nil, fmt.Errorf("%s: no backends", path)
+	}
+	if len(cfg.Tenants) == 0 {
+		return nil, fmt.Errorf("%s: no tenants", path)
+	}
+	return cfg, nil
+}
+
+func newClient(backend backendConfig, logger *slog.Logger, extra ...comfyUIclient.Option) (*comfyUIclient.Client, error) {
+	var authenticators []comfyUIclient.Authenticator
+	if backend.Token != "" {
+		authenticators = append(authenticators, comfyUIclient.NewBearerAuth(backend.Token))
+	}
+	if backend.User != "" {
+		authenticators = append(authenticators, comfyUIclient.NewComfyUserAuth(backend.User))
+	}
+	opts := []comfyUIclient.Option{
+		comfyUIclient.WithUserAgent("comfyserver"),
+		comfyUIclient.WithEventBufferSize(-1),
+		comfyUIclient.WithTransportMode(comfyUIclient.TransportAuto),
+		comfyUIclient.WithLogger(logger),
+	}
+	if len(authenticators) != 0 {
+		opts = append(opts, comfyUIclient.WithAuthenticator(comfyUIclient.ChainAuth(authenticators...)))
+	}
+	client, err := comfyUIclient.New(backend.URL, append(opts, extra...)...)
+	if err != nil {
+		return nil, fmt.Errorf("comfyUIclient.New: error: %w", err)
+	}
+	return client, nil
+}
@@ -0,0 +1,152 @@
+package comfytest
+
+import (
+	"net/http"
+	"path"
+	"time"
+)
+
+// Behavior scripts how the server handles a queued prompt, the zero value runs the prompt successfully without delays
+type Behavior struct {
+	// NodeErrors rejects the prompt with 400 and these node_errors, like a prompt failing validation
+	NodeErrors map[string]interface{}
+	// StartDelay is waited after the prompt was taken from the queue, before execution_start
+	StartDelay time.Duration
+	// NodeDelay is waited for every executed node
+	NodeDelay time.Duration
+	// StepDelay is waited for every progress step
+	StepDelay time.Duration
+	// Steps is the number of progress steps of nodes with a steps input, the input value when zero
+	Steps int
+	// Previews sends a binary preview image with every progress step
+	Previews bool
+	// Cached are node ids reported in execution_cached, they are not executed but keep their outputs in the history
+	Cached []string
+	// ErrorAt is the node id raising an execution_error
+	ErrorAt string
+	// ExceptionType and ExceptionMessage describe the execution error, "RuntimeError" and a generic message when empty
+	ExceptionType    string
+	ExceptionMessage string
+	// InterruptAt is the node id at which the prompt is interrupted, like POST /interrupt while it runs
+	InterruptAt string
+	// DisconnectAt is the node id before which the websocket connections of the prompt's client are closed
+	// Messages sent until the client reconnects are lost, like with a real network failure
+	DisconnectAt string
+}
+
+// SetDefaultBehavior sets the behavior of prompts without a scripted behavior
+func (s *Server) SetDefaultBehavior(behavior *Behavior) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if behavior == nil {
+		behavior = &Behavior{}
+	}
+	s.behavior = behavior
+}
+
+// Script queues behaviors for the next prompts, in the order they are posted to /prompt
+// A nil behavior uses the default behavior
+func (s *Server) Script(behaviors ...*Behavior) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = append(s.script, behaviors...)
+}
+
+// nextBehavior pops the next scripted behavior, s.mu must be held
+func (s *Server) nextBehavior() *Behavior {
+	if len(s.script) == 0 {
+		return s.behavior
+	}
+	behavior := s.script[0]
+	s.script = s.script[1:]
+	if behavior == nil {
+		return s.behavior
+	}
+	return behavior
+}
+
+// Fault makes requests fail or slow down before they are handled
+type Fault struct {
+	// Method matches the request method, any method when empty
+	Method string
+	// Path is a path.Match pattern of the route without the /api prefix, e.g. "/prompt" or "/history/*"
+	Path string
+	// Delay is waited before the request is handled or failed
+	Delay time.Duration
+	// Status fails the request with this status code, the request is handled after Delay when zero
+	Status int
+	// Body is the response body of a failed request, the status text when empty
+	Body string
+	// Header is added to the response of a failed request, e.g. Retry-After
+	Header http.Header
+	// Times is how many requests the fault applies to, every request when zero
+	Times int
+}
+
+// AddFault adds a fault, faults are matched in the order they are added
+func (s *Server) AddFault(fault *Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, fault)
+}
+
+// ClearFaults removes all faults
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// matchFault returns the first fault matching the request and uses it up, s.mu must be held
+func (s *Server) matchFault(method, routePath string) *Fault {
+	for i, fault := range s.faults {
+		if fault.Method != "" && fault.Method != method {
+			continue
+		}
+		if ok, _ := path.Match(fault.Path, routePath); !ok {
+			continue
+		}
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				s.faults = append(s.faults[:i:i], s.faults[i+1:]...)
+			}
+		}
+		return fault
+	}
+	return nil
+}
+
+func writeFault(w http.ResponseWriter, fault *Fault) {
+	for name, values := range fault.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	body := fault.Body
+	if body == "" {
+		body = http.StatusText(fault.Status)
+	}
+	w.WriteHeader(fault.Status)
+	_, _ = w.Write([]byte(body))
+}
+
+// sleep waits d and reports false when done was closed first
+func sleep(d time.Duration, done <-chan struct{}) bool {
+	if d <= 0 {
+		select {
+		case <-done:
+			return false
+		default:
+			return true
+		}
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
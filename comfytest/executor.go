@@ -0,0 +1,423 @@
+package comfytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/XdpCs/comfyUIclient/graph"
+)
+
+type queuedPrompt struct {
+	number    float64
+	promptID  string
+	clientID  string
+	prompt    comfyUIclient.Prompt
+	extraData map[string]interface{}
+	outputs   []string
+	order     []string
+	behavior  *Behavior
+
+	interrupted   chan struct{}
+	interruptOnce sync.Once
+}
+
+func (p *queuedPrompt) queueItem() []interface{} {
+	return []interface{}{p.number, p.promptID, p.prompt, p.extraData, p.outputs}
+}
+
+func (p *queuedPrompt) interrupt() {
+	p.interruptOnce.Do(func() { close(p.interrupted) })
+}
+
+func (p *queuedPrompt) isInterrupted() bool {
+	select {
+	case <-p.interrupted:
+		return true
+	default:
+		return false
+	}
+}
+
+type historyEntry struct {
+	Prompt  []interface{}                     `json:"prompt"`
+	Outputs map[string]map[string]interface{} `json:"outputs"`
+	Status  historyStatus                     `json:"status"`
+	Meta    map[string]interface{}            `json:"meta"`
+}
+
+type historyStatus struct {
+	StatusStr string          `json:"status_str"`
+	Completed bool            `json:"completed"`
+	Messages  [][]interface{} `json:"messages"`
+}
+
+type promptRequest struct {
+	Prompt                  comfyUIclient.Prompt   `json:"prompt"`
+	ClientID                string                 `json:"client_id"`
+	PromptID                string                 `json:"prompt_id"`
+	ExtraData               map[string]interface{} `json:"extra_data"`
+	Front                   bool                   `json:"front"`
+	Number                  *float64               `json:"number"`
+	PartialExecutionTargets []string               `json:"partial_execution_targets"`
+}
+
+// postPrompt validates and queues a prompt, rejected prompts get the same error bodies as from ComfyUI
+func (s *Server) postPrompt(w http.ResponseWriter, body []byte) {
+	var request promptRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		writePromptError(w, "invalid_prompt", err.Error(), "", []interface{}{})
+		return
+	}
+	if len(request.Prompt) == 0 {
+		writePromptError(w, "no_prompt", "No prompt provided", "No prompt provided", []interface{}{})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range sortedIDs(request.Prompt) {
+		classType := request.Prompt[id].ClassType
+		if classType == "" {
+			writePromptError(w, "invalid_prompt", "Cannot execute because a node is missing the class_type property.", fmt.Sprintf("Node ID '#%s'", id), map[string]interface{}{})
+			return
+		}
+		if _, ok := s.nodes[classType]; !ok {
+			writePromptError(w, "invalid_prompt", fmt.Sprintf("Cannot execute because node %s does not exist.", classType), fmt.Sprintf("Node ID '#%s'", id), map[string]interface{}{})
+			return
+		}
+	}
+	g, err := graph.New(request.Prompt)
+	if err != nil {
+		writePromptError(w, "invalid_prompt", err.Error(), "", map[string]interface{}{})
+		return
+	}
+	order, err := g.TopologicalOrder()
+	if err != nil {
+		writePromptError(w, "invalid_prompt", err.Error(), "", map[string]interface{}{})
+		return
+	}
+	outputs := g.OutputNodes(s.nodes)
+	if len(request.PartialExecutionTargets) != 0 {
+		outputs = request.PartialExecutionTargets
+	}
+	if len(outputs) == 0 {
+		writePromptError(w, "prompt_no_outputs", "Prompt has no outputs", "", []interface{}{})
+		return
+	}
+
+	behavior := s.nextBehavior()
+	if len(behavior.NodeErrors) != 0 {
+		writePromptError(w, "prompt_outputs_failed_validation", "Prompt outputs failed validation", "", behavior.NodeErrors)
+		return
+	}
+
+	used := make(map[string]bool)
+	for _, id := range g.Upstream(outputs...) {
+		used[id] = true
+	}
+	prompt := &queuedPrompt{
+		number:      float64(s.number),
+		promptID:    request.PromptID,
+		clientID:    request.ClientID,
+		prompt:      request.Prompt,
+		extraData:   request.ExtraData,
+		outputs:     outputs,
+		behavior:    behavior,
+		interrupted: make(chan struct{}),
+	}
+	if request.Front {
+		prompt.number = -prompt.number
+	}
+	if request.Number != nil {
+		prompt.number = *request.Number
+	}
+	s.number++
+	if prompt.promptID == "" {
+		prompt.promptID = comfyUIclient.NewPromptID()
+	}
+	for _, id := range order {
+		if used[id] {
+			prompt.order = append(prompt.order, id)
+		}
+	}
+
+	i := sort.Search(len(s.pending), func(i int) bool { return s.pending[i].number > prompt.number })
+	s.pending = append(s.pending, nil)
+	copy(s.pending[i+1:], s.pending[i:])
+	s.pending[i] = prompt
+	s.queued.Broadcast()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"prompt_id": prompt.promptID, "number": prompt.number, "node_errors": map[string]interface{}{}})
+	go s.sendStatus("")
+}
+
+func writePromptError(w http.ResponseWriter, errorType, message, details string, nodeErrors interface{}) {
+	writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+		"error": map[string]interface{}{
+			"type":       errorType,
+			"message":    message,
+			"details":    details,
+			"extra_info": map[string]interface{}{},
+		},
+		"node_errors": nodeErrors,
+	})
+}
+
+// work runs the queued prompts one at a time in number order
+func (s *Server) work() {
+	defer close(s.workerDone)
+	for {
+		s.mu.Lock()
+		for !s.closed && (s.paused || len(s.pending) == 0) {
+			s.queued.Wait()
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		prompt := s.pending[0]
+		s.pending = s.pending[1:]
+		s.running = prompt
+		s.mu.Unlock()
+
+		s.execute(prompt)
+
+		s.mu.Lock()
+		s.running = nil
+		s.mu.Unlock()
+		s.sendStatus("")
+	}
+}
+
+// run tracks the messages and outputs of an executing prompt
+type run struct {
+	s        *Server
+	prompt   *queuedPrompt
+	messages [][]interface{}
+	outputs  map[string]map[string]interface{}
+	executed []string
+}
+
+// send sends a message to the prompt's client and records it for the history when it is an execution state message
+func (r *run) send(messageType comfyUIclient.WsMessageType, data map[string]interface{}, record bool) {
+	if record {
+		r.messages = append(r.messages, []interface{}{messageType, data})
+	}
+	r.s.send(r.prompt.clientID, messageType, data)
+}
+
+func (s *Server) execute(prompt *queuedPrompt) {
+	behavior := prompt.behavior
+	r := &run{s: s, prompt: prompt, outputs: make(map[string]map[string]interface{})}
+	if !s.wait(behavior.StartDelay, prompt) {
+		return
+	}
+
+	r.send(comfyUIclient.ExecutionStart, map[string]interface{}{"prompt_id": prompt.promptID, "timestamp": timestamp()}, true)
+	cachedSet := make(map[string]bool, len(behavior.Cached))
+	for _, id := range behavior.Cached {
+		cachedSet[id] = true
+	}
+	cached := []string{}
+	for _, id := range prompt.order {
+		if cachedSet[id] {
+			cached = append(cached, id)
+		}
+	}
+	r.send(comfyUIclient.ExecutionCached, map[string]interface{}{"nodes": cached, "prompt_id": prompt.promptID, "timestamp": timestamp()}, true)
+	for _, id := range cached {
+		if s.isOutputNode(prompt.prompt[id].ClassType) {
+			r.outputs[id] = s.output(prompt.prompt[id])
+		}
+	}
+
+	for _, id := range prompt.order {
+		if cachedSet[id] {
+			continue
+		}
+		node := prompt.prompt[id]
+		if behavior.DisconnectAt == id {
+			s.disconnect(prompt.clientID)
+		}
+		if behavior.InterruptAt == id {
+			prompt.interrupt()
+		}
+		r.send(comfyUIclient.Executing, map[string]interface{}{"node": id, "display_node": id, "prompt_id": prompt.promptID}, false)
+
+		steps := behavior.steps(node)
+		for step := 1; step <= steps && !prompt.isInterrupted(); step++ {
+			if !s.wait(behavior.StepDelay, prompt) {
+				return
+			}
+			r.send(comfyUIclient.Progress, map[string]interface{}{"value": step, "max": steps, "prompt_id": prompt.promptID, "node": id}, false)
+			if behavior.Previews {
+				s.sendBinary(prompt.clientID, previewFrame(id, step))
+			}
+		}
+		if !s.wait(behavior.NodeDelay, prompt) {
+			return
+		}
+
+		if prompt.isInterrupted() {
+			r.finish("error", comfyUIclient.ExecutionInterrupted, map[string]interface{}{
+				"prompt_id": prompt.promptID,
+				"node_id":   id,
+				"node_type": node.ClassType,
+				"executed":  r.executedNodes(cached),
+				"timestamp": timestamp(),
+			})
+			return
+		}
+		if behavior.ErrorAt == id {
+			exceptionType, exceptionMessage := behavior.ExceptionType, behavior.ExceptionMessage
+			if exceptionType == "" {
+				exceptionType = "RuntimeError"
+			}
+			if exceptionMessage == "" {
+				exceptionMessage = fmt.Sprintf("comfytest: scripted failure in node %s", id)
+			}
+			r.finish("error", comfyUIclient.ExecutionError, map[string]interface{}{
+				"prompt_id":         prompt.promptID,
+				"node_id":           id,
+				"node_type":         node.ClassType,
+				"executed":          r.executedNodes(cached),
+				"exception_message": exceptionMessage,
+				"exception_type":    exceptionType,
+				"traceback":         []string{fmt.Sprintf("%s: %s\n", exceptionType, exceptionMessage)},
+				"current_inputs":    node.Inputs,
+				"current_outputs":   map[string]interface{}{},
+				"timestamp":         timestamp(),
+			})
+			return
+		}
+
+		r.executed = append(r.executed, id)
+		if s.isOutputNode(node.ClassType) {
+			output := s.output(node)
+			r.outputs[id] = output
+			r.send(comfyUIclient.Executed, map[string]interface{}{"node": id, "display_node": id, "output": output, "prompt_id": prompt.promptID}, false)
+		}
+	}
+	r.finish("success", comfyUIclient.ExecutionSuccess, map[string]interface{}{"prompt_id": prompt.promptID, "timestamp": timestamp()})
+}
+
+// executedNodes returns the cached and executed nodes, ComfyUI reports both as executed
+func (r *run) executedNodes(cached []string) []string {
+	return append(append([]string{}, cached...), r.executed...)
+}
+
+// finish sends the final message, stores the history entry and then sends executing with a null node
+// ComfyUI does the same, the history is only complete once the executing message arrives
+func (r *run) finish(statusStr string, messageType comfyUIclient.WsMessageType, data map[string]interface{}) {
+	r.messages = append(r.messages, []interface{}{messageType, data})
+	prompt := r.prompt
+	meta := make(map[string]interface{}, len(r.outputs))
+	for id := range r.outputs {
+		meta[id] = map[string]interface{}{"node_id": id, "display_node": id, "parent_node": nil, "real_node_id": id}
+	}
+	entry := &historyEntry{
+		Prompt:  prompt.queueItem(),
+		Outputs: r.outputs,
+		Status: historyStatus{
+			StatusStr: statusStr,
+			Completed: statusStr == "success",
+			Messages:  r.messages,
+		},
+		Meta: meta,
+	}
+
+	s := r.s
+	s.send(prompt.clientID, messageType, data)
+	s.mu.Lock()
+	if _, ok := s.history[prompt.promptID]; !ok {
+		s.order = append(s.order, prompt.promptID)
+	}
+	s.history[prompt.promptID] = entry
+	s.mu.Unlock()
+
+	s.send(prompt.clientID, comfyUIclient.Executing, map[string]interface{}{"node": nil, "prompt_id": prompt.promptID})
+}
+
+// wait waits d or until prompt is interrupted, it reports false when the server was closed
+func (s *Server) wait(d time.Duration, prompt *queuedPrompt) bool {
+	timer := time.NewTimer(max(d, 0))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-prompt.interrupted:
+	case <-s.done:
+		return false
+	}
+	select {
+	case <-s.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// steps returns the number of progress steps of node
+func (b *Behavior) steps(node comfyUIclient.PromptNode) int {
+	value, ok := node.Inputs["steps"]
+	if !ok {
+		return 0
+	}
+	if b.Steps > 0 {
+		return b.Steps
+	}
+	steps, ok := value.(float64)
+	if !ok || steps < 0 {
+		return 0
+	}
+	return int(min(steps, 10000))
+}
+
+func (s *Server) isOutputNode(classType string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	node, ok := s.nodes[classType]
+	return ok && node.OutputNode
+}
+
+// output generates the placeholder image of an output node and stores it for /view
+// PreviewImage nodes save temp files, other output nodes save output files
+func (s *Server) output(node comfyUIclient.PromptNode) map[string]interface{} {
+	s.mu.Lock()
+	s.counter++
+	file := &comfyUIclient.DataOutputFile{Filename: fmt.Sprintf("ComfyUI_%05d_.png", s.counter), Type: "output"}
+	if node.ClassType == "PreviewImage" {
+		file = &comfyUIclient.DataOutputFile{Filename: fmt.Sprintf("ComfyUI_temp_%05d_.png", s.counter), Type: "temp"}
+	}
+	if prefix, ok := node.Inputs["filename_prefix"].(string); ok && prefix != "" && file.Type == "output" {
+		file.Filename = fmt.Sprintf("%s_%05d_.png", prefix, s.counter)
+	}
+	width, height := s.imageSize[0], s.imageSize[1]
+	s.mu.Unlock()
+
+	s.AddFile(file, Placeholder(nodeSeed(node), width, height))
+	return map[string]interface{}{"images": []*comfyUIclient.DataOutputFile{file}}
+}
+
+// nodeSeed derives the placeholder seed from the class and inputs, so the same node always renders the same image
+func nodeSeed(node comfyUIclient.PromptNode) string {
+	inputs, _ := json.Marshal(node.Inputs)
+	return node.ClassType + string(inputs)
+}
+
+func timestamp() int64 {
+	return time.Now().UnixMilli()
+}
+
+func sortedIDs(prompt comfyUIclient.Prompt) []string {
+	ids := make([]string, 0, len(prompt))
+	for id := range prompt {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,47 @@
+package comfytest
+
+import (
+	"bytes"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// Placeholder returns a png of the given size whose colors are derived from seed
+// The same seed and size always produce the same bytes
+func Placeholder(seed string, width, height int) []byte {
+	width, height = max(width, 1), max(height, 1)
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(seed))
+	sum := hash.Sum64()
+	from := color.RGBA{R: byte(sum), G: byte(sum >> 8), B: byte(sum >> 16), A: 0xFF}
+	to := color.RGBA{R: byte(sum >> 24), G: byte(sum >> 32), B: byte(sum >> 40), A: 0xFF}
+	// a checker of 1/8 of the image makes scaling and cropping bugs visible
+	cell := max(min(width, height)/8, 1)
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			t := (x + y) * 255 / max(width+height-2, 1)
+			c := color.RGBA{
+				R: mix(from.R, to.R, t),
+				G: mix(from.G, to.G, t),
+				B: mix(from.B, to.B, t),
+				A: 0xFF,
+			}
+			if (x/cell+y/cell)%2 == 1 {
+				c.R, c.G, c.B = c.R/2+64, c.G/2+64, c.B/2+64
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func mix(a, b byte, t int) byte {
+	return byte((int(a)*(255-t) + int(b)*t) / 255)
+}
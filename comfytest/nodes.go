@@ -0,0 +1,90 @@
+package comfytest
+
+import "github.com/XdpCs/comfyUIclient"
+
+// DefaultNodes returns the node classes of the default text to image and image to image workflows
+func DefaultNodes() map[string]*comfyUIclient.NodeObject {
+	return map[string]*comfyUIclient.NodeObject{
+		"CheckpointLoaderSimple": newNode("CheckpointLoaderSimple", "Load Checkpoint", "loaders", map[string]interface{}{
+			"ckpt_name": []interface{}{[]string{"comfytest.safetensors"}},
+		}, []string{"MODEL", "CLIP", "VAE"}, false),
+		"CLIPTextEncode": newNode("CLIPTextEncode", "CLIP Text Encode (Prompt)", "conditioning", map[string]interface{}{
+			"text": []interface{}{"STRING", map[string]interface{}{"multiline": true}},
+			"clip": []interface{}{"CLIP"},
+		}, []string{"CONDITIONING"}, false),
+		"EmptyLatentImage": newNode("EmptyLatentImage", "Empty Latent Image", "latent", map[string]interface{}{
+			"width":      []interface{}{"INT", map[string]interface{}{"default": 512, "min": 16, "max": 16384, "step": 8}},
+			"height":     []interface{}{"INT", map[string]interface{}{"default": 512, "min": 16, "max": 16384, "step": 8}},
+			"batch_size": []interface{}{"INT", map[string]interface{}{"default": 1, "min": 1, "max": 4096}},
+		}, []string{"LATENT"}, false),
+		"KSampler": newNode("KSampler", "KSampler", "sampling", map[string]interface{}{
+			"model":        []interface{}{"MODEL"},
+			"seed":         []interface{}{"INT", map[string]interface{}{"default": 0, "min": 0, "max": uint64(0xffffffffffffffff)}},
+			"steps":        []interface{}{"INT", map[string]interface{}{"default": 20, "min": 1, "max": 10000}},
+			"cfg":          []interface{}{"FLOAT", map[string]interface{}{"default": 8.0, "min": 0.0, "max": 100.0}},
+			"sampler_name": []interface{}{[]string{"euler", "euler_ancestral", "dpmpp_2m"}},
+			"scheduler":    []interface{}{[]string{"normal", "karras", "simple"}},
+			"positive":     []interface{}{"CONDITIONING"},
+			"negative":     []interface{}{"CONDITIONING"},
+			"latent_image": []interface{}{"LATENT"},
+			"denoise":      []interface{}{"FLOAT", map[string]interface{}{"default": 1.0, "min": 0.0, "max": 1.0}},
+		}, []string{"LATENT"}, false),
+		"VAEDecode": newNode("VAEDecode", "VAE Decode", "latent", map[string]interface{}{
+			"samples": []interface{}{"LATENT"},
+			"vae":     []interface{}{"VAE"},
+		}, []string{"IMAGE"}, false),
+		"VAEEncode": newNode("VAEEncode", "VAE Encode", "latent", map[string]interface{}{
+			"pixels": []interface{}{"IMAGE"},
+			"vae":    []interface{}{"VAE"},
+		}, []string{"LATENT"}, false),
+		"LoadImage": newNode("LoadImage", "Load Image", "image", map[string]interface{}{
+			"image": []interface{}{[]string{}, map[string]interface{}{"image_upload": true}},
+		}, []string{"IMAGE", "MASK"}, false),
+		"SaveImage": newNode("SaveImage", "Save Image", "image", map[string]interface{}{
+			"images":          []interface{}{"IMAGE"},
+			"filename_prefix": []interface{}{"STRING", map[string]interface{}{"default": "ComfyUI"}},
+		}, nil, true),
+		"PreviewImage": newNode("PreviewImage", "Preview Image", "image", map[string]interface{}{
+			"images": []interface{}{"IMAGE"},
+		}, nil, true),
+	}
+}
+
+func newNode(name, displayName, category string, required map[string]interface{}, outputs []string, outputNode bool) *comfyUIclient.NodeObject {
+	if outputs == nil {
+		outputs = []string{}
+	}
+	return &comfyUIclient.NodeObject{
+		Input:        &comfyUIclient.NodeObjectInput{Required: required},
+		Output:       outputs,
+		OutputIsList: make([]bool, len(outputs)),
+		OutputName:   outputs,
+		Name:         name,
+		DisplayName:  displayName,
+		Category:     category,
+		OutputNode:   outputNode,
+	}
+}
+
+func defaultSystemStats() *comfyUIclient.SystemStats {
+	return &comfyUIclient.SystemStats{
+		System: &comfyUIclient.System{OS: "posix", PythonVersion: "3.12.0", EmbeddedPython: false},
+		Devices: []*comfyUIclient.GPU{{
+			Name:           "cuda:0 comfytest",
+			Type:           "cuda",
+			Index:          0,
+			VRAMTotal:      24 << 30,
+			VRAMFree:       20 << 30,
+			TorchVRAMTotal: 2 << 30,
+			TorchVRAMFree:  1 << 30,
+		}},
+	}
+}
+
+func defaultModels() map[string][]string {
+	return map[string][]string{
+		"checkpoints": {"comfytest.safetensors"},
+		"loras":       {},
+		"vae":         {},
+	}
+}
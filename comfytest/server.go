@@ -0,0 +1,534 @@
+// Package comfytest provides an in-process fake ComfyUI server for hermetic tests
+//
+// The server implements the HTTP routes and the websocket used by comfyUIclient.Client
+// Prompts run on a simulated executor that sends the same websocket messages as ComfyUI, in the same order,
+// and produce deterministic placeholder images, how a prompt runs is scripted with Behavior and Fault
+package comfytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/gorilla/websocket"
+)
+
+// Server is a fake ComfyUI server listening on a local address, URL is the base url for comfyUIclient.New
+type Server struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	queued     *sync.Cond
+	done       chan struct{}
+	closeOnce  sync.Once
+	closed     bool
+	paused     bool
+	nodes      map[string]*comfyUIclient.NodeObject
+	stats      *comfyUIclient.SystemStats
+	models     map[string][]string
+	imageSize  [2]int
+	files      map[fileKey][]byte
+	counter    int
+	number     int
+	pending    []*queuedPrompt
+	running    *queuedPrompt
+	history    map[string]*historyEntry
+	order      []string
+	behavior   *Behavior
+	script     []*Behavior
+	faults     []*Fault
+	requests   []*Request
+	header     [2]string
+	clients    map[string][]*wsClient
+	upgrader   websocket.Upgrader
+	workerDone chan struct{}
+}
+
+// Request is a request received by the server
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+type fileKey struct {
+	fileType  string
+	subFolder string
+	filename  string
+}
+
+// NewServer starts a server with the default nodes, see DefaultNodes, and runs prompts with the zero Behavior
+// The caller must call Close when done
+func NewServer() *Server {
+	s := &Server{
+		done:       make(chan struct{}),
+		nodes:      DefaultNodes(),
+		stats:      defaultSystemStats(),
+		models:     defaultModels(),
+		imageSize:  [2]int{64, 64},
+		files:      make(map[fileKey][]byte),
+		history:    make(map[string]*historyEntry),
+		behavior:   &Behavior{},
+		clients:    make(map[string][]*wsClient),
+		workerDone: make(chan struct{}),
+	}
+	s.queued = sync.NewCond(&s.mu)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	go s.work()
+	return s
+}
+
+// Close stops executing prompts, disconnects websocket clients and shuts the server down
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		close(s.done)
+		s.queued.Broadcast()
+		s.mu.Unlock()
+		<-s.workerDone
+		s.Disconnect()
+		s.Server.Close()
+	})
+}
+
+// AddNodes adds node classes to /object_info, prompts using classes the server doesn't know are rejected like ComfyUI does
+func (s *Server) AddNodes(nodes map[string]*comfyUIclient.NodeObject) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, node := range nodes {
+		s.nodes[name] = node
+	}
+}
+
+// SetSystemStats sets the response of /system_stats
+func (s *Server) SetSystemStats(stats *comfyUIclient.SystemStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats = stats
+}
+
+// SetModels sets the model files served by /models, keyed by folder
+func (s *Server) SetModels(models map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.models = models
+}
+
+// SetImageSize sets the size of the generated placeholder images, 64x64 by default
+func (s *Server) SetImageSize(width, height int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.imageSize = [2]int{width, height}
+}
+
+// RequireHeader rejects requests, including the websocket handshake, whose header name isn't value with 401
+func (s *Server) RequireHeader(name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.header = [2]string{name, value}
+}
+
+// AddFile stores a file served by /view
+func (s *Server) AddFile(file *comfyUIclient.DataOutputFile, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[fileKey{fileType: file.Type, subFolder: file.SubFolder, filename: file.Filename}] = data
+}
+
+// File returns a file served by /view
+func (s *Server) File(file *comfyUIclient.DataOutputFile) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[fileKey{fileType: file.Type, subFolder: file.SubFolder, filename: file.Filename}]
+	return data, ok
+}
+
+// Requests returns the requests received so far in order
+func (s *Server) Requests() []*Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Request(nil), s.requests...)
+}
+
+// Pause stops starting queued prompts, the running prompt finishes
+func (s *Server) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = true
+}
+
+// Resume starts queued prompts again after Pause
+func (s *Server) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = false
+	s.queued.Broadcast()
+}
+
+// Client returns a client connected to the server, it waits up to 5 seconds for the connection
+func (s *Server) Client(opts ...comfyUIclient.Option) (*comfyUIclient.Client, error) {
+	opts = append([]comfyUIclient.Option{
+		comfyUIclient.WithReconnectPolicy(comfyUIclient.ReconnectPolicy{MaxRetry: 3, Interval: 50 * time.Millisecond}),
+	}, opts...)
+	client, err := comfyUIclient.New(s.URL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("comfyUIclient.New: error: %w", err)
+	}
+	client.ConnectAndListen()
+	deadline := time.Now().Add(5 * time.Second)
+	for !client.IsInitialized() {
+		if time.Now().After(deadline) {
+			return nil, errors.New("client not initialized after 5s")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return client, nil
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	// ComfyUI serves every route under /api too
+	routePath := r.URL.Path
+	if strings.HasPrefix(routePath, "/api/") {
+		routePath = strings.TrimPrefix(routePath, "/api")
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, &Request{
+		Method: r.Method,
+		Path:   routePath,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+		Body:   body,
+	})
+	header := s.header
+	fault := s.matchFault(r.Method, routePath)
+	s.mu.Unlock()
+
+	if fault != nil {
+		if !sleep(fault.Delay, s.done) {
+			return
+		}
+		if fault.Status != 0 {
+			writeFault(w, fault)
+			return
+		}
+	}
+	if header[0] != "" && r.Header.Get(header[0]) != header[1] {
+		http.Error(w, "401: Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case routePath == "/ws":
+		s.serveWebSocket(w, r)
+	case routePath == string(comfyUIclient.PromptRouter):
+		if r.Method == http.MethodPost {
+			s.postPrompt(w, body)
+			return
+		}
+		s.mu.Lock()
+		remaining := s.queueRemaining()
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"exec_info": map[string]interface{}{"queue_remaining": remaining}})
+	case routePath == string(comfyUIclient.QueueRouter):
+		if r.Method == http.MethodPost {
+			s.postQueue(w, body)
+			return
+		}
+		s.getQueue(w)
+	case routePath == string(comfyUIclient.HistoryRouter):
+		if r.Method == http.MethodPost {
+			s.postHistory(w, body)
+			return
+		}
+		s.getHistory(w, "", r.URL.Query().Get("max_items"))
+	case strings.HasPrefix(routePath, string(comfyUIclient.HistoryRouter)+"/"):
+		s.getHistory(w, strings.TrimPrefix(routePath, string(comfyUIclient.HistoryRouter)+"/"), "")
+	case routePath == string(comfyUIclient.ViewRouter):
+		s.getView(w, r)
+	case routePath == string(comfyUIclient.UploadImageRouter), routePath == string(comfyUIclient.UploadMaskRouter):
+		s.postUpload(w, r)
+	case routePath == string(comfyUIclient.ObjectInfoRouter):
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		writeJSON(w, http.StatusOK, s.nodes)
+	case strings.HasPrefix(routePath, string(comfyUIclient.ObjectInfoRouter)+"/"):
+		name := strings.TrimPrefix(routePath, string(comfyUIclient.ObjectInfoRouter)+"/")
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		result := map[string]*comfyUIclient.NodeObject{}
+		if node, ok := s.nodes[name]; ok {
+			result[name] = node
+		}
+		writeJSON(w, http.StatusOK, result)
+	case routePath == string(comfyUIclient.SystemStatsRouter):
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		writeJSON(w, http.StatusOK, s.stats)
+	case routePath == string(comfyUIclient.ModelsRouter):
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		folders := make([]string, 0, len(s.models))
+		for folder := range s.models {
+			folders = append(folders, folder)
+		}
+		sort.Strings(folders)
+		writeJSON(w, http.StatusOK, folders)
+	case strings.HasPrefix(routePath, string(comfyUIclient.ModelsRouter)+"/"):
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		files, ok := s.models[strings.TrimPrefix(routePath, string(comfyUIclient.ModelsRouter)+"/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, files)
+	case routePath == string(comfyUIclient.InterruptRouter):
+		s.postInterrupt(w, body)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) getQueue(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	running := []interface{}{}
+	if s.running != nil {
+		running = append(running, s.running.queueItem())
+	}
+	pending := make([]interface{}, 0, len(s.pending))
+	for _, prompt := range s.pending {
+		pending = append(pending, prompt.queueItem())
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"queue_running": running, "queue_pending": pending})
+}
+
+// postQueue handles {"clear": true} and {"delete": [ids]}
+func (s *Server) postQueue(w http.ResponseWriter, body []byte) {
+	var request struct {
+		Clear  interface{}     `json:"clear"`
+		Delete json.RawMessage `json:"delete"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ids, err := idList(request.Delete)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if truthy(request.Clear) {
+		s.pending = nil
+	}
+	for _, id := range ids {
+		for i, prompt := range s.pending {
+			if prompt.promptID == id {
+				s.pending = append(s.pending[:i], s.pending[i+1:]...)
+				break
+			}
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) getHistory(w http.ResponseWriter, promptID, maxItems string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make(map[string]*historyEntry)
+	if promptID != "" {
+		if entry, ok := s.history[promptID]; ok {
+			result[promptID] = entry
+		}
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+
+	ids := s.order
+	if limit, err := strconv.Atoi(maxItems); err == nil && limit >= 0 && limit < len(ids) {
+		ids = ids[len(ids)-limit:]
+	}
+	for _, id := range ids {
+		result[id] = s.history[id]
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// postHistory handles {"clear": true} and {"delete": [ids]}
+func (s *Server) postHistory(w http.ResponseWriter, body []byte) {
+	var request struct {
+		Clear  interface{}     `json:"clear"`
+		Delete json.RawMessage `json:"delete"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ids, err := idList(request.Delete)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if truthy(request.Clear) {
+		s.history = make(map[string]*historyEntry)
+		s.order = nil
+	}
+	for _, id := range ids {
+		delete(s.history, id)
+		for i, ordered := range s.order {
+			if ordered == id {
+				s.order = append(s.order[:i], s.order[i+1:]...)
+				break
+			}
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) getView(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	fileType := query.Get("type")
+	if fileType == "" {
+		fileType = "output"
+	}
+	data, ok := s.File(&comfyUIclient.DataOutputFile{Filename: query.Get("filename"), SubFolder: query.Get("subfolder"), Type: fileType})
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", contentType(query.Get("filename")))
+	w.Write(data)
+}
+
+// postUpload stores the uploaded image, an existing file is renamed to "name (n).ext" unless overwrite is true
+func (s *Server) postUpload(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fileType := r.FormValue("type")
+	if fileType == "" {
+		fileType = "input"
+	}
+	subFolder := r.FormValue("subfolder")
+	overwrite := r.FormValue("overwrite") == "true" || r.FormValue("overwrite") == "1"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := header.Filename
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; !overwrite; i++ {
+		if _, exists := s.files[fileKey{fileType: fileType, subFolder: subFolder, filename: name}]; !exists {
+			break
+		}
+		name = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	s.files[fileKey{fileType: fileType, subFolder: subFolder, filename: name}] = data
+	writeJSON(w, http.StatusOK, map[string]string{"name": name, "subfolder": subFolder, "type": fileType})
+}
+
+// postInterrupt interrupts the running prompt, or only the given prompt_id when it is running
+func (s *Server) postInterrupt(w http.ResponseWriter, body []byte) {
+	var request struct {
+		PromptID string `json:"prompt_id"`
+	}
+	_ = json.Unmarshal(body, &request)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running != nil && (request.PromptID == "" || request.PromptID == s.running.promptID) {
+		s.running.interrupt()
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// queueRemaining returns the number of pending and running prompts, s.mu must be held
+func (s *Server) queueRemaining() int {
+	remaining := len(s.pending)
+	if s.running != nil {
+		remaining++
+	}
+	return remaining
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0
+	}
+	return false
+}
+
+// idList decodes the ids of delete, ComfyUI iterates over it, so anything but a list is rejected
+// A single id string would delete one id per character
+func idList(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var ids []string
+	if err := json.Unmarshal(raw, &ids); err != nil {
+		return nil, fmt.Errorf("delete must be a list of prompt ids: %w", err)
+	}
+	return ids, nil
+}
+
+func contentType(filename string) string {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".png":
+		return "image/png"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".webp":
+		return "image/webp"
+	case ".gif":
+		return "image/gif"
+	}
+	return "application/octet-stream"
+}
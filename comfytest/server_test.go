@@ -0,0 +1,35 @@
+package comfytest_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/XdpCs/comfyUIclient/comfytest"
+)
+
+func TestDeleteRequiresList(t *testing.T) {
+	server := comfytest.NewServer()
+	defer server.Close()
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{body: `{"delete": ["prompt-id"]}`, status: http.StatusOK},
+		{body: `{"clear": true}`, status: http.StatusOK},
+		{body: `{"delete": "prompt-id"}`, status: http.StatusBadRequest},
+		{body: `{"delete": 1}`, status: http.StatusBadRequest},
+	}
+	for _, path := range []string{"/queue", "/history"} {
+		for _, tt := range tests {
+			resp, err := http.Post(server.URL+path, "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("POST %s: %v", path, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("POST %s %s: status %d, want %d", path, tt.body, resp.StatusCode, tt.status)
+			}
+		}
+	}
+}
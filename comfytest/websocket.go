@@ -0,0 +1,141 @@
+package comfytest
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/gorilla/websocket"
+)
+
+type wsClient struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (c *wsClient) write(messageType int, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.conn.WriteMessage(messageType, data)
+}
+
+// serveWebSocket registers the connection under its clientId and sends the queue status, like ComfyUI does
+func (s *Server) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	clientID := r.URL.Query().Get("clientId")
+	if clientID == "" {
+		clientID = strings.ReplaceAll(comfyUIclient.NewPromptID(), "-", "")
+	}
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	client := &wsClient{conn: conn}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.clients[clientID] = append(s.clients[clientID], client)
+	remaining := s.queueRemaining()
+	s.mu.Unlock()
+
+	client.write(websocket.TextMessage, marshalMessage(comfyUIclient.Status, map[string]interface{}{
+		"status": map[string]interface{}{"exec_info": map[string]interface{}{"queue_remaining": remaining}},
+		"sid":    clientID,
+	}))
+
+	go func() {
+		defer s.removeClient(clientID, client)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func (s *Server) removeClient(clientID string, client *wsClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clients := s.clients[clientID]
+	for i, c := range clients {
+		if c == client {
+			s.clients[clientID] = append(clients[:i:i], clients[i+1:]...)
+			break
+		}
+	}
+	if len(s.clients[clientID]) == 0 {
+		delete(s.clients, clientID)
+	}
+	client.conn.Close()
+}
+
+// Disconnect closes all websocket connections, clients reconnect according to their reconnect policy
+func (s *Server) Disconnect() {
+	s.disconnect("")
+}
+
+// disconnect closes the connections of clientID, or all connections when clientID is empty
+func (s *Server) disconnect(clientID string) {
+	for _, client := range s.connections(clientID) {
+		client.conn.Close()
+	}
+}
+
+// connections returns the connections of clientID, or all connections when clientID is empty
+func (s *Server) connections(clientID string) []*wsClient {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if clientID != "" {
+		return append([]*wsClient(nil), s.clients[clientID]...)
+	}
+	var all []*wsClient
+	for _, clients := range s.clients {
+		all = append(all, clients...)
+	}
+	return all
+}
+
+// send sends a json message to clientID, or to every client when clientID is empty
+// Like ComfyUI, messages for clients that aren't connected are dropped
+func (s *Server) send(clientID string, messageType comfyUIclient.WsMessageType, data interface{}) {
+	message := marshalMessage(messageType, data)
+	for _, client := range s.connections(clientID) {
+		client.write(websocket.TextMessage, message)
+	}
+}
+
+func (s *Server) sendBinary(clientID string, data []byte) {
+	for _, client := range s.connections(clientID) {
+		client.write(websocket.BinaryMessage, data)
+	}
+}
+
+// sendStatus sends the queue status to clientID, or to every client when clientID is empty
+func (s *Server) sendStatus(clientID string) {
+	s.mu.Lock()
+	remaining := s.queueRemaining()
+	s.mu.Unlock()
+	s.send(clientID, comfyUIclient.Status, map[string]interface{}{
+		"status": map[string]interface{}{"exec_info": map[string]interface{}{"queue_remaining": remaining}},
+	})
+}
+
+func marshalMessage(messageType comfyUIclient.WsMessageType, data interface{}) []byte {
+	message, _ := json.Marshal(map[string]interface{}{"type": messageType, "data": data})
+	return message
+}
+
+// previewFrame returns a binary preview image message with a png placeholder
+func previewFrame(nodeID string, step int) []byte {
+	image := Placeholder(nodeID+"/"+strings.Repeat("*", step), 16, 16)
+	frame := make([]byte, 8, 8+len(image))
+	binary.BigEndian.PutUint32(frame[0:4], 1)
+	binary.BigEndian.PutUint32(frame[4:8], 2)
+	return append(frame, image...)
+}
@@ -0,0 +1,173 @@
+package comfyUIclient
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// WSMessageDataCompleted is the data of the Completed message the client emits once per prompt
+//
+// A prompt is completed on executing with a null node, which ComfyUI sends after execution_success,
+// execution_error or execution_interrupted once the history was written, so it is also detected when all outputs were cached.
+// Prompts whose final messages were lost are completed from the history, prompts missing from both the queue
+// and the history are completed as ExecutionInterrupted, see WithResync.
+type WSMessageDataCompleted struct {
+	PromptID string
+	// Status is ExecutionSuccess, ExecutionError or ExecutionInterrupted
+	Status WsMessageType
+	// Outputs are the files of every output node, keyed by node id and output name
+	// Outputs of cached nodes are read from the history
+	Outputs map[string]map[string][]*DataOutputFile
+	// ExecutedNodes and CachedNodes are the nodes the server ran and skipped
+	ExecutedNodes []string
+	CachedNodes   []string
+	// Error is set when Status is ExecutionError
+	Error *WSMessageExecutionError
+	// Interrupted is set when Status is ExecutionInterrupted
+	Interrupted *WSMessageExecutionInterrupted
+	// StartedAt and EndedAt are the times the client received start and end, StartedAt is zero when the start was missed
+	StartedAt time.Time
+	EndedAt   time.Time
+}
+
+// Duration returns the execution time of the prompt, zero when the start was missed
+func (d *WSMessageDataCompleted) Duration() time.Duration {
+	if d.StartedAt.IsZero() {
+		return 0
+	}
+	return d.EndedAt.Sub(d.StartedAt)
+}
+
+// completedHistorySize is the number of completed prompt ids remembered to drop duplicate completions
+const completedHistorySize = 1024
+
+type promptRun struct {
+	data     *WSMessageDataCompleted
+	executed map[string]bool
+	// status is the final status received so far, the prompt is finished by the following executing message
+	status WsMessageType
+}
+
+type completionTracker struct {
+	mu        sync.Mutex
+	runs      map[string]*promptRun
+	completed map[string]bool
+	order     []string
+}
+
+// trackCompletion updates the state of the prompt of message and returns a Completed message when it finished
+func (c *Client) trackCompletion(message *WSMessage) *WSMessage {
+	t := &c.completions
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch data := message.Data.(type) {
+	case *WSMessageDataExecutionStart:
+		if run := t.run(data.PromptID); run != nil && run.data.StartedAt.IsZero() {
+			run.data.StartedAt = time.Now()
+		}
+	case *WSMessageDataExecutionCached:
+		if run := t.run(data.PromptID); run != nil {
+			run.data.CachedNodes = append(run.data.CachedNodes, data.Nodes...)
+		}
+	case *WSMessageDataExecuting:
+		if data.Node == "" {
+			return t.finish(data.PromptID)
+		}
+		if run := t.run(data.PromptID); run != nil && !run.executed[data.Node] {
+			run.executed[data.Node] = true
+			run.data.ExecutedNodes = append(run.data.ExecutedNodes, data.Node)
+		}
+	case *WSMessageDataExecuted:
+		if run := t.run(data.PromptID); run != nil && len(data.Output) != 0 {
+			run.data.Outputs[data.Node] = data.Output
+		}
+	case *WSMessageDataExecutionSuccess:
+		if run := t.run(data.PromptID); run != nil {
+			run.status = ExecutionSuccess
+		}
+	case *WSMessageExecutionError:
+		if run := t.run(data.PromptID); run != nil {
+			run.data.Error = data
+			run.status = ExecutionError
+		}
+	case *WSMessageExecutionInterrupted:
+		if run := t.run(data.PromptID); run != nil {
+			run.data.Interrupted = data
+			run.status = ExecutionInterrupted
+		}
+	}
+	return nil
+}
+
+// run returns the state of promptID, nil when it already completed
+func (t *completionTracker) run(promptID string) *promptRun {
+	if promptID == "" || t.completed[promptID] {
+		return nil
+	}
+	if t.runs == nil {
+		t.runs = make(map[string]*promptRun)
+		t.completed = make(map[string]bool)
+	}
+	run, ok := t.runs[promptID]
+	if !ok {
+		run = &promptRun{
+			data: &WSMessageDataCompleted{
+				PromptID: promptID,
+				Outputs:  make(map[string]map[string][]*DataOutputFile),
+			},
+			executed: make(map[string]bool),
+		}
+		t.runs[promptID] = run
+	}
+	return run
+}
+
+// finish completes promptID with the final status it received, a prompt without one succeeded
+func (t *completionTracker) finish(promptID string) *WSMessage {
+	run := t.run(promptID)
+	if run == nil {
+		return nil
+	}
+	delete(t.runs, promptID)
+	t.completed[promptID] = true
+	t.order = append(t.order, promptID)
+	if len(t.order) > completedHistorySize {
+		delete(t.completed, t.order[0])
+		t.order = t.order[1:]
+	}
+
+	run.data.Status = run.status
+	if run.data.Status == "" {
+		run.data.Status = ExecutionSuccess
+	}
+	run.data.EndedAt = time.Now()
+	return &WSMessage{Type: Completed, Data: run.data}
+}
+
+// backfillOutputs reads outputs that weren't sent over the websocket, e.g. of cached nodes, from the history
+// It runs on the delivery goroutine, the history was written before the prompt completed
+func (c *Client) backfillOutputs(ctx context.Context, data *WSMessageDataCompleted) {
+	if data.Status != ExecutionSuccess || (len(data.CachedNodes) == 0 && len(data.Outputs) != 0) {
+		return
+	}
+
+	history, err := c.getHistoryByPromptID(ctx, data.PromptID)
+	if err != nil {
+		c.logger.Warn("read outputs from history failed", "prompt_id", data.PromptID, "error", err)
+		return
+	}
+	if history == nil {
+		return
+	}
+	for node, output := range history.Outputs {
+		if _, ok := data.Outputs[node]; ok || len(output.Files) == 0 {
+			continue
+		}
+		data.Outputs[node] = output.Files
+	}
+	if history.Status != nil && history.Status.StatusStr == "error" {
+		data.Status = ExecutionError
+	}
+}
@@ -0,0 +1,68 @@
+package comfyUIclient_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/XdpCs/comfyUIclient/comfytest"
+)
+
+func TestCompletedCachedPromptHasOutputs(t *testing.T) {
+	server, client := newTestServer(t)
+	completed := completions(t, client)
+	server.Script(&comfytest.Behavior{Cached: []string{"1", "2"}})
+
+	q, err := client.QueuePromptByNodesWithOptions(context.Background(), testPrompt(), nil)
+	if err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	data := nextCompleted(t, completed)
+	if data.PromptID != q.PromptID || data.Status != comfyUIclient.ExecutionSuccess {
+		t.Fatalf("completed %s with %v, want %s with %v", data.PromptID, data.Status, q.PromptID, comfyUIclient.ExecutionSuccess)
+	}
+	if len(data.CachedNodes) != 2 || len(data.ExecutedNodes) != 0 {
+		t.Fatalf("cached %v executed %v, want 2 cached nodes", data.CachedNodes, data.ExecutedNodes)
+	}
+	if files := data.Outputs["2"]["images"]; len(files) != 1 {
+		t.Fatalf("outputs = %v, want the image of node 2 from the history", data.Outputs)
+	}
+}
+
+func TestCompletedOncePerPrompt(t *testing.T) {
+	server, client := newTestServer(t)
+	completed := completions(t, client)
+	server.Script(nil, &comfytest.Behavior{ErrorAt: "2", ExceptionMessage: "boom"})
+
+	ctx := context.Background()
+	first, err := client.QueuePromptByNodesWithOptions(ctx, testPrompt(), nil)
+	if err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	second, err := client.QueuePromptByNodesWithOptions(ctx, testPrompt(), nil)
+	if err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+
+	data := nextCompleted(t, completed)
+	if data.PromptID != first.PromptID || data.Status != comfyUIclient.ExecutionSuccess || len(data.Outputs["2"]["images"]) != 1 {
+		t.Fatalf("first prompt completed with %+v", data)
+	}
+	if data.StartedAt.IsZero() || data.Duration() < 0 {
+		t.Fatalf("StartedAt %v EndedAt %v", data.StartedAt, data.EndedAt)
+	}
+	data = nextCompleted(t, completed)
+	if data.PromptID != second.PromptID || data.Status != comfyUIclient.ExecutionError {
+		t.Fatalf("second prompt completed with %+v", data)
+	}
+	if data.Error == nil || data.Error.ExceptionMessage != "boom" {
+		t.Fatalf("Error = %+v, want the exception", data.Error)
+	}
+
+	select {
+	case data := <-completed:
+		t.Fatalf("unexpected completion of %s", data.PromptID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -11,6 +11,19 @@ const (
 	ExecutionError       WsMessageType = "execution_error"
 	ExecutionCached      WsMessageType = "execution_cached"
 	ExecutionInterrupted WsMessageType = "execution_interrupted"
+	ExecutionSuccess     WsMessageType = "execution_success"
+	ProgressState        WsMessageType = "progress_state"
+
+	// PreviewImage and ProgressText are decoded from binary websocket frames, ComfyUI doesn't name them
+	PreviewImage WsMessageType = "preview_image"
+	ProgressText WsMessageType = "progress_text"
+	// Binary is a binary websocket frame of unknown event type, Data holds the frame
+	Binary WsMessageType = "binary"
+
+	// Completed is emitted by the client once per prompt when it finished, see WSMessageDataCompleted
+	Completed WsMessageType = "completed"
+	// Queued is emitted by the client to listeners when it queued a prompt, see WSMessageDataQueued
+	Queued WsMessageType = "queued"
 )
 
 type Router string
@@ -28,6 +41,7 @@ const (
 	ObjectInfoRouter   Router = "/object_info"
 	UploadImageRouter  Router = "/upload/image"
 	UploadMaskRouter   Router = "/upload/mask"
+	ModelsRouter       Router = "/models"
 )
 
 type TaskStatusType = WsMessageType
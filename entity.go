@@ -3,6 +3,7 @@ package comfyUIclient
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 )
 
 // SystemStats contains a system info and gpu infos
@@ -38,8 +39,9 @@ type QueueExecInfo struct {
 
 // QueuePromptResp contains prompt id, number and node errors
 type QueuePromptResp struct {
-	PromptID   string                 `json:"prompt_id"`
-	Number     int                    `json:"number"`
+	PromptID string `json:"prompt_id"`
+	// Number is the queue number, it is negative or fractional for prompts queued in front, like NodeInfo.Number
+	Number     float64                `json:"number"`
 	NodeErrors map[string]interface{} `json:"node_errors"`
 }
 
@@ -54,10 +56,76 @@ type DataOutputFile struct {
 type PromptHistoryMember struct {
 	NodeInfo *NodeInfo                            `json:"prompt"`
 	Outputs  map[string]PromptHistoryMemberImages `json:"outputs"`
+	Status   *PromptHistoryStatus                 `json:"status"`
 }
 
+// PromptHistoryMemberImages is the output of a node in the history
 type PromptHistoryMemberImages struct {
 	Images *[]DataOutputFile `json:"images"`
+	// Files holds every file list of the output, including images, gifs and audio
+	Files map[string][]*DataOutputFile `json:"-"`
+}
+
+func (p *PromptHistoryMemberImages) UnmarshalJSON(data []byte) error {
+	var output map[string]json.RawMessage
+	if err := json.Unmarshal(data, &output); err != nil {
+		return err
+	}
+
+	p.Files, _ = splitOutput(output)
+	if images, ok := p.Files["images"]; ok {
+		list := make([]DataOutputFile, 0, len(images))
+		for _, image := range images {
+			list = append(list, *image)
+		}
+		p.Images = &list
+	}
+	return nil
+}
+
+// PromptHistoryStatus tells how a prompt in the history finished
+type PromptHistoryStatus struct {
+	// StatusStr is "success" or "error"
+	StatusStr string                  `json:"status_str"`
+	Completed bool                    `json:"completed"`
+	Messages  []*PromptHistoryMessage `json:"messages"`
+}
+
+// PromptHistoryMessage is a websocket message recorded in the history, e.g. execution_error
+type PromptHistoryMessage struct {
+	Type WsMessageType
+	Data json.RawMessage
+}
+
+func (m *PromptHistoryMessage) UnmarshalJSON(data []byte) error {
+	var temp []json.RawMessage
+	if err := json.Unmarshal(data, &temp); err != nil {
+		return err
+	}
+	if len(temp) != 2 {
+		return fmt.Errorf("unexpected JSON array length for PromptHistoryMessage")
+	}
+	if err := json.Unmarshal(temp[0], &m.Type); err != nil {
+		return err
+	}
+	m.Data = temp[1]
+	return nil
+}
+
+// Message decodes the recorded message like a websocket message
+func (m *PromptHistoryMessage) Message() (*WSMessage, error) {
+	message := &WSMessage{}
+	data, err := json.Marshal(struct {
+		Type WsMessageType   `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}{m.Type, m.Data})
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, message); err != nil {
+		return nil, err
+	}
+	return message, nil
 }
 
 // PromptHistoryItem contains prompt id, WorkFlow, output info
@@ -99,7 +167,10 @@ type QueueInfo struct {
 
 // NodeInfo contains the node info
 type NodeInfo struct {
-	Num           uint64
+	// Num is the queue number, it is 0 when the number is negative or fractional, see Number
+	Num uint64
+	// Number is the exact queue number, negative for prompts queued in front, lower numbers run first
+	Number        float64
 	PromptID      string
 	Prompt        map[string]PromptNode `json:"prompt"`
 	ExtraData     json.RawMessage       // extra data is just for user's custom data
@@ -125,9 +196,12 @@ func (n *NodeInfo) UnmarshalJSON(data []byte) error {
 	}
 
 	// Extract values from the array
-	if err := json.Unmarshal(temp[0], &n.Num); err != nil {
+	if err := json.Unmarshal(temp[0], &n.Number); err != nil {
 		return err
 	}
+	if n.Number >= 0 && n.Number == math.Trunc(n.Number) {
+		n.Num = uint64(n.Number)
+	}
 
 	if err := json.Unmarshal(temp[1], &n.PromptID); err != nil {
 		return err
@@ -145,7 +219,3 @@ func (n *NodeInfo) UnmarshalJSON(data []byte) error {
 
 	return nil
 }
-
-type extraData struct {
-	ExtraPngInfo json.RawMessage `json:"extra_pnginfo"`
-}
@@ -0,0 +1,93 @@
+package comfyUIclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+func TestNodeInfoUnmarshalNumber(t *testing.T) {
+	tests := []struct {
+		name   string
+		number string
+		num    uint64
+		exact  float64
+	}{
+		{name: "positive", number: "7", num: 7, exact: 7},
+		{name: "front", number: "-3", num: 0, exact: -3},
+		{name: "fractional", number: "2.5", num: 0, exact: 2.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := []byte(`[` + tt.number + `, "id", {}, {}, ["9"]]`)
+			var info comfyUIclient.NodeInfo
+			if err := json.Unmarshal(data, &info); err != nil {
+				t.Fatalf("json.Unmarshal: %v", err)
+			}
+			if info.Num != tt.num || info.Number != tt.exact {
+				t.Fatalf("Num, Number = %v, %v, want %v, %v", info.Num, info.Number, tt.num, tt.exact)
+			}
+			if info.PromptID != "id" || len(info.OutputNodeIDs) != 1 {
+				t.Fatalf("unexpected info %+v", info)
+			}
+		})
+	}
+}
+
+func TestQueuePromptRespUnmarshalNumber(t *testing.T) {
+	tests := []struct {
+		number string
+		want   float64
+	}{
+		{number: "-2.5", want: -2.5},
+		{number: "5.0", want: 5},
+		{number: "3", want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.number, func(t *testing.T) {
+			var resp comfyUIclient.QueuePromptResp
+			if err := json.Unmarshal([]byte(`{"prompt_id": "id", "number": `+tt.number+`, "node_errors": {}}`), &resp); err != nil {
+				t.Fatalf("json.Unmarshal: %v", err)
+			}
+			if resp.Number != tt.want {
+				t.Fatalf("Number = %v, want %v", resp.Number, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrontQueuedPromptIsFound(t *testing.T) {
+	server, client := newTestServer(t)
+	server.Pause()
+	defer server.Resume()
+
+	ctx := context.Background()
+	if _, err := client.QueuePromptByNodesWithOptions(ctx, testPrompt(), nil); err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	front, err := client.QueuePromptByNodesWithOptions(ctx, testPrompt(), &comfyUIclient.QueueOptions{Front: true})
+	if err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions front: %v", err)
+	}
+
+	queueInfo, err := client.GetQueueInfo()
+	if err != nil {
+		t.Fatalf("GetQueueInfo: %v", err)
+	}
+	if len(queueInfo.QueuePending) != 2 || queueInfo.QueuePending[0].PromptID != front.PromptID {
+		t.Fatalf("front prompt is not first in %+v", queueInfo.QueuePending)
+	}
+	if queueInfo.QueuePending[0].Number >= 0 {
+		t.Fatalf("front prompt number = %v, want negative", queueInfo.QueuePending[0].Number)
+	}
+
+	exists, err := client.PromptExists(ctx, front.PromptID)
+	if err != nil {
+		t.Fatalf("PromptExists: %v", err)
+	}
+	if !exists {
+		t.Fatal("front prompt not found")
+	}
+}
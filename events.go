@@ -0,0 +1,151 @@
+package comfyUIclient
+
+import (
+	"sync"
+)
+
+// MessageListener is called with every websocket message the client handles
+// Listeners are called one at a time in the order the messages were received and must return quickly
+// The Queued message of a prompt queued by this client precedes its execution messages
+type MessageListener func(*WSMessage)
+
+type listenerEntry struct {
+	id       uint64
+	listener MessageListener
+}
+
+type listeners struct {
+	mu      sync.Mutex
+	nextID  uint64
+	entries []listenerEntry
+}
+
+// AddListener registers listener for all websocket messages including status, it returns a function that removes it
+// While listeners are registered the GetTaskStatus channel no longer blocks the websocket,
+// messages are dropped when it is full, use WithEventBufferSize to buffer them or a negative size to disable it
+func (c *Client) AddListener(listener MessageListener) (remove func()) {
+	c.listeners.mu.Lock()
+	defer c.listeners.mu.Unlock()
+	c.listeners.nextID++
+	id := c.listeners.nextID
+	c.listeners.entries = append(c.listeners.entries, listenerEntry{id: id, listener: listener})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.listeners.mu.Lock()
+			defer c.listeners.mu.Unlock()
+			for i, entry := range c.listeners.entries {
+				if entry.id == id {
+					c.listeners.entries = append(c.listeners.entries[:i:i], c.listeners.entries[i+1:]...)
+					return
+				}
+			}
+		})
+	}
+}
+
+func (c *Client) hasListeners() bool {
+	c.listeners.mu.Lock()
+	defer c.listeners.mu.Unlock()
+	return len(c.listeners.entries) != 0
+}
+
+func (c *Client) dispatch(message *WSMessage) {
+	c.listeners.mu.Lock()
+	entries := c.listeners.entries
+	c.listeners.mu.Unlock()
+
+	for _, entry := range entries {
+		entry.listener(message)
+	}
+}
+
+// maxPendingMessages bounds the messages waiting for delivery, the websocket reader waits once it is reached
+const maxPendingMessages = 1024
+
+// messageQueue passes received messages to the delivery goroutine in order,
+// so that listeners and history reads don't hold up the websocket reader
+type messageQueue struct {
+	mu      sync.Mutex
+	space   *sync.Cond
+	pending []*WSMessage
+	running bool
+	// held are the messages of prompts that are being queued, keyed by prompt id
+	held map[string][]*WSMessage
+}
+
+// enqueue queues message for delivery, the delivery goroutine is started when it isn't running
+func (c *Client) enqueue(message *WSMessage) {
+	q := &c.messages
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.pending) >= maxPendingMessages {
+		q.space.Wait()
+	}
+	if held, ok := q.held[message.PromptID()]; ok {
+		q.held[message.PromptID()] = append(held, message)
+		return
+	}
+	q.pending = append(q.pending, message)
+	q.start(c)
+}
+
+// start starts the delivery goroutine unless it is running, q.mu must be held
+func (q *messageQueue) start(c *Client) {
+	if q.space == nil {
+		q.space = sync.NewCond(&q.mu)
+	}
+	if !q.running {
+		q.running = true
+		go c.deliverPending()
+	}
+}
+
+// hold keeps the messages of promptID back until release
+func (c *Client) hold(promptID string) {
+	q := &c.messages
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.held == nil {
+		q.held = make(map[string][]*WSMessage)
+	}
+	q.held[promptID] = nil
+}
+
+// release queues first, if any, followed by the held messages of promptID
+func (c *Client) release(promptID string, first *WSMessage) {
+	q := &c.messages
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if first != nil {
+		q.pending = append(q.pending, first)
+	}
+	q.pending = append(q.pending, q.held[promptID]...)
+	delete(q.held, promptID)
+	if len(q.pending) != 0 {
+		q.start(c)
+	}
+}
+
+// deliverPending handles queued messages one at a time until the queue is empty
+func (c *Client) deliverPending() {
+	q := &c.messages
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		message := q.pending[0]
+		q.pending[0] = nil
+		q.pending = q.pending[1:]
+		q.space.Broadcast()
+		q.mu.Unlock()
+
+		if err := c.handleMessage(message); err != nil {
+			c.logger.Warn("handle message failed", "type", message.Type, "error", err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package comfyUIclient_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+func TestListenersDontNeedTaskStatusReader(t *testing.T) {
+	_, client := newTestServer(t)
+	completed := make(chan *comfyUIclient.WSMessageDataCompleted, 4)
+	remove := client.AddListener(func(message *comfyUIclient.WSMessage) {
+		if message.Type == comfyUIclient.Completed {
+			completed <- message.Data.(*comfyUIclient.WSMessageDataCompleted)
+		}
+	})
+	defer remove()
+
+	// nobody reads the unbuffered GetTaskStatus channel
+	for i := 0; i < 2; i++ {
+		if _, err := client.QueuePromptByNodesWithOptions(context.Background(), testPrompt(), nil); err != nil {
+			t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case data := <-completed:
+			if data.Status != comfyUIclient.ExecutionSuccess {
+				t.Fatalf("status = %v, want %v", data.Status, comfyUIclient.ExecutionSuccess)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("prompt didn't complete, the websocket reader is blocked")
+		}
+	}
+	if client.DroppedEvents() == 0 {
+		t.Fatal("DroppedEvents = 0, want dropped messages")
+	}
+}
+
+// delayedTransport delays the responses of POST /prompt, so the prompt starts before QueuePrompt returns
+type delayedTransport struct {
+	next  http.RoundTripper
+	delay time.Duration
+}
+
+func (d delayedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := d.next.RoundTrip(req)
+	if err == nil && req.Method == http.MethodPost && req.URL.Path == "/prompt" {
+		time.Sleep(d.delay)
+	}
+	return resp, err
+}
+
+func TestQueuedPrecedesExecutionMessages(t *testing.T) {
+	_, client := newTestServer(t, comfyUIclient.WithHTTPTransport(func(next http.RoundTripper) http.RoundTripper {
+		return delayedTransport{next: next, delay: 200 * time.Millisecond}
+	}))
+	var (
+		mu    sync.Mutex
+		types []comfyUIclient.WsMessageType
+	)
+	completed := make(chan struct{})
+	remove := client.AddListener(func(message *comfyUIclient.WSMessage) {
+		if message.PromptID() == "" {
+			return
+		}
+		mu.Lock()
+		types = append(types, message.Type)
+		mu.Unlock()
+		if message.Type == comfyUIclient.Completed {
+			close(completed)
+		}
+	})
+	defer remove()
+
+	if _, err := client.QueuePromptByNodesWithOptions(context.Background(), testPrompt(), nil); err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	select {
+	case <-completed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("prompt didn't complete")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if types[0] != comfyUIclient.Queued || types[1] != comfyUIclient.ExecutionStart {
+		t.Fatalf("messages = %v, want queued then execution_start first", types)
+	}
+}
@@ -2,13 +2,19 @@ package main
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/XdpCs/comfyUIclient"
 )
 
 func main() {
-	endPoint := comfyUIclient.NewEndPoint("https", "serverAddress", "port")
-	client := comfyUIclient.NewDefaultClient(endPoint)
+	client, err := comfyUIclient.New("http://127.0.0.1:8188",
+		comfyUIclient.WithTimeout(30*time.Second),
+		comfyUIclient.WithUserAgent("comfyUIclient-example"),
+	)
+	if err != nil {
+		panic(err)
+	}
 	client.ConnectAndListen()
 	for !client.IsInitialized() {
 	}
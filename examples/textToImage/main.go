@@ -473,7 +473,7 @@ func main() {
 	for !client.IsInitialized() {
 	}
 
-	// if you use the same seed, you will get the same result, so comfyUI will not give you result.
+	// if you use the same seed, comfyUI caches the result, the completed message still carries the outputs.
 	go func() {
 		_, err := client.QueuePromptByString(workflow, "")
 		if err != nil {
@@ -508,8 +508,18 @@ func main() {
 		case comfyUIclient.Executed:
 			s := taskStatus.Data.(*comfyUIclient.WSMessageDataExecuted)
 			fmt.Printf("Type: %v, Data:%+v\n", comfyUIclient.Executed, s)
-			for _, images := range s.Output {
-				for _, image := range images {
+		case comfyUIclient.ExecutionInterrupted:
+			s := taskStatus.Data.(*comfyUIclient.WSMessageExecutionInterrupted)
+			fmt.Printf("Type: %v, Data:%+v\n", comfyUIclient.ExecutionInterrupted, s)
+		case comfyUIclient.ExecutionError:
+			s := taskStatus.Data.(*comfyUIclient.WSMessageExecutionError)
+			fmt.Printf("Type: %v, Data:%+v\n", comfyUIclient.ExecutionError, s)
+		case comfyUIclient.Completed:
+			// completed is sent once per prompt, even if all outputs were cached
+			s := taskStatus.Data.(*comfyUIclient.WSMessageDataCompleted)
+			fmt.Printf("Type: %v, PromptID: %v, Status: %v\n", comfyUIclient.Completed, s.PromptID, s.Status)
+			for _, output := range s.Outputs {
+				for _, image := range output["images"] {
 					imageData, err := client.GetFile(image)
 					if err != nil {
 						panic(err)
@@ -525,18 +535,8 @@ func main() {
 			}
 			count++
 			IsEndQueuePrompt(count, 2)
-		case comfyUIclient.ExecutionInterrupted:
-			s := taskStatus.Data.(*comfyUIclient.WSMessageExecutionInterrupted)
-			fmt.Printf("Type: %v, Data:%+v\n", comfyUIclient.ExecutionInterrupted, s)
-			count++
-			IsEndQueuePrompt(count, 2)
-		case comfyUIclient.ExecutionError:
-			s := taskStatus.Data.(*comfyUIclient.WSMessageExecutionError)
-			fmt.Printf("Type: %v, Data:%+v\n", comfyUIclient.ExecutionError, s)
-			count++
-			IsEndQueuePrompt(count, 2)
 		default:
-			fmt.Println("unknown message type")
+			fmt.Printf("Type: %v\n", taskStatus.Type)
 		}
 	}
 }
@@ -0,0 +1,299 @@
+// Package graph analyses the node graph of a ComfyUI prompt in api format
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+// Edge links output Slot of node From to input Input of node To
+type Edge struct {
+	From  string
+	Slot  int
+	To    string
+	Input string
+}
+
+// Graph is the dependency graph of a prompt
+type Graph struct {
+	prompt       comfyUIclient.Prompt
+	ids          []string
+	dependencies map[string][]Edge
+	dependents   map[string][]Edge
+}
+
+// CycleError is returned when the prompt contains a cycle
+type CycleError struct {
+	Cycle []string
+}
+
+func (e *CycleError) Error() string {
+	return "cycle in prompt: " + strings.Join(e.Cycle, " -> ")
+}
+
+// New builds the graph of prompt, it fails when an input links to a node that doesn't exist
+func New(prompt comfyUIclient.Prompt) (*Graph, error) {
+	g := &Graph{
+		prompt:       prompt,
+		ids:          make([]string, 0, len(prompt)),
+		dependencies: make(map[string][]Edge, len(prompt)),
+		dependents:   make(map[string][]Edge, len(prompt)),
+	}
+	for id := range prompt {
+		g.ids = append(g.ids, id)
+	}
+	sortIDs(g.ids)
+
+	for _, id := range g.ids {
+		node := prompt[id]
+		links := node.Links()
+		inputs := make([]string, 0, len(links))
+		for input := range links {
+			inputs = append(inputs, input)
+		}
+		sort.Strings(inputs)
+		for _, input := range inputs {
+			link := links[input]
+			if _, ok := prompt[link.NodeID]; !ok {
+				return nil, fmt.Errorf("input %s of node %s links to missing node %s", input, id, link.NodeID)
+			}
+			edge := Edge{From: link.NodeID, Slot: link.Slot, To: id, Input: input}
+			g.dependencies[id] = append(g.dependencies[id], edge)
+			g.dependents[link.NodeID] = append(g.dependents[link.NodeID], edge)
+		}
+	}
+	return g, nil
+}
+
+// Nodes returns all node ids in numeric order
+func (g *Graph) Nodes() []string {
+	return append([]string{}, g.ids...)
+}
+
+// Node returns the node with id
+func (g *Graph) Node(id string) (comfyUIclient.PromptNode, bool) {
+	node, ok := g.prompt[id]
+	return node, ok
+}
+
+// Edges returns all links of the graph
+func (g *Graph) Edges() []Edge {
+	var edges []Edge
+	for _, id := range g.ids {
+		edges = append(edges, g.dependencies[id]...)
+	}
+	return edges
+}
+
+// Dependencies returns the ids of the nodes id reads inputs from
+func (g *Graph) Dependencies(id string) []string {
+	return uniqueIDs(g.dependencies[id], func(e Edge) string { return e.From })
+}
+
+// Dependents returns the ids of the nodes that read outputs of id
+func (g *Graph) Dependents(id string) []string {
+	return uniqueIDs(g.dependents[id], func(e Edge) string { return e.To })
+}
+
+// Upstream returns ids and all nodes they transitively depend on
+func (g *Graph) Upstream(ids ...string) []string {
+	return g.closure(ids, g.Dependencies)
+}
+
+// Downstream returns ids and all nodes that transitively depend on them
+func (g *Graph) Downstream(ids ...string) []string {
+	return g.closure(ids, g.Dependents)
+}
+
+func (g *Graph) closure(ids []string, next func(string) []string) []string {
+	seen := make(map[string]bool)
+	stack := append([]string{}, ids...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[id] {
+			continue
+		}
+		if _, ok := g.prompt[id]; !ok {
+			continue
+		}
+		seen[id] = true
+		stack = append(stack, next(id)...)
+	}
+	return sortedKeys(seen)
+}
+
+// TopologicalOrder returns the node ids so that every node comes after its dependencies
+// Nodes without order between them are sorted numerically, a *CycleError is returned for cyclic prompts
+func (g *Graph) TopologicalOrder() ([]string, error) {
+	inDegree := make(map[string]int, len(g.ids))
+	for _, id := range g.ids {
+		inDegree[id] = len(g.Dependencies(id))
+	}
+
+	var ready, order []string
+	for _, id := range g.ids {
+		if inDegree[id] == 0 {
+			ready = append(ready, id)
+		}
+	}
+	for len(ready) > 0 {
+		id := ready[0]
+		ready = ready[1:]
+		order = append(order, id)
+
+		var unlocked []string
+		for _, dependent := range g.Dependents(id) {
+			inDegree[dependent]--
+			if inDegree[dependent] == 0 {
+				unlocked = append(unlocked, dependent)
+			}
+		}
+		ready = append(ready, unlocked...)
+		sortIDs(ready)
+	}
+
+	if len(order) != len(g.ids) {
+		return nil, &CycleError{Cycle: g.FindCycle()}
+	}
+	return order, nil
+}
+
+// FindCycle returns a cycle as a path whose first and last ids are equal, or nil when the graph is acyclic
+func (g *Graph) FindCycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(g.ids))
+	var path []string
+
+	var visit func(id string) []string
+	visit = func(id string) []string {
+		state[id] = visiting
+		path = append(path, id)
+		for _, dependency := range g.Dependencies(id) {
+			switch state[dependency] {
+			case visiting:
+				for i, pathID := range path {
+					if pathID == dependency {
+						cycle := append([]string{}, path[i:]...)
+						return append(cycle, dependency)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dependency); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[id] = done
+		return nil
+	}
+
+	for _, id := range g.ids {
+		if state[id] == unvisited {
+			if cycle := visit(id); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// OutputNodes returns the nodes whose class is an output node according to objectInfos, see Client.GetObjectInfos
+// When objectInfos is nil, nodes without dependents are treated as outputs
+func (g *Graph) OutputNodes(objectInfos map[string]*comfyUIclient.NodeObject) []string {
+	var outputs []string
+	for _, id := range g.ids {
+		if objectInfos == nil {
+			if len(g.dependents[id]) == 0 {
+				outputs = append(outputs, id)
+			}
+			continue
+		}
+		if info, ok := objectInfos[g.prompt[id].ClassType]; ok && info.OutputNode {
+			outputs = append(outputs, id)
+		}
+	}
+	return outputs
+}
+
+// UnusedNodes returns the nodes that no output node depends on, ComfyUI never executes them
+func (g *Graph) UnusedNodes(objectInfos map[string]*comfyUIclient.NodeObject) []string {
+	used := make(map[string]bool)
+	for _, id := range g.Upstream(g.OutputNodes(objectInfos)...) {
+		used[id] = true
+	}
+
+	var unused []string
+	for _, id := range g.ids {
+		if !used[id] {
+			unused = append(unused, id)
+		}
+	}
+	return unused
+}
+
+// ExpectedExecutingSteps returns how many executing messages the prompt is expected to produce,
+// one per node that output nodes depend on and that isn't cached
+// It's an estimate, nodes with lazy inputs may be skipped by the server
+func (g *Graph) ExpectedExecutingSteps(objectInfos map[string]*comfyUIclient.NodeObject, cached []string) int {
+	return g.ExpectedExecutingStepsFor(g.OutputNodes(objectInfos), cached)
+}
+
+// ExpectedExecutingStepsFor is ExpectedExecutingSteps for explicit targets, e.g. partial execution targets
+func (g *Graph) ExpectedExecutingStepsFor(targets []string, cached []string) int {
+	skip := make(map[string]bool, len(cached))
+	for _, id := range cached {
+		skip[id] = true
+	}
+
+	steps := 0
+	for _, id := range g.Upstream(targets...) {
+		if !skip[id] {
+			steps++
+		}
+	}
+	return steps
+}
+
+func uniqueIDs(edges []Edge, id func(Edge) string) []string {
+	seen := make(map[string]bool, len(edges))
+	for _, edge := range edges {
+		seen[id(edge)] = true
+	}
+	return sortedKeys(seen)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sortIDs(keys)
+	return keys
+}
+
+// sortIDs sorts node ids numerically when possible, so that "10" comes after "9"
+func sortIDs(ids []string) {
+	sort.Slice(ids, func(i, j int) bool {
+		a, errA := strconv.Atoi(ids[i])
+		b, errB := strconv.Atoi(ids[j])
+		switch {
+		case errA == nil && errB == nil:
+			return a < b
+		case errA == nil:
+			return true
+		case errB == nil:
+			return false
+		}
+		return ids[i] < ids[j]
+	})
+}
@@ -0,0 +1,231 @@
+package graph_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/XdpCs/comfyUIclient/graph"
+)
+
+// workflow is a text to image prompt in api format, node 11 isn't used by any output
+const workflow = `{
+	"3": {"class_type": "KSampler", "inputs": {"model": ["4", 0], "positive": ["6", 0], "negative": ["7", 0], "latent_image": ["5", 0], "seed": 1, "steps": 20}},
+	"4": {"class_type": "CheckpointLoaderSimple", "inputs": {"ckpt_name": "model.safetensors"}},
+	"5": {"class_type": "EmptyLatentImage", "inputs": {"width": 512, "height": 512, "batch_size": 1}},
+	"6": {"class_type": "CLIPTextEncode", "inputs": {"text": "a cat", "clip": ["4", 1]}},
+	"7": {"class_type": "CLIPTextEncode", "inputs": {"text": "", "clip": ["4", 1]}},
+	"8": {"class_type": "VAEDecode", "inputs": {"samples": ["3", 0], "vae": ["4", 2]}},
+	"9": {"class_type": "SaveImage", "inputs": {"images": ["8", 0], "filename_prefix": "cat"}},
+	"10": {"class_type": "PreviewImage", "inputs": {"images": ["8", 0]}},
+	"11": {"class_type": "EmptyLatentImage", "inputs": {"width": 64, "height": 64, "batch_size": 1}}
+}`
+
+var objectInfos = map[string]*comfyUIclient.NodeObject{
+	"SaveImage":    {Name: "SaveImage", OutputNode: true},
+	"PreviewImage": {Name: "PreviewImage", OutputNode: true},
+	"KSampler":     {Name: "KSampler"},
+}
+
+func newGraph(t *testing.T, data string) *graph.Graph {
+	t.Helper()
+	var prompt comfyUIclient.Prompt
+	if err := json.Unmarshal([]byte(data), &prompt); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	g, err := graph.New(prompt)
+	if err != nil {
+		t.Fatalf("graph.New: %v", err)
+	}
+	return g
+}
+
+func TestNodeWiring(t *testing.T) {
+	g := newGraph(t, workflow)
+	tests := []struct {
+		node         string
+		dependencies []string
+		dependents   []string
+	}{
+		{node: "3", dependencies: []string{"4", "5", "6", "7"}, dependents: []string{"8"}},
+		{node: "4", dependencies: []string{}, dependents: []string{"3", "6", "7", "8"}},
+		{node: "8", dependencies: []string{"3", "4"}, dependents: []string{"9", "10"}},
+		{node: "10", dependencies: []string{"8"}, dependents: []string{}},
+		{node: "11", dependencies: []string{}, dependents: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.node, func(t *testing.T) {
+			if got := g.Dependencies(tt.node); !reflect.DeepEqual(got, tt.dependencies) {
+				t.Errorf("Dependencies = %v, want %v", got, tt.dependencies)
+			}
+			if got := g.Dependents(tt.node); !reflect.DeepEqual(got, tt.dependents) {
+				t.Errorf("Dependents = %v, want %v", got, tt.dependents)
+			}
+		})
+	}
+
+	if got, want := g.Nodes(), []string{"3", "4", "5", "6", "7", "8", "9", "10", "11"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Nodes = %v, want %v", got, want)
+	}
+	var edges []graph.Edge
+	for _, edge := range g.Edges() {
+		if edge.To == "8" {
+			edges = append(edges, edge)
+		}
+	}
+	want := []graph.Edge{{From: "3", Slot: 0, To: "8", Input: "samples"}, {From: "4", Slot: 2, To: "8", Input: "vae"}}
+	if !reflect.DeepEqual(edges, want) {
+		t.Errorf("edges of 8 = %v, want %v", edges, want)
+	}
+}
+
+func TestLinkValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		edges   int
+		wantErr bool
+	}{
+		{name: "link", value: []interface{}{"1", 0}, edges: 1},
+		{name: "decoded link", value: []interface{}{"1", float64(2)}, edges: 1},
+		{name: "missing node", value: []interface{}{"9", 0}, wantErr: true},
+		{name: "fractional slot", value: []interface{}{"1", 0.5}},
+		{name: "numeric node id", value: []interface{}{1, 0}},
+		{name: "one element", value: []interface{}{"1"}},
+		{name: "string list", value: []interface{}{"a", "b"}},
+		{name: "scalar", value: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prompt := comfyUIclient.Prompt{
+				"1": {ClassType: "EmptyLatentImage", Inputs: map[string]interface{}{}},
+				"2": {ClassType: "VAEDecode", Inputs: map[string]interface{}{"samples": tt.value}},
+			}
+			g, err := graph.New(prompt)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("graph.New succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("graph.New: %v", err)
+			}
+			if edges := g.Edges(); len(edges) != tt.edges {
+				t.Fatalf("edges = %v, want %d", edges, tt.edges)
+			}
+		})
+	}
+}
+
+func TestTopologicalOrder(t *testing.T) {
+	g := newGraph(t, workflow)
+	order, err := g.TopologicalOrder()
+	if err != nil {
+		t.Fatalf("TopologicalOrder: %v", err)
+	}
+	if want := []string{"4", "5", "6", "7", "3", "8", "9", "10", "11"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+	if cycle := g.FindCycle(); cycle != nil {
+		t.Fatalf("FindCycle = %v, want none", cycle)
+	}
+}
+
+func TestCycle(t *testing.T) {
+	g := newGraph(t, `{
+		"1": {"class_type": "A", "inputs": {"in": ["3", 0]}},
+		"2": {"class_type": "B", "inputs": {"in": ["1", 0]}},
+		"3": {"class_type": "C", "inputs": {"in": ["2", 0]}},
+		"4": {"class_type": "D", "inputs": {"in": ["3", 0]}}
+	}`)
+	_, err := g.TopologicalOrder()
+	var cycleErr *graph.CycleError
+	if !errors.As(err, &cycleErr) {
+		t.Fatalf("TopologicalOrder error = %v, want a *CycleError", err)
+	}
+	if want := []string{"1", "3", "2", "1"}; !reflect.DeepEqual(cycleErr.Cycle, want) {
+		t.Fatalf("cycle = %v, want %v", cycleErr.Cycle, want)
+	}
+}
+
+func TestClosures(t *testing.T) {
+	g := newGraph(t, workflow)
+	tests := []struct {
+		name string
+		got  []string
+		want []string
+	}{
+		{name: "upstream", got: g.Upstream("8"), want: []string{"3", "4", "5", "6", "7", "8"}},
+		{name: "upstream of several", got: g.Upstream("6", "11"), want: []string{"4", "6", "11"}},
+		{name: "downstream", got: g.Downstream("6"), want: []string{"3", "6", "8", "9", "10"}},
+		{name: "missing node", got: g.Upstream("99"), want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Fatalf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutputs(t *testing.T) {
+	g := newGraph(t, workflow)
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{name: "output nodes", got: g.OutputNodes(objectInfos), want: []string{"9", "10"}},
+		{name: "nodes without dependents", got: g.OutputNodes(nil), want: []string{"9", "10", "11"}},
+		{name: "unused nodes", got: g.UnusedNodes(objectInfos), want: []string{"11"}},
+		{name: "executing steps", got: g.ExpectedExecutingSteps(objectInfos, nil), want: 8},
+		{name: "executing steps with cache", got: g.ExpectedExecutingSteps(objectInfos, []string{"4", "5"}), want: 6},
+		{name: "executing steps for targets", got: g.ExpectedExecutingStepsFor([]string{"6"}, nil), want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Fatalf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptJSON(t *testing.T) {
+	g := newGraph(t, workflow)
+	pruned, err := comfyUIclient.PrunePrompt(promptOf(g), "9")
+	if err != nil {
+		t.Fatalf("PrunePrompt: %v", err)
+	}
+	data, err := json.Marshal(pruned)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+
+	// the encoded prompt keeps its links when ComfyUI or the graph decode it again
+	decoded := newGraph(t, string(data))
+	if want := []string{"3", "4", "5", "6", "7", "8", "9"}; !reflect.DeepEqual(decoded.Nodes(), want) {
+		t.Fatalf("nodes = %v, want %v", decoded.Nodes(), want)
+	}
+	var want []graph.Edge
+	for _, edge := range g.Edges() {
+		if edge.To != "10" {
+			want = append(want, edge)
+		}
+	}
+	if !reflect.DeepEqual(decoded.Edges(), want) {
+		t.Fatalf("edges = %v, want %v", decoded.Edges(), want)
+	}
+}
+
+func promptOf(g *graph.Graph) comfyUIclient.Prompt {
+	prompt := make(comfyUIclient.Prompt)
+	for _, id := range g.Nodes() {
+		prompt[id], _ = g.Node(id)
+	}
+	return prompt
+}
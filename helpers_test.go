@@ -0,0 +1,67 @@
+package comfyUIclient_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/XdpCs/comfyUIclient/comfytest"
+)
+
+// newTestServer starts a fake ComfyUI server and a client listening to it
+func newTestServer(t *testing.T, opts ...comfyUIclient.Option) (*comfytest.Server, *comfyUIclient.Client) {
+	t.Helper()
+	server := comfytest.NewServer()
+	t.Cleanup(server.Close)
+	client, err := server.Client(opts...)
+	if err != nil {
+		t.Fatalf("server.Client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return server, client
+}
+
+// testPrompt returns a two node prompt with the output node "2"
+func testPrompt() comfyUIclient.Prompt {
+	return comfyUIclient.Prompt{
+		"1": {ClassType: "EmptyLatentImage", Inputs: map[string]interface{}{"width": 64, "height": 64, "batch_size": 1}},
+		"2": {ClassType: "SaveImage", Inputs: map[string]interface{}{"images": []interface{}{"1", 0}, "filename_prefix": "test"}},
+	}
+}
+
+// waitFor polls cond until it holds or fails the test after timeout
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// completions returns the Completed messages of client
+func completions(t *testing.T, client *comfyUIclient.Client) <-chan *comfyUIclient.WSMessageDataCompleted {
+	t.Helper()
+	completed := make(chan *comfyUIclient.WSMessageDataCompleted, 16)
+	remove := client.AddListener(func(message *comfyUIclient.WSMessage) {
+		if data, ok := message.Data.(*comfyUIclient.WSMessageDataCompleted); ok {
+			completed <- data
+		}
+	})
+	t.Cleanup(remove)
+	return completed
+}
+
+// nextCompleted waits for the next Completed message
+func nextCompleted(t *testing.T, completed <-chan *comfyUIclient.WSMessageDataCompleted) *comfyUIclient.WSMessageDataCompleted {
+	t.Helper()
+	select {
+	case data := <-completed:
+		return data
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a completed prompt")
+		return nil
+	}
+}
@@ -0,0 +1,97 @@
+package comfyUIclient
+
+import (
+	"log/slog"
+)
+
+// Logger is the structured logger used by Client and WebSocketConnection, *slog.Logger satisfies it
+//
+// Expected events such as reconnects are logged at Info, failures at Warn and Error
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// WithLogger sets the logger of the client, a nil logger discards all logs
+func WithLogger(logger Logger) Option {
+	return func(o *clientOptions) {
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		o.logger = logger
+	}
+}
+
+func defaultLogger() Logger {
+	return slog.Default()
+}
+
+// withFields returns a logger that adds args to every record
+func withFields(logger Logger, args ...any) Logger {
+	switch l := logger.(type) {
+	case *slog.Logger:
+		return l.With(args...)
+	case nopLogger:
+		return l
+	case *fieldLogger:
+		return &fieldLogger{logger: l.logger, args: append(append([]any{}, l.args...), args...)}
+	}
+	return &fieldLogger{logger: logger, args: args}
+}
+
+type fieldLogger struct {
+	logger Logger
+	args   []any
+}
+
+func (f *fieldLogger) with(args []any) []any {
+	return append(append(make([]any, 0, len(f.args)+len(args)), f.args...), args...)
+}
+
+func (f *fieldLogger) Debug(msg string, args ...any) { f.logger.Debug(msg, f.with(args)...) }
+func (f *fieldLogger) Info(msg string, args ...any)  { f.logger.Info(msg, f.with(args)...) }
+func (f *fieldLogger) Warn(msg string, args ...any)  { f.logger.Warn(msg, f.with(args)...) }
+func (f *fieldLogger) Error(msg string, args ...any) { f.logger.Error(msg, f.with(args)...) }
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+// messageFields returns the prompt and node ids of a websocket message as log fields
+func messageFields(message *WSMessage) []any {
+	fields := []any{"type", message.Type}
+	switch data := message.Data.(type) {
+	case *WSMessageDataExecutionStart:
+		fields = append(fields, "prompt_id", data.PromptID)
+	case *WSMessageDataExecutionCached:
+		fields = append(fields, "prompt_id", data.PromptID)
+	case *WSMessageDataExecuting:
+		fields = append(fields, "prompt_id", data.PromptID, "node_id", data.Node)
+	case *WSMessageDataExecuted:
+		fields = append(fields, "prompt_id", data.PromptID, "node_id", data.Node)
+	case *WSMessageExecutionInterrupted:
+		fields = append(fields, "prompt_id", data.PromptID, "node_id", data.NodeID)
+	case *WSMessageExecutionError:
+		fields = append(fields, "prompt_id", data.PromptID, "node_id", data.Node)
+	case *WSMessageDataProgress:
+		fields = append(fields, "prompt_id", data.PromptID, "node_id", data.Node)
+	case *WSMessageDataExecutionSuccess:
+		fields = append(fields, "prompt_id", data.PromptID)
+	case *WSMessageDataProgressState:
+		fields = append(fields, "prompt_id", data.PromptID)
+	case *WSMessageDataPreviewImage:
+		fields = append(fields, "prompt_id", data.PromptID, "node_id", data.NodeID)
+	case *WSMessageDataProgressText:
+		fields = append(fields, "node_id", data.NodeID)
+	case *WSMessageDataCompleted:
+		fields = append(fields, "prompt_id", data.PromptID, "status", data.Status)
+	case *WSMessageDataQueued:
+		fields = append(fields, "prompt_id", data.PromptID)
+	}
+	return fields
+}
@@ -0,0 +1,379 @@
+// Package metrics exposes the state of comfyUIclient clients in the Prometheus text format
+//
+// A Collector counts the http requests and websocket connections of a client through its transports,
+// and the events and prompts of the client through a listener. Queue depth and gpu memory are read
+// with GetQueueRemaining and GetSystemStats whenever the metrics are scraped:
+//
+//	collector := metrics.New(nil)
+//	client, err := comfyUIclient.New(url, collector.ClientOptions("gpu-1")...)
+//	remove := collector.Register("gpu-1", client)
+//	defer remove()
+//	http.Handle("/metrics", collector)
+//
+// All metrics carry a backend label with the name the client was registered with.
+// A stuck queue shows up as comfyui_queue_remaining above zero while
+// comfyui_last_execution_event_timestamp_seconds stops advancing.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+// Options configures a Collector
+type Options struct {
+	// RequestBuckets are the histogram buckets of http request latencies in seconds, DefaultRequestBuckets when nil
+	RequestBuckets []float64
+	// PromptBuckets are the histogram buckets of prompt durations in seconds, DefaultPromptBuckets when nil
+	PromptBuckets []float64
+	// ScrapeTimeout limits how long a scrape waits for GetQueueRemaining and GetSystemStats, 5 seconds when zero
+	// Slower answers are used by the next scrape
+	ScrapeTimeout time.Duration
+}
+
+// DefaultRequestBuckets are the request latency buckets used when Options.RequestBuckets is nil
+var DefaultRequestBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// DefaultPromptBuckets are the prompt duration buckets used when Options.PromptBuckets is nil
+var DefaultPromptBuckets = []float64{1, 2.5, 5, 10, 20, 30, 60, 120, 300, 600, 1200, 3600}
+
+// routers are the api routes used as router label, requests to other paths are labeled "other"
+var routers = []comfyUIclient.Router{
+	comfyUIclient.PromptRouter,
+	comfyUIclient.HistoryRouter,
+	comfyUIclient.ViewRouter,
+	comfyUIclient.ViewMetadataRouter,
+	comfyUIclient.EmbeddingsRouter,
+	comfyUIclient.ExtensionsRouter,
+	comfyUIclient.SystemStatsRouter,
+	comfyUIclient.InterruptRouter,
+	comfyUIclient.QueueRouter,
+	comfyUIclient.ObjectInfoRouter,
+	comfyUIclient.UploadImageRouter,
+	comfyUIclient.UploadMaskRouter,
+	comfyUIclient.ModelsRouter,
+}
+
+// Collector collects metrics of registered clients and serves them, it is safe for concurrent use
+type Collector struct {
+	scrapeTimeout time.Duration
+
+	mu       sync.Mutex
+	backends map[string]*backend
+	families []*family
+
+	httpRequests   *family
+	httpDuration   *family
+	wsConnects     *family
+	wsReconnects   *family
+	wsDialErrors   *family
+	wsConnected    *family
+	events         *family
+	prompts        *family
+	promptDuration *family
+	queueRemaining *family
+	lastExecution  *family
+	vramTotal      *family
+	vramFree       *family
+	torchVRAMTotal *family
+	torchVRAMFree  *family
+	scrapeErrors   *family
+	scrapeDuration *family
+}
+
+type backend struct {
+	name       string
+	client     *comfyUIclient.Client
+	refreshing atomic.Bool
+}
+
+// New returns a collector, opts may be nil
+func New(opts *Options) *Collector {
+	if opts == nil {
+		opts = &Options{}
+	}
+	requestBuckets := opts.RequestBuckets
+	if requestBuckets == nil {
+		requestBuckets = DefaultRequestBuckets
+	}
+	promptBuckets := opts.PromptBuckets
+	if promptBuckets == nil {
+		promptBuckets = DefaultPromptBuckets
+	}
+	c := &Collector{
+		scrapeTimeout: opts.ScrapeTimeout,
+		backends:      make(map[string]*backend),
+	}
+	if c.scrapeTimeout <= 0 {
+		c.scrapeTimeout = 5 * time.Second
+	}
+
+	c.httpRequests = c.newFamily("comfyui_http_requests_total", "HTTP requests sent to ComfyUI, code is \"error\" when no response was received.", counter, "backend", "router", "method", "code")
+	c.httpDuration = c.newFamily("comfyui_http_request_duration_seconds", "Latency of HTTP requests until the response headers arrived.", histogram, "backend", "router")
+	c.httpDuration.buckets = requestBuckets
+	c.wsConnects = c.newFamily("comfyui_websocket_connects_total", "Successful websocket connections.", counter, "backend")
+	c.wsReconnects = c.newFamily("comfyui_websocket_reconnects_total", "Successful websocket connections after the first one.", counter, "backend")
+	c.wsDialErrors = c.newFamily("comfyui_websocket_dial_errors_total", "Failed websocket dials.", counter, "backend")
+	c.wsConnected = c.newFamily("comfyui_websocket_connected", "Whether the websocket is connected.", gauge, "backend")
+	c.events = c.newFamily("comfyui_events_total", "Messages passed to listeners by message type.", counter, "backend", "type")
+	c.prompts = c.newFamily("comfyui_prompts_total", "Completed prompts by outcome.", counter, "backend", "outcome")
+	c.promptDuration = c.newFamily("comfyui_prompt_duration_seconds", "Execution time of completed prompts whose start was seen.", histogram, "backend", "outcome")
+	c.promptDuration.buckets = promptBuckets
+	c.queueRemaining = c.newFamily("comfyui_queue_remaining", "Prompts running or pending, from status events and GetQueueRemaining.", gauge, "backend")
+	c.lastExecution = c.newFamily("comfyui_last_execution_event_timestamp_seconds", "Unix time of the last execution event of any prompt.", gauge, "backend")
+	c.vramTotal = c.newFamily("comfyui_gpu_vram_total_bytes", "Total VRAM of a device.", gauge, "backend", "index", "device")
+	c.vramFree = c.newFamily("comfyui_gpu_vram_free_bytes", "Free VRAM of a device.", gauge, "backend", "index", "device")
+	c.torchVRAMTotal = c.newFamily("comfyui_gpu_torch_vram_total_bytes", "VRAM reserved by torch on a device.", gauge, "backend", "index", "device")
+	c.torchVRAMFree = c.newFamily("comfyui_gpu_torch_vram_free_bytes", "VRAM reserved by torch and unused on a device.", gauge, "backend", "index", "device")
+	c.scrapeErrors = c.newFamily("comfyui_scrape_errors_total", "Failed GetQueueRemaining and GetSystemStats calls made while scraping.", counter, "backend", "call")
+	c.scrapeDuration = c.newFamily("comfyui_scrape_duration_seconds", "Time the last scrape waited for the ComfyUI servers.", gauge)
+	return c
+}
+
+func (c *Collector) newFamily(name, help string, k kind, labels ...string) *family {
+	f := newFamily(name, help, k, labels...)
+	c.families = append(c.families, f)
+	return f
+}
+
+// ClientOptions returns the client options that count the http requests and websocket connections of the client named name
+func (c *Collector) ClientOptions(name string) []comfyUIclient.Option {
+	return []comfyUIclient.Option{
+		comfyUIclient.WithHTTPTransport(func(next http.RoundTripper) http.RoundTripper {
+			return &transport{collector: c, name: name, next: next}
+		}),
+		comfyUIclient.WithWebSocketTransport(func(next comfyUIclient.WebSocketDialer) comfyUIclient.WebSocketDialer {
+			return &dialer{collector: c, name: name, next: next}
+		}),
+	}
+}
+
+// Register counts the events and prompts of client and scrapes its queue and gpus under name
+// The returned func unregisters the client and drops its metrics
+func (c *Collector) Register(name string, client *comfyUIclient.Client) func() {
+	b := &backend{name: name, client: client}
+	c.mu.Lock()
+	c.backends[name] = b
+	c.mu.Unlock()
+
+	remove := client.AddListener(func(message *comfyUIclient.WSMessage) {
+		c.handle(name, message)
+	})
+	return func() {
+		remove()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.backends[name] != b {
+			return
+		}
+		delete(c.backends, name)
+		for _, f := range c.families {
+			if len(f.labels) != 0 && f.labels[0] == "backend" {
+				f.deletePrefix(name)
+			}
+		}
+	}
+}
+
+func (c *Collector) handle(name string, message *comfyUIclient.WSMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events.add(1, name, string(message.Type))
+
+	switch data := message.Data.(type) {
+	case *comfyUIclient.WSMessageDataStatus:
+		c.queueRemaining.set(float64(data.Status.ExecInfo.QueueRemaining), name)
+	case *comfyUIclient.WSMessageDataCompleted:
+		outcome := outcomeOf(data.Status)
+		c.prompts.add(1, name, outcome)
+		if !data.StartedAt.IsZero() {
+			c.promptDuration.observe(data.Duration().Seconds(), name, outcome)
+		}
+	}
+	switch message.Type {
+	case comfyUIclient.ExecutionStart, comfyUIclient.ExecutionCached, comfyUIclient.Executing, comfyUIclient.Progress,
+		comfyUIclient.Executed, comfyUIclient.ExecutionSuccess, comfyUIclient.ExecutionError, comfyUIclient.ExecutionInterrupted:
+		c.lastExecution.set(float64(time.Now().UnixNano())/1e9, name)
+	}
+}
+
+func outcomeOf(status comfyUIclient.WsMessageType) string {
+	switch status {
+	case comfyUIclient.ExecutionError:
+		return "error"
+	case comfyUIclient.ExecutionInterrupted:
+		return "interrupted"
+	}
+	return "success"
+}
+
+// ServeHTTP refreshes the queue depth and gpu memory of the registered clients and writes all metrics
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
+	c.refresh(r.Context())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scrapeDuration.set(time.Since(started).Seconds())
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = writeText(w, c.families)
+}
+
+// refresh scrapes all backends concurrently, a backend still answering a previous scrape is skipped
+func (c *Collector) refresh(ctx context.Context) {
+	c.mu.Lock()
+	backends := make([]*backend, 0, len(c.backends))
+	for _, b := range c.backends {
+		backends = append(backends, b)
+	}
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.scrapeTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, b := range backends {
+		if !b.refreshing.CompareAndSwap(false, true) {
+			continue
+		}
+		wg.Add(1)
+		done := make(chan struct{})
+		go func(b *backend) {
+			defer b.refreshing.Store(false)
+			defer close(done)
+			c.scrape(b)
+		}(b)
+		go func() {
+			defer wg.Done()
+			select {
+			case <-done:
+			case <-ctx.Done():
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (c *Collector) scrape(b *backend) {
+	remaining, queueErr := b.client.GetQueueRemaining()
+	stats, statsErr := b.client.GetSystemStats()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the client may have been unregistered while waiting
+	if c.backends[b.name] != b {
+		return
+	}
+	if queueErr != nil {
+		c.scrapeErrors.add(1, b.name, "queue_remaining")
+	} else {
+		c.queueRemaining.set(float64(remaining), b.name)
+	}
+	if statsErr != nil {
+		c.scrapeErrors.add(1, b.name, "system_stats")
+		return
+	}
+	for _, device := range stats.Devices {
+		index := strconv.Itoa(device.Index)
+		c.vramTotal.set(float64(device.VRAMTotal), b.name, index, device.Name)
+		c.vramFree.set(float64(device.VRAMFree), b.name, index, device.Name)
+		c.torchVRAMTotal.set(float64(device.TorchVRAMTotal), b.name, index, device.Name)
+		c.torchVRAMFree.set(float64(device.TorchVRAMFree), b.name, index, device.Name)
+	}
+}
+
+// routerOf returns the router of an api path, the base path of the server is skipped
+func routerOf(path string) string {
+	best, bestIndex := "other", -1
+	for _, router := range routers {
+		from := 0
+		for {
+			i := strings.Index(path[from:], string(router))
+			if i < 0 {
+				break
+			}
+			i += from
+			end := i + len(router)
+			if end == len(path) || path[end] == '/' {
+				if bestIndex < 0 || i < bestIndex {
+					best, bestIndex = string(router), i
+				}
+				break
+			}
+			from = i + 1
+		}
+	}
+	return best
+}
+
+type transport struct {
+	collector *Collector
+	name      string
+	next      http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	started := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(started).Seconds()
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	router := routerOf(req.URL.Path)
+	c := t.collector
+	c.mu.Lock()
+	c.httpRequests.add(1, t.name, router, req.Method, code)
+	c.httpDuration.observe(elapsed, t.name, router)
+	c.mu.Unlock()
+	return resp, err
+}
+
+type dialer struct {
+	collector *Collector
+	name      string
+	next      comfyUIclient.WebSocketDialer
+	connects  atomic.Int64
+}
+
+func (d *dialer) Dial(url string, header http.Header) (comfyUIclient.WebSocketConn, *http.Response, error) {
+	conn, resp, err := d.next.Dial(url, header)
+	c := d.collector
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.wsDialErrors.add(1, d.name)
+		return conn, resp, err
+	}
+	c.wsConnects.add(1, d.name)
+	if d.connects.Add(1) > 1 {
+		c.wsReconnects.add(1, d.name)
+	}
+	c.wsConnected.set(1, d.name)
+	return &trackedConn{WebSocketConn: conn, dialer: d}, resp, nil
+}
+
+// trackedConn marks the websocket as disconnected once reading fails
+type trackedConn struct {
+	comfyUIclient.WebSocketConn
+	dialer *dialer
+	failed atomic.Bool
+}
+
+func (c *trackedConn) ReadMessage() (int, []byte, error) {
+	messageType, data, err := c.WebSocketConn.ReadMessage()
+	if err != nil && c.failed.CompareAndSwap(false, true) {
+		collector := c.dialer.collector
+		collector.mu.Lock()
+		collector.wsConnected.set(0, c.dialer.name)
+		collector.mu.Unlock()
+	}
+	return messageType, data, err
+}
@@ -0,0 +1,177 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/XdpCs/comfyUIclient/comfytest"
+)
+
+func TestRouterOf(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/prompt", want: "/prompt"},
+		{path: "/api/prompt", want: "/prompt"},
+		{path: "/view", want: "/view"},
+		{path: "/view_metadata/checkpoints", want: "/view_metadata"},
+		{path: "/api/view_metadata/loras", want: "/view_metadata"},
+		{path: "/history/abc", want: "/history"},
+		{path: "/object_info/KSampler", want: "/object_info"},
+		{path: "/upload/image", want: "/upload/image"},
+		{path: "/models/checkpoints", want: "/models"},
+		// the base path of the server may contain router names
+		{path: "/prompts/view", want: "/view"},
+		{path: "/comfy/queue/history/abc", want: "/queue"},
+		{path: "/viewer", want: "other"},
+		{path: "/", want: "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := routerOf(tt.path); got != tt.want {
+				t.Fatalf("routerOf(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	requests := newFamily("requests_total", "Requests\nby code.", counter, "backend", "code")
+	requests.add(1, "a", "200")
+	requests.add(2, "a", "200")
+	requests.add(1, `b"\`, "error")
+	up := newFamily("up", "Whether it's up.", gauge)
+	up.set(1)
+	empty := newFamily("empty", "Never set.", gauge, "backend")
+	duration := newFamily("duration_seconds", "Durations.", histogram, "backend")
+	duration.buckets = []float64{0.5, 1}
+	duration.observe(0.25, "a")
+	duration.observe(2, "a")
+
+	var b strings.Builder
+	if err := writeText(&b, []*family{requests, up, empty, duration}); err != nil {
+		t.Fatalf("writeText: %v", err)
+	}
+	want := `# HELP requests_total Requests\nby code.
+# TYPE requests_total counter
+requests_total{backend="a",code="200"} 3
+requests_total{backend="b\"\\",code="error"} 1
+# HELP up Whether it's up.
+# TYPE up gauge
+up 1
+# HELP duration_seconds Durations.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{backend="a",le="0.5"} 1
+duration_seconds_bucket{backend="a",le="1"} 1
+duration_seconds_bucket{backend="a",le="+Inf"} 2
+duration_seconds_sum{backend="a"} 2.25
+duration_seconds_count{backend="a"} 2
+`
+	if b.String() != want {
+		t.Fatalf("text:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	f := newFamily("h", "", histogram)
+	f.buckets = []float64{1, 2, 5}
+	tests := []struct {
+		value  float64
+		counts []uint64
+	}{
+		// a value on a bound belongs to that bucket, le is inclusive
+		{value: 1, counts: []uint64{1, 0, 0, 0}},
+		{value: 0, counts: []uint64{2, 0, 0, 0}},
+		{value: 1.5, counts: []uint64{2, 1, 0, 0}},
+		{value: 5, counts: []uint64{2, 1, 1, 0}},
+		{value: 100, counts: []uint64{2, 1, 1, 1}},
+		{value: math.Inf(1), counts: []uint64{2, 1, 1, 2}},
+	}
+	for _, tt := range tests {
+		f.observe(tt.value)
+		s := f.get(nil)
+		for i, count := range tt.counts {
+			if s.counts[i] != count {
+				t.Fatalf("after observing %v counts = %v, want %v", tt.value, s.counts, tt.counts)
+			}
+		}
+	}
+	if s := f.get(nil); s.count != uint64(len(tests)) {
+		t.Fatalf("count = %d, want %d", s.count, len(tests))
+	}
+}
+
+func scrape(t *testing.T, c *Collector) string {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	c.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if got := recorder.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Fatalf("Content-Type = %q", got)
+	}
+	body, _ := io.ReadAll(recorder.Body)
+	return string(body)
+}
+
+func TestRegister(t *testing.T) {
+	server := comfytest.NewServer()
+	defer server.Close()
+	c := New(nil)
+	client, err := server.Client(c.ClientOptions("gpu-1")...)
+	if err != nil {
+		t.Fatalf("server.Client: %v", err)
+	}
+	defer client.Close()
+	remove := c.Register("gpu-1", client)
+
+	completed := make(chan struct{}, 1)
+	removeListener := client.AddListener(func(message *comfyUIclient.WSMessage) {
+		if message.Type == comfyUIclient.Completed {
+			completed <- struct{}{}
+		}
+	})
+	defer removeListener()
+	prompt := comfyUIclient.Prompt{
+		"1": {ClassType: "EmptyLatentImage", Inputs: map[string]interface{}{"width": 64, "height": 64, "batch_size": 1}},
+		"2": {ClassType: "SaveImage", Inputs: map[string]interface{}{"images": []interface{}{"1", 0}, "filename_prefix": "metrics"}},
+	}
+	if _, err := client.QueuePromptByNodesWithOptions(context.Background(), prompt, nil); err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	select {
+	case <-completed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the prompt to complete")
+	}
+
+	text := scrape(t, c)
+	for _, sample := range []string{
+		`comfyui_http_requests_total{backend="gpu-1",router="/prompt",method="POST",code="200"} 1`,
+		`comfyui_websocket_connects_total{backend="gpu-1"} 1`,
+		`comfyui_websocket_connected{backend="gpu-1"} 1`,
+		`comfyui_prompts_total{backend="gpu-1",outcome="success"} 1`,
+		`comfyui_queue_remaining{backend="gpu-1"} 0`,
+		`comfyui_gpu_vram_total_bytes{backend="gpu-1",index="0",`,
+	} {
+		if !strings.Contains(text, sample) {
+			t.Fatalf("metrics miss %s:\n%s", sample, text)
+		}
+	}
+
+	// unregistering drops every series of the backend
+	remove()
+	text = scrape(t, c)
+	if strings.Contains(text, `backend="gpu-1"`) {
+		t.Fatalf("metrics of the removed backend are still served:\n%s", text)
+	}
+	if !strings.Contains(text, "comfyui_scrape_duration_seconds ") {
+		t.Fatalf("metrics miss the scrape duration:\n%s", text)
+	}
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type kind string
+
+const (
+	counter   kind = "counter"
+	gauge     kind = "gauge"
+	histogram kind = "histogram"
+)
+
+// family is a metric with all its label combinations
+type family struct {
+	name    string
+	help    string
+	kind    kind
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+	// counts are the non-cumulative bucket counts of a histogram, the last one is +Inf
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newFamily(name, help string, k kind, labels ...string) *family {
+	return &family{name: name, help: help, kind: k, labels: labels, series: make(map[string]*series)}
+}
+
+func (f *family) get(values []string) *series {
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.kind == histogram {
+			s.counts = make([]uint64, len(f.buckets)+1)
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *family) add(delta float64, values ...string) {
+	f.get(values).value += delta
+}
+
+func (f *family) set(value float64, values ...string) {
+	f.get(values).value = value
+}
+
+func (f *family) observe(value float64, values ...string) {
+	s := f.get(values)
+	s.counts[sort.SearchFloat64s(f.buckets, value)]++
+	s.sum += value
+	s.count++
+}
+
+// deletePrefix removes the series whose first label values are values
+func (f *family) deletePrefix(values ...string) {
+	for key, s := range f.series {
+		match := true
+		for i, value := range values {
+			if s.values[i] != value {
+				match = false
+				break
+			}
+		}
+		if match {
+			delete(f.series, key)
+		}
+	}
+}
+
+// writeText writes the families in the Prometheus text exposition format
+func writeText(w io.Writer, families []*family) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		if len(f.series) == 0 {
+			continue
+		}
+		bw.WriteString("# HELP " + f.name + " " + escapeHelp(f.help) + "\n")
+		bw.WriteString("# TYPE " + f.name + " " + string(f.kind) + "\n")
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := f.series[key]
+			if f.kind != histogram {
+				writeSample(bw, f.name, f.labels, s.values, "", "", s.value)
+				continue
+			}
+			var cumulative uint64
+			for i, upper := range f.buckets {
+				cumulative += s.counts[i]
+				writeSample(bw, f.name+"_bucket", f.labels, s.values, "le", formatFloat(upper), float64(cumulative))
+			}
+			writeSample(bw, f.name+"_bucket", f.labels, s.values, "le", "+Inf", float64(s.count))
+			writeSample(bw, f.name+"_sum", f.labels, s.values, "", "", s.sum)
+			writeSample(bw, f.name+"_count", f.labels, s.values, "", "", float64(s.count))
+		}
+	}
+	return bw.Flush()
+}
+
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) != 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i != 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(label + `="` + escapeLabel(values[i]) + `"`)
+		}
+		if extraLabel != "" {
+			if len(labels) != 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraLabel + `="` + extraValue + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,269 @@
+package comfyUIclient
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Option configures a Client created by New
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	clientID        string
+	httpClient      *http.Client
+	timeout         time.Duration
+	dialer          *websocket.Dialer
+	tlsConfig       *tls.Config
+	proxy           func(*http.Request) (*url.URL, error)
+	reconnect       ReconnectPolicy
+	eventBufferSize int
+	userAgent       string
+	authenticator   Authenticator
+	logger          Logger
+	retryPolicy     RetryPolicy
+	transportMode   TransportMode
+	pollMinInterval time.Duration
+	pollMaxInterval time.Duration
+	resyncInterval  time.Duration
+	vanishTimeout   time.Duration
+	httpTransports  []func(http.RoundTripper) http.RoundTripper
+	wsTransports    []func(WebSocketDialer) WebSocketDialer
+	tracer          Tracer
+}
+
+// ReconnectPolicy controls how the websocket connection is re-established
+type ReconnectPolicy struct {
+	// MaxRetry is the number of dial attempts each time the connection is lost
+	MaxRetry int
+	// Interval is the time between connection checks
+	Interval time.Duration
+}
+
+// DefaultReconnectPolicy returns the policy used when no WithReconnectPolicy option is given
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		MaxRetry: 3,
+		Interval: 5 * time.Second,
+	}
+}
+
+// WithClientID sets the client id used for the websocket
+// Reuse the id of a previous run to keep receiving events for its prompts
+func WithClientID(id string) Option {
+	return func(o *clientOptions) {
+		o.clientID = id
+	}
+}
+
+// WithHTTPClient sets the http client used for api requests
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(o *clientOptions) {
+		o.httpClient = httpClient
+	}
+}
+
+// WithTimeout sets the timeout of the default http client, it is ignored when WithHTTPClient is used
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *clientOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithWebSocketDialer sets the dialer used for the websocket, the dialer is copied and never modified
+func WithWebSocketDialer(dialer *websocket.Dialer) Option {
+	return func(o *clientOptions) {
+		o.dialer = dialer
+	}
+}
+
+// WithTLSConfig sets the tls config for both http requests and the websocket
+func WithTLSConfig(config *tls.Config) Option {
+	return func(o *clientOptions) {
+		o.tlsConfig = config
+	}
+}
+
+// WithProxy sets the proxy for both http requests and the websocket, e.g. http.ProxyURL(proxyURL)
+func WithProxy(proxy func(*http.Request) (*url.URL, error)) Option {
+	return func(o *clientOptions) {
+		o.proxy = proxy
+	}
+}
+
+// WithReconnectPolicy sets how the websocket reconnects
+func WithReconnectPolicy(policy ReconnectPolicy) Option {
+	return func(o *clientOptions) {
+		o.reconnect = policy
+	}
+}
+
+// WithEventBufferSize sets the buffer size of the channel returned by GetTaskStatus
+// A negative size disables the channel, messages are then only delivered to listeners, see AddListener
+func WithEventBufferSize(size int) Option {
+	return func(o *clientOptions) {
+		o.eventBufferSize = size
+	}
+}
+
+// WithUserAgent sets the User-Agent header for http requests and the websocket handshake
+func WithUserAgent(userAgent string) Option {
+	return func(o *clientOptions) {
+		o.userAgent = userAgent
+	}
+}
+
+// WithAuthenticator sets the Authenticator used for http requests and the websocket handshake
+func WithAuthenticator(authenticator Authenticator) Option {
+	return func(o *clientOptions) {
+		o.authenticator = authenticator
+	}
+}
+
+// WithHTTPTransport wraps the transport of the http client, e.g. to record, replay or instrument api requests
+// wrap receives the configured transport, options given later wrap the result of earlier ones
+func WithHTTPTransport(wrap func(next http.RoundTripper) http.RoundTripper) Option {
+	return func(o *clientOptions) {
+		o.httpTransports = append(o.httpTransports, wrap)
+	}
+}
+
+// WithWebSocketTransport wraps the dialer of the websocket, e.g. to record or replay received messages
+// wrap receives the configured dialer, options given later wrap the result of earlier ones
+func WithWebSocketTransport(wrap func(next WebSocketDialer) WebSocketDialer) Option {
+	return func(o *clientOptions) {
+		o.wsTransports = append(o.wsTransports, wrap)
+	}
+}
+
+// New creates a client for the ComfyUI server at baseURL, e.g. "https://host:8188/comfy"
+func New(baseURL string, opts ...Option) (*Client, error) {
+	endPoint, err := NewEndPointFromURL(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("NewEndPointFromURL: error: %w", err)
+	}
+	return NewWithEndPoint(endPoint, opts...)
+}
+
+// NewWithEndPoint creates a client for endPoint, endPoint is not modified
+func NewWithEndPoint(endPoint *EndPoint, opts ...Option) (*Client, error) {
+	o := &clientOptions{
+		timeout:     10 * time.Second,
+		reconnect:   DefaultReconnectPolicy(),
+		logger:      defaultLogger(),
+		retryPolicy: DefaultRetryPolicy(),
+		tracer:      noopTracer{},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	httpClient, err := o.buildHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	if len(o.httpTransports) != 0 {
+		transport := httpClient.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		for _, wrap := range o.httpTransports {
+			transport = wrap(transport)
+		}
+		wrapped := *httpClient
+		wrapped.Transport = transport
+		httpClient = &wrapped
+	}
+	if o.clientID == "" {
+		o.clientID = uuid.New().String()
+	}
+	c := &Client{
+		ID:            o.clientID,
+		endPoint:      *endPoint,
+		baseURL:       endPoint.URL(""),
+		httpClient:    httpClient,
+		userAgent:     o.userAgent,
+		authenticator: o.authenticator,
+		logger:        withFields(o.logger, "client_id", o.clientID, "endpoint", endPoint.String()),
+		retryPolicy:   o.retryPolicy,
+		tracer:        o.tracer,
+	}
+
+	if o.eventBufferSize >= 0 {
+		c.ch = make(chan *WSMessage, o.eventBufferSize)
+	}
+
+	c.transportMode = o.transportMode
+	c.poller = newPoller(c, o)
+
+	c.webSocket = NewWebSocketConnection(endPoint.WebSocketURL(c.ID), o.reconnect.MaxRetry, c)
+	c.webSocket.Dialer = o.buildDialer()
+	if len(o.wsTransports) != 0 {
+		transport := DialerTransport(c.webSocket.Dialer)
+		for _, wrap := range o.wsTransports {
+			transport = wrap(transport)
+		}
+		c.webSocket.Transport = transport
+	}
+	c.webSocket.ReconnectInterval = o.reconnect.Interval
+	c.webSocket.Authenticator = o.authenticator
+	c.webSocket.Logger = c.logger
+	c.webSocket.onStateChange = c.websocketStateChanged
+	if o.userAgent != "" {
+		c.webSocket.Header = http.Header{"User-Agent": {o.userAgent}}
+	}
+	return c, nil
+}
+
+func (o *clientOptions) buildHTTPClient() (*http.Client, error) {
+	if o.httpClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		o.applyTransport(transport)
+		return &http.Client{Timeout: o.timeout, Transport: transport}, nil
+	}
+	if o.tlsConfig == nil && o.proxy == nil {
+		return o.httpClient, nil
+	}
+
+	var transport *http.Transport
+	switch t := o.httpClient.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		return nil, fmt.Errorf("WithTLSConfig and WithProxy need an *http.Transport, got %T", t)
+	}
+	o.applyTransport(transport)
+	httpClient := *o.httpClient
+	httpClient.Transport = transport
+	return &httpClient, nil
+}
+
+func (o *clientOptions) applyTransport(transport *http.Transport) {
+	if o.tlsConfig != nil {
+		transport.TLSClientConfig = o.tlsConfig.Clone()
+	}
+	if o.proxy != nil {
+		transport.Proxy = o.proxy
+	}
+}
+
+func (o *clientOptions) buildDialer() *websocket.Dialer {
+	dialer := *websocket.DefaultDialer
+	if o.dialer != nil {
+		dialer = *o.dialer
+	}
+	if o.tlsConfig != nil {
+		dialer.TLSClientConfig = o.tlsConfig.Clone()
+	}
+	if o.proxy != nil {
+		dialer.Proxy = o.proxy
+	}
+	return &dialer
+}
@@ -0,0 +1,431 @@
+package comfyUIclient
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TransportMode selects how the client learns about prompt state
+type TransportMode int
+
+const (
+	// TransportWebSocket receives events over the websocket, it is the default
+	TransportWebSocket TransportMode = iota
+	// TransportPolling polls /queue and /history for prompts queued by this client and emits synthetic events
+	TransportPolling
+	// TransportAuto uses the websocket and polls while it is disconnected, including when it can't be dialed at all
+	TransportAuto
+)
+
+// WithTransportMode sets how the client learns about prompt state
+func WithTransportMode(mode TransportMode) Option {
+	return func(o *clientOptions) {
+		o.transportMode = mode
+	}
+}
+
+// WithPollInterval sets the polling interval, it starts at min and doubles up to max while nothing changes
+func WithPollInterval(min, max time.Duration) Option {
+	return func(o *clientOptions) {
+		o.pollMinInterval = min
+		o.pollMaxInterval = max
+	}
+}
+
+// WithResync sets how often the prompts of this client are checked against /queue and /history while the websocket is connected,
+// and how long a prompt may be missing from both before it is reported as interrupted, 30 seconds and one minute when zero
+// The check completes prompts whose final messages were lost, e.g. while the websocket was reconnecting
+func WithResync(interval, vanishTimeout time.Duration) Option {
+	return func(o *clientOptions) {
+		o.resyncInterval = interval
+		o.vanishTimeout = vanishTimeout
+	}
+}
+
+type polledPrompt struct {
+	started bool
+	// missingSince is when the prompt was first found neither in the queue nor in the history
+	missingSince time.Time
+	// unqueued is set once a resync found the prompt finished, it is completed by the next resync
+	unqueued bool
+}
+
+// poller tracks the prompts queued by this client by polling /queue and /history
+// It polls continuously while active, otherwise it only resyncs, see WithResync
+type poller struct {
+	client         *Client
+	active         atomic.Bool
+	minInterval    time.Duration
+	maxInterval    time.Duration
+	resyncInterval time.Duration
+	vanishTimeout  time.Duration
+
+	mu      sync.Mutex
+	prompts map[string]*polledPrompt
+	wake    chan struct{}
+	resync  chan struct{}
+
+	startOnce sync.Once
+	// ctx is canceled when the client is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newPoller(client *Client, o *clientOptions) *poller {
+	minInterval, maxInterval := o.pollMinInterval, o.pollMaxInterval
+	if minInterval <= 0 {
+		minInterval = 500 * time.Millisecond
+	}
+	if maxInterval < minInterval {
+		maxInterval = minInterval
+	}
+	resyncInterval := o.resyncInterval
+	if resyncInterval <= 0 {
+		resyncInterval = 30 * time.Second
+	}
+	vanishTimeout := o.vanishTimeout
+	if vanishTimeout <= 0 {
+		vanishTimeout = time.Minute
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &poller{
+		ctx:            ctx,
+		cancel:         cancel,
+		client:         client,
+		minInterval:    minInterval,
+		maxInterval:    maxInterval,
+		resyncInterval: resyncInterval,
+		vanishTimeout:  vanishTimeout,
+		prompts:        make(map[string]*polledPrompt),
+		wake:           make(chan struct{}, 1),
+		resync:         make(chan struct{}, 1),
+	}
+}
+
+// WatchPrompt makes the client track promptID until it completed, e.g. a prompt queued before a restart
+// Prompts queued by this client are watched automatically
+func (c *Client) WatchPrompt(promptID string) {
+	c.poller.track(promptID)
+}
+
+// IsPolling reports whether the client tracks prompts by polling instead of the websocket
+// With TransportAuto it polls while the websocket is disconnected
+func (c *Client) IsPolling() bool {
+	return c.poller.active.Load()
+}
+
+// Close stops listening for events, the client can still send requests but can't listen again
+// The spans of prompts that didn't complete yet end with an error
+func (c *Client) Close() error {
+	c.poller.stop()
+	c.endPromptTraces(errClientClosed)
+	if err := c.webSocket.stop(); err != nil {
+		return fmt.Errorf("c.webSocket.stop: error: %w", err)
+	}
+	return nil
+}
+
+// websocketStateChanged moves prompt tracking between the websocket and polling
+func (c *Client) websocketStateChanged(connected bool) {
+	if c.poller.ctx.Err() != nil {
+		return
+	}
+	switch {
+	case c.transportMode == TransportAuto && !connected:
+		if !c.poller.active.Swap(true) {
+			c.logger.Warn("websocket disconnected, polling until it reconnects")
+			notify(c.poller.wake)
+		}
+	case c.transportMode == TransportAuto:
+		if c.poller.active.Swap(false) {
+			c.logger.Info("websocket reconnected, stopped polling")
+		}
+		notify(c.poller.resync)
+	case c.transportMode == TransportWebSocket && connected:
+		// messages sent while the websocket was down are lost
+		notify(c.poller.resync)
+	}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (p *poller) track(promptID string) {
+	p.mu.Lock()
+	if _, ok := p.prompts[promptID]; !ok {
+		p.prompts[promptID] = &polledPrompt{}
+	}
+	p.mu.Unlock()
+	notify(p.wake)
+}
+
+// observe updates the tracked prompts with a delivered message
+func (p *poller) observe(message *WSMessage) {
+	switch data := message.Data.(type) {
+	case *WSMessageDataExecutionStart:
+		p.mu.Lock()
+		if state, ok := p.prompts[data.PromptID]; ok {
+			state.started = true
+		}
+		p.mu.Unlock()
+	case *WSMessageDataCompleted:
+		p.mu.Lock()
+		delete(p.prompts, data.PromptID)
+		p.mu.Unlock()
+	}
+}
+
+func (p *poller) tracked() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.prompts)
+}
+
+func (p *poller) start() {
+	p.startOnce.Do(func() { go p.run() })
+}
+
+func (p *poller) stop() {
+	p.active.Store(false)
+	p.cancel()
+}
+
+func (p *poller) run() {
+	interval := p.minInterval
+	nextResync := time.Now().Add(p.resyncInterval)
+	for {
+		var wait <-chan time.Time
+		var timer *time.Timer
+		switch {
+		case p.tracked() == 0:
+			interval = p.minInterval
+			nextResync = time.Now().Add(p.resyncInterval)
+		case p.active.Load():
+			timer = time.NewTimer(interval)
+		default:
+			timer = time.NewTimer(time.Until(nextResync))
+		}
+		if timer != nil {
+			wait = timer.C
+		}
+
+		due, settle := false, true
+		select {
+		case <-p.ctx.Done():
+		case <-p.wake:
+			// a tracked prompt only reschedules the resync
+			interval = p.minInterval
+			due = p.active.Load()
+		case <-p.resync:
+			due, settle = true, false
+		case <-wait:
+			due = true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if p.ctx.Err() != nil {
+			return
+		}
+		if !due || p.tracked() == 0 {
+			continue
+		}
+
+		if !p.active.Load() {
+			p.poll(p.ctx, true, settle)
+			nextResync = time.Now().Add(p.resyncInterval)
+			if deadline := p.vanishDeadline(); !deadline.IsZero() && deadline.Before(nextResync) {
+				nextResync = deadline
+			}
+		} else if p.poll(p.ctx, false, false) {
+			interval = p.minInterval
+		} else {
+			interval = min(interval*2, p.maxInterval)
+		}
+	}
+}
+
+// poll checks every tracked prompt once and reports whether any of them changed
+// A resync only completes finished and vanished prompts, with settle finished prompts are completed by the second check,
+// so that the websocket has time to deliver their final messages first
+func (p *poller) poll(ctx context.Context, resync, settle bool) bool {
+	queueInfo, err := p.client.getQueueInfo(ctx)
+	if err != nil {
+		p.client.logger.Warn("poll queue failed", "error", err)
+		return false
+	}
+	running := promptIDSet(queueInfo.QueueRunning)
+	pending := promptIDSet(queueInfo.QueuePending)
+
+	p.mu.Lock()
+	ids := make([]string, 0, len(p.prompts))
+	for id := range p.prompts {
+		ids = append(ids, id)
+	}
+	p.mu.Unlock()
+
+	changed := false
+	for _, id := range ids {
+		p.mu.Lock()
+		state, ok := p.prompts[id]
+		if !ok {
+			p.mu.Unlock()
+			continue
+		}
+		queued := pending[id] || running[id]
+		if queued {
+			state.missingSince = time.Time{}
+			state.unqueued = false
+		}
+		start := running[id] && !state.started && !resync
+		if start {
+			state.started = true
+		}
+		started := state.started
+		p.mu.Unlock()
+
+		if start {
+			changed = true
+			p.emit(&WSMessage{Type: ExecutionStart, Data: &WSMessageDataExecutionStart{PromptID: id}})
+		}
+		if queued {
+			continue
+		}
+
+		history, err := p.client.getHistoryByPromptID(ctx, id)
+		if err != nil {
+			p.client.logger.Warn("poll history failed", "prompt_id", id, "error", err)
+			continue
+		}
+		p.mu.Lock()
+		if history == nil {
+			// the prompt may have left the queue before it shows up in the history
+			if state.missingSince.IsZero() {
+				state.missingSince = time.Now()
+			}
+			vanished := time.Since(state.missingSince) >= p.vanishTimeout
+			p.mu.Unlock()
+			if vanished {
+				p.vanish(id)
+				p.forget(id)
+				changed = true
+			}
+			continue
+		}
+		wait := settle && !state.unqueued
+		state.unqueued = true
+		p.mu.Unlock()
+		if wait {
+			continue
+		}
+
+		p.finish(id, started, history)
+		p.forget(id)
+		changed = true
+	}
+	return changed
+}
+
+// vanishDeadline returns when the first missing prompt is reported as vanished, zero if no prompt is missing
+func (p *poller) vanishDeadline() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var deadline time.Time
+	for _, state := range p.prompts {
+		if state.missingSince.IsZero() {
+			continue
+		}
+		if at := state.missingSince.Add(p.vanishTimeout); deadline.IsZero() || at.Before(deadline) {
+			deadline = at
+		}
+	}
+	return deadline
+}
+
+func (p *poller) forget(promptID string) {
+	p.mu.Lock()
+	delete(p.prompts, promptID)
+	p.mu.Unlock()
+}
+
+// vanish reports a prompt that is neither queued nor in the history as interrupted, e.g. one deleted from the queue
+func (p *poller) vanish(promptID string) {
+	p.client.logger.Warn("prompt is neither queued nor in the history, reporting it as interrupted", "prompt_id", promptID, "timeout", p.vanishTimeout)
+	p.interrupted(promptID)
+}
+
+// cancelled reports a watched prompt that was deleted from the queue as interrupted
+func (p *poller) cancelled(promptID string) {
+	p.mu.Lock()
+	_, ok := p.prompts[promptID]
+	delete(p.prompts, promptID)
+	p.mu.Unlock()
+	if ok {
+		p.interrupted(promptID)
+	}
+}
+
+func (p *poller) interrupted(promptID string) {
+	p.emit(&WSMessage{Type: ExecutionInterrupted, Data: &WSMessageExecutionInterrupted{PromptID: promptID}})
+	p.emit(&WSMessage{Type: Executing, Data: &WSMessageDataExecuting{PromptID: promptID}})
+}
+
+// finish replays the messages recorded in the history and the final executing message, so that a Completed message is emitted
+func (p *poller) finish(promptID string, started bool, history *PromptHistoryItem) {
+	if !started {
+		p.emit(&WSMessage{Type: ExecutionStart, Data: &WSMessageDataExecutionStart{PromptID: promptID}})
+	}
+
+	var final *WSMessage
+	if history.Status != nil {
+		for _, recorded := range history.Status.Messages {
+			message, err := recorded.Message()
+			if err != nil {
+				p.client.logger.Warn("decode history message failed", "prompt_id", promptID, "type", recorded.Type, "error", err)
+				continue
+			}
+			switch message.Type {
+			case ExecutionCached:
+				p.emit(message)
+			case ExecutionSuccess, ExecutionError, ExecutionInterrupted:
+				final = message
+			}
+		}
+	}
+
+	for node, output := range history.Outputs {
+		if len(output.Files) == 0 {
+			continue
+		}
+		p.emit(&WSMessage{Type: Executed, Data: &WSMessageDataExecuted{Node: node, PromptID: promptID, Output: output.Files}})
+	}
+
+	if final == nil {
+		if history.Status != nil && history.Status.StatusStr == "error" {
+			final = &WSMessage{Type: ExecutionError, Data: &WSMessageExecutionError{PromptID: promptID}}
+		} else {
+			final = &WSMessage{Type: ExecutionSuccess, Data: &WSMessageDataExecutionSuccess{PromptID: promptID}}
+		}
+	}
+	p.emit(final)
+	p.emit(&WSMessage{Type: Executing, Data: &WSMessageDataExecuting{PromptID: promptID}})
+}
+
+func (p *poller) emit(message *WSMessage) {
+	p.client.enqueue(message)
+}
+
+func promptIDSet(items []*NodeInfo) map[string]bool {
+	ids := make(map[string]bool, len(items))
+	for _, item := range items {
+		ids[item.PromptID] = true
+	}
+	return ids
+}
@@ -0,0 +1,128 @@
+package comfyUIclient_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/XdpCs/comfyUIclient/comfytest"
+)
+
+func TestPollingCompletesPrompt(t *testing.T) {
+	server, client := newTestServer(t,
+		comfyUIclient.WithTransportMode(comfyUIclient.TransportPolling),
+		comfyUIclient.WithPollInterval(10*time.Millisecond, 50*time.Millisecond),
+	)
+	completed := completions(t, client)
+	server.Script(&comfytest.Behavior{Cached: []string{"1"}})
+
+	q, err := client.QueuePromptByNodesWithOptions(context.Background(), testPrompt(), nil)
+	if err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	data := nextCompleted(t, completed)
+	if data.PromptID != q.PromptID || data.Status != comfyUIclient.ExecutionSuccess {
+		t.Fatalf("completed %+v", data)
+	}
+	if len(data.CachedNodes) != 1 || len(data.Outputs["2"]["images"]) != 1 {
+		t.Fatalf("cached %v outputs %v", data.CachedNodes, data.Outputs)
+	}
+}
+
+func TestPollingReportsVanishedPrompt(t *testing.T) {
+	server, client := newTestServer(t,
+		comfyUIclient.WithTransportMode(comfyUIclient.TransportPolling),
+		comfyUIclient.WithPollInterval(10*time.Millisecond, 50*time.Millisecond),
+		comfyUIclient.WithResync(0, 100*time.Millisecond),
+	)
+	completed := completions(t, client)
+	server.Pause()
+	defer server.Resume()
+
+	q, err := client.QueuePromptByNodesWithOptions(context.Background(), testPrompt(), nil)
+	if err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	if err := client.DeleteQueueByPromptID(q.PromptID); err != nil {
+		t.Fatalf("DeleteQueueByPromptID: %v", err)
+	}
+	data := nextCompleted(t, completed)
+	if data.PromptID != q.PromptID || data.Status != comfyUIclient.ExecutionInterrupted {
+		t.Fatalf("completed %s with %v, want %s with %v", data.PromptID, data.Status, q.PromptID, comfyUIclient.ExecutionInterrupted)
+	}
+}
+
+func TestWebSocketResyncsAfterReconnect(t *testing.T) {
+	server, client := newTestServer(t,
+		comfyUIclient.WithReconnectPolicy(comfyUIclient.ReconnectPolicy{MaxRetry: 3, Interval: 300 * time.Millisecond}),
+	)
+	completed := completions(t, client)
+	// the prompt finishes while the client is disconnected, its final messages are lost
+	server.Script(&comfytest.Behavior{DisconnectAt: "2"})
+
+	q, err := client.QueuePromptByNodesWithOptions(context.Background(), testPrompt(), nil)
+	if err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	data := nextCompleted(t, completed)
+	if data.PromptID != q.PromptID || data.Status != comfyUIclient.ExecutionSuccess || len(data.Outputs["2"]["images"]) != 1 {
+		t.Fatalf("completed %+v", data)
+	}
+}
+
+func TestAutoPollsWhileDisconnected(t *testing.T) {
+	server := comfytest.NewServer()
+	defer server.Close()
+	// the first connection and the first reconnect fail
+	server.AddFault(&comfytest.Fault{Path: "/ws", Status: http.StatusServiceUnavailable, Times: 6})
+	client, err := server.Client(
+		comfyUIclient.WithTransportMode(comfyUIclient.TransportAuto),
+		comfyUIclient.WithPollInterval(10*time.Millisecond, 50*time.Millisecond),
+		comfyUIclient.WithReconnectPolicy(comfyUIclient.ReconnectPolicy{MaxRetry: 3, Interval: 200 * time.Millisecond}),
+	)
+	if err != nil {
+		t.Fatalf("server.Client: %v", err)
+	}
+	defer client.Close()
+	if !client.IsPolling() {
+		t.Fatal("client isn't polling without websocket")
+	}
+	completed := completions(t, client)
+
+	q, err := client.QueuePromptByNodesWithOptions(context.Background(), testPrompt(), nil)
+	if err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	if data := nextCompleted(t, completed); data.PromptID != q.PromptID || data.Status != comfyUIclient.ExecutionSuccess {
+		t.Fatalf("completed %+v", data)
+	}
+	waitFor(t, 5*time.Second, "websocket reconnect", func() bool { return !client.IsPolling() && client.IsInitialized() })
+
+	// the websocket goes down mid session
+	server.Script(&comfytest.Behavior{DisconnectAt: "2", NodeDelay: 50 * time.Millisecond})
+	q, err = client.QueuePromptByNodesWithOptions(context.Background(), testPrompt(), nil)
+	if err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	if data := nextCompleted(t, completed); data.PromptID != q.PromptID || data.Status != comfyUIclient.ExecutionSuccess {
+		t.Fatalf("completed %+v", data)
+	}
+	waitFor(t, 5*time.Second, "websocket reconnect", func() bool { return !client.IsPolling() })
+}
+
+func TestCloseStopsListening(t *testing.T) {
+	_, client := newTestServer(t,
+		comfyUIclient.WithTransportMode(comfyUIclient.TransportAuto),
+		comfyUIclient.WithReconnectPolicy(comfyUIclient.ReconnectPolicy{MaxRetry: 1, Interval: 20 * time.Millisecond}),
+	)
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	waitFor(t, 5*time.Second, "close", func() bool { return !client.IsInitialized() })
+	time.Sleep(100 * time.Millisecond)
+	if client.IsInitialized() || client.IsPolling() {
+		t.Fatal("client reconnected after Close")
+	}
+}
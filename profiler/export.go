@@ -0,0 +1,76 @@
+package profiler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Report is the json export of a Profiler, durations are in nanoseconds
+type Report struct {
+	Classes []*ClassStats    `json:"classes"`
+	Prompts []*PromptProfile `json:"prompts"`
+}
+
+// WriteJSON writes the statistics by class_type and the kept prompt profiles as a Report
+func (p *Profiler) WriteJSON(w io.Writer) error {
+	report := &Report{Classes: p.Classes(), Prompts: p.Prompts()}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return fmt.Errorf("encoder.Encode: error: %w", err)
+	}
+	return nil
+}
+
+// WriteCSV writes one row of statistics per class_type, durations are in seconds
+func (p *Profiler) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	_ = writer.Write([]string{"class_type", "runs", "cached", "errors", "cache_hit_rate", "total_s", "mean_s", "min_s", "max_s", "steps", "steps_per_s"})
+	for _, stats := range p.Classes() {
+		_ = writer.Write([]string{
+			stats.ClassType,
+			strconv.Itoa(stats.Runs),
+			strconv.Itoa(stats.Cached),
+			strconv.Itoa(stats.Errors),
+			formatFloat(stats.CacheHitRate()),
+			formatFloat(stats.Total.Seconds()),
+			formatFloat(stats.Mean.Seconds()),
+			formatFloat(stats.Min.Seconds()),
+			formatFloat(stats.Max.Seconds()),
+			strconv.Itoa(stats.Steps),
+			formatFloat(stats.StepRate),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("writer.Flush: error: %w", err)
+	}
+	return nil
+}
+
+// WriteFolded writes the total wall time in microseconds per class_type as folded stacks, e.g. for flamegraph.pl or speedscope
+// Every stack is rooted at "comfyui", class types are the frames below it
+func (p *Profiler) WriteFolded(w io.Writer) error {
+	for _, stats := range p.Classes() {
+		if stats.Total <= 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "comfyui;%s %d\n", foldedFrame(stats.ClassType), stats.Total.Microseconds()); err != nil {
+			return fmt.Errorf("fmt.Fprintf: error: %w", err)
+		}
+	}
+	return nil
+}
+
+// foldedFrame replaces the separators of the folded format in name
+func foldedFrame(name string) string {
+	return strings.NewReplacer(";", "_", " ", "_", "\n", "_").Replace(name)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,384 @@
+// Package profiler infers how long each node of a prompt ran from the events of a comfyUIclient.Client
+//
+// ComfyUI doesn't report node durations, so a node is timed from its executing message to the next executing message,
+// or to the end of the prompt. Nodes listed in execution_cached are recorded as cache hits without a duration,
+// progress messages give the step rate of samplers. Statistics are aggregated by class_type across all profiled prompts:
+//
+//	p := profiler.New(nil)
+//	remove := p.Attach(client)
+//	defer remove()
+//	... queue prompts with client ...
+//	err := p.WriteCSV(os.Stdout)
+//
+// The class_type of a node is known for prompts queued by the attached client, call Track for prompts queued elsewhere.
+// Durations are measured when the client receives the messages, so they include network latency and are only as exact as the delivery.
+package profiler
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+// UnknownClassType is the class_type of nodes of prompts whose nodes are unknown
+const UnknownClassType = "unknown"
+
+// Options configures a Profiler
+type Options struct {
+	// MaxPrompts is the number of finished prompt profiles kept for Prompts, 100 when zero, negative keeps none
+	// Statistics by class_type include all prompts regardless
+	MaxPrompts int
+	// Expiry drops running and tracked prompts without messages for that long, e.g. ones deleted from the queue,
+	// 1h when zero, negative keeps them until they complete. Dropped prompts aren't counted in the statistics
+	Expiry time.Duration
+}
+
+// NodeRun is one execution of a node
+type NodeRun struct {
+	NodeID    string `json:"node_id"`
+	ClassType string `json:"class_type"`
+	// Cached nodes didn't run, their Duration is zero
+	Cached   bool          `json:"cached"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration_ns"`
+	// Steps is the highest progress value and StepRate the steps per second between the first and the last progress message
+	Steps    int     `json:"steps,omitempty"`
+	StepRate float64 `json:"step_rate,omitempty"`
+	// Error is the exception type when the node raised the execution error
+	Error string `json:"error,omitempty"`
+
+	firstStep     int
+	firstStepTime time.Time
+	lastStepTime  time.Time
+}
+
+// PromptProfile is the node runs of one prompt in execution order
+type PromptProfile struct {
+	PromptID string `json:"prompt_id"`
+	// Status is ExecutionSuccess, ExecutionError or ExecutionInterrupted, empty while running
+	Status   comfyUIclient.WsMessageType `json:"status,omitempty"`
+	Start    time.Time                   `json:"start"`
+	Duration time.Duration               `json:"duration_ns"`
+	Nodes    []*NodeRun                  `json:"nodes"`
+}
+
+// ClassStats are the statistics of a class_type across all profiled prompts
+type ClassStats struct {
+	ClassType string `json:"class_type"`
+	// Runs counts executions, Cached counts cache hits and Errors counts runs that raised the execution error
+	Runs   int `json:"runs"`
+	Cached int `json:"cached"`
+	Errors int `json:"errors"`
+	// Total, Min, Max and Mean are wall times of executed runs
+	Total time.Duration `json:"total_ns"`
+	Min   time.Duration `json:"min_ns"`
+	Max   time.Duration `json:"max_ns"`
+	Mean  time.Duration `json:"mean_ns"`
+	// Steps is the sum of progress steps, StepRate the steps per second over all runs that reported at least two steps
+	Steps    int     `json:"steps,omitempty"`
+	StepRate float64 `json:"step_rate,omitempty"`
+
+	rateSteps int
+	rateTime  time.Duration
+}
+
+// CacheHitRate returns the share of cache hits of all runs and hits
+func (s *ClassStats) CacheHitRate() float64 {
+	if s.Runs+s.Cached == 0 {
+		return 0
+	}
+	return float64(s.Cached) / float64(s.Runs+s.Cached)
+}
+
+// Profiler records node runs, it is safe for concurrent use
+type Profiler struct {
+	maxPrompts int
+	expiry     time.Duration
+
+	mu       sync.Mutex
+	nodes    map[string]comfyUIclient.Prompt
+	running  map[string]*promptState
+	finished []*PromptProfile
+	classes  map[string]*ClassStats
+	// done remembers finished prompts, so trailing messages don't start them again
+	done      map[string]struct{}
+	doneOrder []string
+	// seen is when the last message of a running or tracked prompt arrived
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+// doneSize is the number of finished prompt ids remembered
+const doneSize = 1024
+
+type promptState struct {
+	profile *PromptProfile
+	current *NodeRun
+}
+
+// New returns a profiler, opts may be nil
+func New(opts *Options) *Profiler {
+	if opts == nil {
+		opts = &Options{}
+	}
+	maxPrompts := opts.MaxPrompts
+	if maxPrompts == 0 {
+		maxPrompts = 100
+	}
+	expiry := opts.Expiry
+	if expiry == 0 {
+		expiry = time.Hour
+	}
+	return &Profiler{
+		maxPrompts: maxPrompts,
+		expiry:     expiry,
+		nodes:      make(map[string]comfyUIclient.Prompt),
+		running:    make(map[string]*promptState),
+		classes:    make(map[string]*ClassStats),
+		done:       make(map[string]struct{}),
+		seen:       make(map[string]time.Time),
+	}
+}
+
+// Attach profiles the prompts of client, the returned func detaches the profiler
+func (p *Profiler) Attach(client *comfyUIclient.Client) func() {
+	return client.AddListener(func(message *comfyUIclient.WSMessage) {
+		// events of prompts queued for another client id never reach this client
+		if queued, ok := message.Data.(*comfyUIclient.WSMessageDataQueued); ok && queued.ClientID != client.ID {
+			return
+		}
+		p.Handle(message)
+	})
+}
+
+// Track sets the nodes of promptID, so its node runs get their class_type
+func (p *Profiler) Track(promptID string, prompt comfyUIclient.Prompt) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	p.sweep(now)
+	p.nodes[promptID] = prompt
+	p.seen[promptID] = now
+}
+
+// Handle records message, Attach registers it as listener
+func (p *Profiler) Handle(message *comfyUIclient.WSMessage) {
+	p.handle(message, time.Now())
+}
+
+func (p *Profiler) handle(message *comfyUIclient.WSMessage, now time.Time) {
+	promptID := message.PromptID()
+	if promptID == "" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sweep(now)
+	if _, ok := p.done[promptID]; ok {
+		return
+	}
+	p.seen[promptID] = now
+
+	switch data := message.Data.(type) {
+	case *comfyUIclient.WSMessageDataQueued:
+		if data.Prompt != nil {
+			p.nodes[promptID] = data.Prompt
+		}
+	case *comfyUIclient.WSMessageDataExecutionStart:
+		p.state(promptID, now)
+	case *comfyUIclient.WSMessageDataExecutionCached:
+		state := p.state(promptID, now)
+		for _, node := range data.Nodes {
+			state.profile.Nodes = append(state.profile.Nodes, &NodeRun{NodeID: node, ClassType: p.classType(promptID, node), Cached: true, Start: now})
+		}
+	case *comfyUIclient.WSMessageDataExecuting:
+		state := p.state(promptID, now)
+		state.endNode(now)
+		if data.Node != "" {
+			state.current = &NodeRun{NodeID: data.Node, ClassType: p.classType(promptID, data.Node), Start: now}
+			state.profile.Nodes = append(state.profile.Nodes, state.current)
+		}
+	case *comfyUIclient.WSMessageDataProgress:
+		state := p.state(promptID, now)
+		run := state.current
+		if run == nil || (data.Node != "" && data.Node != run.NodeID) {
+			return
+		}
+		if run.firstStepTime.IsZero() {
+			run.firstStep, run.firstStepTime = data.Value, now
+		}
+		run.lastStepTime = now
+		run.Steps = max(run.Steps, data.Value)
+	case *comfyUIclient.WSMessageExecutionError:
+		state := p.state(promptID, now)
+		if state.current == nil || state.current.NodeID != data.Node {
+			state.endNode(now)
+			state.current = &NodeRun{NodeID: data.Node, ClassType: data.NodeType, Start: now}
+			state.profile.Nodes = append(state.profile.Nodes, state.current)
+		}
+		state.current.Error = data.ExceptionType
+		if state.current.Error == "" {
+			state.current.Error = "error"
+		}
+		state.endNode(now)
+	case *comfyUIclient.WSMessageExecutionInterrupted:
+		p.state(promptID, now).endNode(now)
+	case *comfyUIclient.WSMessageDataCompleted:
+		state, ok := p.running[promptID]
+		if ok {
+			// Track may have been called after the prompt started
+			for _, run := range state.profile.Nodes {
+				if run.ClassType == UnknownClassType {
+					run.ClassType = p.classType(promptID, run.NodeID)
+				}
+			}
+		}
+		delete(p.nodes, promptID)
+		delete(p.seen, promptID)
+		p.done[promptID] = struct{}{}
+		p.doneOrder = append(p.doneOrder, promptID)
+		if len(p.doneOrder) > doneSize {
+			delete(p.done, p.doneOrder[0])
+			p.doneOrder = p.doneOrder[1:]
+		}
+		if !ok {
+			return
+		}
+		delete(p.running, promptID)
+		state.endNode(now)
+		state.profile.Status = data.Status
+		state.profile.Duration = now.Sub(state.profile.Start)
+		p.finish(state.profile)
+	}
+}
+
+// sweep drops the prompts that expired, at most once per minute
+func (p *Profiler) sweep(now time.Time) {
+	if p.expiry < 0 || now.Sub(p.lastSweep) < min(p.expiry, time.Minute) {
+		return
+	}
+	p.lastSweep = now
+	for promptID, seen := range p.seen {
+		if now.Sub(seen) < p.expiry {
+			continue
+		}
+		delete(p.seen, promptID)
+		delete(p.nodes, promptID)
+		delete(p.running, promptID)
+	}
+}
+
+// state returns the state of a running prompt, it starts when the first execution message arrives
+func (p *Profiler) state(promptID string, now time.Time) *promptState {
+	state, ok := p.running[promptID]
+	if !ok {
+		state = &promptState{profile: &PromptProfile{PromptID: promptID, Start: now}}
+		p.running[promptID] = state
+	}
+	return state
+}
+
+func (p *Profiler) classType(promptID, node string) string {
+	if n, ok := p.nodes[promptID][node]; ok && n.ClassType != "" {
+		return n.ClassType
+	}
+	return UnknownClassType
+}
+
+func (s *promptState) endNode(now time.Time) {
+	run := s.current
+	if run == nil {
+		return
+	}
+	s.current = nil
+	run.Duration = now.Sub(run.Start)
+	if steps := run.Steps - run.firstStep; steps > 0 {
+		if elapsed := run.lastStepTime.Sub(run.firstStepTime); elapsed > 0 {
+			run.StepRate = float64(steps) / elapsed.Seconds()
+		}
+	}
+}
+
+// finish adds the runs of profile to the class statistics and keeps profile
+func (p *Profiler) finish(profile *PromptProfile) {
+	for _, run := range profile.Nodes {
+		stats, ok := p.classes[run.ClassType]
+		if !ok {
+			stats = &ClassStats{ClassType: run.ClassType}
+			p.classes[run.ClassType] = stats
+		}
+		if run.Cached {
+			stats.Cached++
+			continue
+		}
+		if stats.Runs == 0 || run.Duration < stats.Min {
+			stats.Min = run.Duration
+		}
+		stats.Max = max(stats.Max, run.Duration)
+		stats.Runs++
+		stats.Total += run.Duration
+		stats.Mean = stats.Total / time.Duration(stats.Runs)
+		if run.Error != "" {
+			stats.Errors++
+		}
+		stats.Steps += run.Steps
+		if run.StepRate > 0 {
+			stats.rateSteps += run.Steps - run.firstStep
+			stats.rateTime += run.lastStepTime.Sub(run.firstStepTime)
+			stats.StepRate = float64(stats.rateSteps) / stats.rateTime.Seconds()
+		}
+	}
+
+	if p.maxPrompts < 0 {
+		return
+	}
+	p.finished = append(p.finished, profile)
+	if len(p.finished) > p.maxPrompts {
+		p.finished = p.finished[len(p.finished)-p.maxPrompts:]
+	}
+}
+
+// Prompts returns the profiles of the last finished prompts, oldest first
+func (p *Profiler) Prompts() []*PromptProfile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	profiles := make([]*PromptProfile, len(p.finished))
+	for i, profile := range p.finished {
+		copied := *profile
+		copied.Nodes = make([]*NodeRun, len(profile.Nodes))
+		for j, run := range profile.Nodes {
+			copiedRun := *run
+			copied.Nodes[j] = &copiedRun
+		}
+		profiles[i] = &copied
+	}
+	return profiles
+}
+
+// Classes returns the statistics of every class_type, slowest total first
+func (p *Profiler) Classes() []*ClassStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	classes := make([]*ClassStats, 0, len(p.classes))
+	for _, stats := range p.classes {
+		copied := *stats
+		classes = append(classes, &copied)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		if classes[i].Total != classes[j].Total {
+			return classes[i].Total > classes[j].Total
+		}
+		return classes[i].ClassType < classes[j].ClassType
+	})
+	return classes
+}
+
+// Reset drops all recorded prompts and statistics, running prompts keep being profiled
+func (p *Profiler) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finished = nil
+	p.classes = make(map[string]*ClassStats)
+}
@@ -0,0 +1,42 @@
+package profiler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+func executing(promptID, node string) *comfyUIclient.WSMessage {
+	return &comfyUIclient.WSMessage{Type: comfyUIclient.Executing, Data: &comfyUIclient.WSMessageDataExecuting{PromptID: promptID, Node: node}}
+}
+
+func TestExpiredPromptsAreDropped(t *testing.T) {
+	p := New(&Options{Expiry: time.Hour})
+	start := time.Now()
+	p.Track("tracked", comfyUIclient.Prompt{})
+	p.handle(executing("deleted", "1"), start)
+
+	// a message of another prompt after the expiry sweeps both
+	later := start.Add(2 * time.Hour)
+	p.handle(executing("other", "1"), later)
+	if _, ok := p.running["deleted"]; ok {
+		t.Fatal("expired running prompt wasn't dropped")
+	}
+	if _, ok := p.nodes["tracked"]; ok {
+		t.Fatal("expired tracked prompt wasn't dropped")
+	}
+	if _, ok := p.running["other"]; !ok {
+		t.Fatal("running prompt was dropped")
+	}
+
+	p.handle(&comfyUIclient.WSMessage{Type: comfyUIclient.Completed, Data: &comfyUIclient.WSMessageDataCompleted{
+		PromptID: "other", Status: comfyUIclient.ExecutionSuccess,
+	}}, later.Add(time.Second))
+	if len(p.running) != 0 || len(p.seen) != 0 {
+		t.Fatalf("running = %v, seen = %v, want none", p.running, p.seen)
+	}
+	if prompts := p.Prompts(); len(prompts) != 1 || prompts[0].PromptID != "other" {
+		t.Fatalf("prompts = %v, want other", prompts)
+	}
+}
@@ -0,0 +1,267 @@
+package comfyUIclient
+
+import (
+	"sync"
+	"time"
+)
+
+// ProgressSnapshot is the progress of a prompt at a point in time
+type ProgressSnapshot struct {
+	PromptID string
+	// Percent is the estimated overall progress from 0 to 100, it never decreases
+	Percent float64
+	// NodesDone counts executed and cached nodes
+	NodesDone  int
+	NodesTotal int
+	// CurrentNode is the id of the executing node, CurrentClassType its class
+	CurrentNode      string
+	CurrentClassType string
+	// StepValue and StepMax are the sampler steps of the current node
+	StepValue int
+	StepMax   int
+	Elapsed   time.Duration
+	// ETA is the estimated remaining time, zero when unknown
+	ETA time.Duration
+	// Done is set once the prompt finished, Status tells how
+	Done   bool
+	Status WsMessageType
+}
+
+// ProgressTracker estimates the overall progress of one prompt from its websocket messages
+//
+// Every node counts as one unit, the current node is advanced by its sampler steps.
+type ProgressTracker struct {
+	mu        sync.Mutex
+	promptID  string
+	prompt    Prompt
+	total     int
+	started   time.Time
+	ended     time.Time
+	done      map[string]bool
+	current   string
+	stepValue int
+	stepMax   int
+	percent   float64
+	finished  bool
+	status    WsMessageType
+
+	updates   chan ProgressSnapshot
+	callbacks []func(ProgressSnapshot)
+	finishCh  chan struct{}
+	now       func() time.Time
+}
+
+// NewProgressTracker creates a tracker for promptID
+// totalNodes is the number of nodes expected to run, see graph.Graph.ExpectedExecutingSteps, len(prompt) is used when it's not positive
+func NewProgressTracker(promptID string, prompt Prompt, totalNodes int) *ProgressTracker {
+	if totalNodes <= 0 {
+		totalNodes = len(prompt)
+	}
+	return &ProgressTracker{
+		promptID: promptID,
+		prompt:   prompt,
+		total:    totalNodes,
+		done:     make(map[string]bool),
+		updates:  make(chan ProgressSnapshot, 16),
+		finishCh: make(chan struct{}),
+		now:      time.Now,
+	}
+}
+
+// TrackProgress creates a ProgressTracker for promptID that is fed by the client until the prompt finished
+func (c *Client) TrackProgress(promptID string, prompt Prompt, totalNodes int) *ProgressTracker {
+	tracker := NewProgressTracker(promptID, prompt, totalNodes)
+	remove := c.AddListener(tracker.Handle)
+	go func() {
+		<-tracker.Finished()
+		remove()
+	}()
+	return tracker
+}
+
+// OnUpdate registers callback for every snapshot, it runs on the goroutine that calls Handle
+func (t *ProgressTracker) OnUpdate(callback func(ProgressSnapshot)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.callbacks = append(t.callbacks, callback)
+}
+
+// Updates returns a channel of snapshots that is closed when the prompt finished
+// Snapshots are dropped when the channel is full, the last one is always delivered
+func (t *ProgressTracker) Updates() <-chan ProgressSnapshot {
+	return t.updates
+}
+
+// Finished returns a channel that is closed when the prompt finished
+func (t *ProgressTracker) Finished() <-chan struct{} {
+	return t.finishCh
+}
+
+// Snapshot returns the current progress
+func (t *ProgressTracker) Snapshot() ProgressSnapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.snapshot()
+}
+
+// Handle updates the progress with message, messages of other prompts are ignored
+func (t *ProgressTracker) Handle(message *WSMessage) {
+	t.mu.Lock()
+	if t.finished || !t.apply(message) {
+		t.mu.Unlock()
+		return
+	}
+	snapshot := t.snapshot()
+	callbacks := t.callbacks
+	t.publish(snapshot)
+	t.mu.Unlock()
+
+	for _, callback := range callbacks {
+		callback(snapshot)
+	}
+}
+
+// apply updates the state with message and reports whether it belonged to the prompt
+func (t *ProgressTracker) apply(message *WSMessage) bool {
+	switch data := message.Data.(type) {
+	case *WSMessageDataExecutionStart:
+		if data.PromptID != t.promptID {
+			return false
+		}
+		t.start()
+	case *WSMessageDataExecutionCached:
+		if data.PromptID != t.promptID {
+			return false
+		}
+		t.start()
+		for _, node := range data.Nodes {
+			t.done[node] = true
+		}
+	case *WSMessageDataExecuting:
+		if data.PromptID != t.promptID {
+			return false
+		}
+		t.start()
+		if t.current != "" {
+			t.done[t.current] = true
+		}
+		t.current, t.stepValue, t.stepMax = data.Node, 0, 0
+		if data.Node == "" {
+			t.finish(ExecutionSuccess)
+		}
+	case *WSMessageDataProgress:
+		// old servers don't send prompt_id, progress then belongs to the running prompt
+		if data.PromptID != t.promptID && (data.PromptID != "" || t.started.IsZero()) {
+			return false
+		}
+		if data.Node != "" && t.current == "" {
+			t.current = data.Node
+		}
+		t.stepValue, t.stepMax = data.Value, data.Max
+	case *WSMessageDataExecuted:
+		if data.PromptID != t.promptID {
+			return false
+		}
+		t.done[data.Node] = true
+	case *WSMessageDataExecutionSuccess:
+		if data.PromptID != t.promptID {
+			return false
+		}
+		t.finish(ExecutionSuccess)
+	case *WSMessageExecutionInterrupted:
+		if data.PromptID != t.promptID {
+			return false
+		}
+		t.finish(ExecutionInterrupted)
+	case *WSMessageExecutionError:
+		if data.PromptID != t.promptID {
+			return false
+		}
+		t.finish(ExecutionError)
+	default:
+		return false
+	}
+	t.updatePercent()
+	return true
+}
+
+func (t *ProgressTracker) start() {
+	if t.started.IsZero() {
+		t.started = t.now()
+	}
+}
+
+func (t *ProgressTracker) finish(status WsMessageType) {
+	t.finished = true
+	t.status = status
+	t.ended = t.now()
+	t.current, t.stepValue, t.stepMax = "", 0, 0
+}
+
+func (t *ProgressTracker) updatePercent() {
+	if t.finished && t.status == ExecutionSuccess {
+		t.percent = 100
+		return
+	}
+
+	units := float64(len(t.done))
+	if t.current != "" && !t.done[t.current] && t.stepMax > 0 {
+		units += float64(t.stepValue) / float64(t.stepMax)
+	}
+	percent := units / float64(t.total) * 100
+	// the estimate only reaches 100 once the server says so
+	if percent > 99 {
+		percent = 99
+	}
+	if percent > t.percent {
+		t.percent = percent
+	}
+}
+
+func (t *ProgressTracker) snapshot() ProgressSnapshot {
+	snapshot := ProgressSnapshot{
+		PromptID:    t.promptID,
+		Percent:     t.percent,
+		NodesDone:   len(t.done),
+		NodesTotal:  t.total,
+		CurrentNode: t.current,
+		StepValue:   t.stepValue,
+		StepMax:     t.stepMax,
+		Done:        t.finished,
+		Status:      t.status,
+	}
+	if node, ok := t.prompt[t.current]; ok {
+		snapshot.CurrentClassType = node.ClassType
+	}
+	if !t.started.IsZero() {
+		end := t.ended
+		if end.IsZero() {
+			end = t.now()
+		}
+		snapshot.Elapsed = end.Sub(t.started)
+		if !t.finished && t.percent > 0 {
+			snapshot.ETA = time.Duration(float64(snapshot.Elapsed) * (100 - t.percent) / t.percent)
+		}
+	}
+	return snapshot
+}
+
+func (t *ProgressTracker) publish(snapshot ProgressSnapshot) {
+	select {
+	case t.updates <- snapshot:
+	default:
+		if !snapshot.Done {
+			break
+		}
+		// make room for the final snapshot
+		select {
+		case <-t.updates:
+		default:
+		}
+		t.updates <- snapshot
+	}
+	if snapshot.Done {
+		close(t.updates)
+		close(t.finishCh)
+	}
+}
@@ -0,0 +1,96 @@
+package comfyUIclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Prompt is a workflow in api format, keyed by node id
+type Prompt = map[string]PromptNode
+
+// Link is a connection from an output slot of another node to an input
+type Link struct {
+	NodeID string
+	Slot   int
+}
+
+// ParseLink parses an input value of the form [nodeID, slot]
+func ParseLink(value interface{}) (Link, bool) {
+	values, ok := value.([]interface{})
+	if !ok || len(values) != 2 {
+		return Link{}, false
+	}
+	nodeID, ok := values[0].(string)
+	if !ok {
+		return Link{}, false
+	}
+	switch slot := values[1].(type) {
+	case int:
+		return Link{NodeID: nodeID, Slot: slot}, true
+	case int64:
+		return Link{NodeID: nodeID, Slot: int(slot)}, true
+	case float64:
+		if slot != float64(int(slot)) {
+			return Link{}, false
+		}
+		return Link{NodeID: nodeID, Slot: int(slot)}, true
+	}
+	return Link{}, false
+}
+
+// Links returns the inputs of the node that are linked to other nodes, keyed by input name
+func (n *PromptNode) Links() map[string]Link {
+	links := make(map[string]Link)
+	for name, value := range n.Inputs {
+		if link, ok := ParseLink(value); ok {
+			links[name] = link
+		}
+	}
+	return links
+}
+
+// PrunePrompt returns the nodes needed to execute targets, that is targets and all their transitive dependencies
+// The returned prompt shares the PromptNode values of prompt
+func PrunePrompt(prompt Prompt, targets ...string) (Prompt, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("targets is empty")
+	}
+
+	pruned := make(Prompt)
+	stack := append([]string{}, targets...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := pruned[id]; ok {
+			continue
+		}
+		node, ok := prompt[id]
+		if !ok {
+			return nil, fmt.Errorf("node %s not found in prompt", id)
+		}
+		pruned[id] = node
+
+		for _, link := range node.Links() {
+			stack = append(stack, link.NodeID)
+		}
+	}
+	return pruned, nil
+}
+
+// QueuePartialPrompt queues only the output nodes targets of nodes
+// The prompt is pruned to the dependencies of targets so that servers without partial execution skip the other outputs,
+// and targets is sent as partial_execution_targets for servers that understand it
+func (c *Client) QueuePartialPrompt(ctx context.Context, nodes Prompt, targets []string, opts *QueueOptions) (*QueuePromptResp, error) {
+	pruned, err := PrunePrompt(nodes, targets...)
+	if err != nil {
+		return nil, fmt.Errorf("PrunePrompt: error: %w", err)
+	}
+
+	partialOpts := QueueOptions{}
+	if opts != nil {
+		partialOpts = *opts
+	}
+	partialOpts.PartialExecutionTargets = targets
+	return c.QueuePromptByNodesWithOptions(ctx, pruned, &partialOpts)
+}
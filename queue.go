@@ -0,0 +1,350 @@
+package comfyUIclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// QueueOptions controls how a prompt is queued
+type QueueOptions struct {
+	// PromptID is sent as prompt_id, a new one is generated when empty
+	// Generate it with NewPromptID and persist it before queueing to look the prompt up after a failed request
+	PromptID string
+	// ExtraPngInfo is saved into the metadata of output images, it must be a json string
+	// {} is sent when it is empty and ExtraData has no extra_pnginfo either
+	ExtraPngInfo json.RawMessage
+	// Front puts the prompt at the front of the queue, ahead of all pending prompts
+	Front bool
+	// Number sets the queue position explicitly, lower numbers run first, it takes precedence over Front
+	Number *float64
+	// ClientID overrides the client id the prompt belongs to
+	// Websocket events of the prompt are then sent to that client instead of this one
+	ClientID string
+	// ExtraData is merged into extra_data, e.g. ExtraDataAPIKeyComfyOrg for API nodes
+	ExtraData map[string]interface{}
+	// PartialExecutionTargets are the output node ids to execute, all output nodes run when empty
+	// Servers that don't support it ignore the field, see QueuePartialPrompt for those
+	PartialExecutionTargets []string
+}
+
+const (
+	// ExtraDataAPIKeyComfyOrg is the extra_data key of the comfy.org api key used by API nodes
+	ExtraDataAPIKeyComfyOrg = "api_key_comfy_org"
+	// ExtraDataAuthTokenComfyOrg is the extra_data key of the comfy.org auth token used by API nodes
+	ExtraDataAuthTokenComfyOrg = "auth_token_comfy_org"
+)
+
+// WSMessageDataQueued is the data of the Queued message the client emits when it queued a prompt
+// The message is only passed to listeners, before the execution messages of the prompt
+type WSMessageDataQueued struct {
+	PromptID string
+	Number   float64
+	// ClientID receives the execution events of the prompt
+	ClientID string
+	// Prompt is nil when a workflow string couldn't be decoded
+	Prompt   Prompt
+	QueuedAt time.Time
+}
+
+// NewPromptID returns a new random prompt id
+func NewPromptID() string {
+	return uuid.New().String()
+}
+
+// QueuePromptError is returned when queueing a prompt failed
+// The prompt may still have been enqueued, use PromptExists with PromptID to find out
+type QueuePromptError struct {
+	PromptID string
+	Err      error
+}
+
+func (e *QueuePromptError) Error() string {
+	return fmt.Sprintf("queue prompt %s: %v", e.PromptID, e.Err)
+}
+
+func (e *QueuePromptError) Unwrap() error {
+	return e.Err
+}
+
+// PromptRejectedError is returned when the server answered POST /prompt with an error status
+// ComfyUI answers 400 for an invalid prompt, e.g. an unknown class_type, NodeErrors holds the errors per node
+type PromptRejectedError struct {
+	StatusCode int
+	// Type, Message and Details describe the error, e.g. prompt_outputs_failed_validation
+	Type       string
+	Message    string
+	Details    string
+	NodeErrors map[string]interface{}
+}
+
+func (e *PromptRejectedError) Error() string {
+	message := fmt.Sprintf("prompt rejected with status %d", e.StatusCode)
+	if e.Message != "" {
+		message += ": " + e.Message
+	}
+	if e.Details != "" {
+		message += " " + e.Details
+	}
+	if len(e.NodeErrors) != 0 {
+		message += fmt.Sprintf(", node errors: %v", e.NodeErrors)
+	}
+	return message
+}
+
+// newPromptRejectedError decodes the error body of ComfyUI, other bodies become the message
+func newPromptRejectedError(statusCode int, body []byte) *PromptRejectedError {
+	var rejected struct {
+		Error      json.RawMessage        `json:"error"`
+		NodeErrors map[string]interface{} `json:"node_errors"`
+	}
+	e := &PromptRejectedError{StatusCode: statusCode}
+	if err := json.Unmarshal(body, &rejected); err != nil {
+		e.Message = strings.TrimSpace(string(body))
+		return e
+	}
+	e.NodeErrors = rejected.NodeErrors
+	var details struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+		Details string `json:"details"`
+	}
+	// older servers send the error as a string
+	if err := json.Unmarshal(rejected.Error, &details); err == nil {
+		e.Type, e.Message, e.Details = details.Type, details.Message, details.Details
+	} else {
+		_ = json.Unmarshal(rejected.Error, &e.Message)
+	}
+	return e
+}
+
+type queuePromptRequest struct {
+	ClientID                string                 `json:"client_id"`
+	PromptID                string                 `json:"prompt_id"`
+	Prompt                  interface{}            `json:"prompt"`
+	ExtraData               map[string]interface{} `json:"extra_data"`
+	Front                   bool                   `json:"front,omitempty"`
+	Number                  *float64               `json:"number,omitempty"`
+	PartialExecutionTargets []string               `json:"partial_execution_targets,omitempty"`
+}
+
+// QueuePromptByStringWithOptions queues a prompt by workflow which type is string
+// workflow must be a json string
+func (c *Client) QueuePromptByStringWithOptions(ctx context.Context, workflow string, opts *QueueOptions) (*QueuePromptResp, error) {
+	if !c.IsInitialized() {
+		return nil, errors.New("client not initialized")
+	}
+
+	if workflow == "" {
+		return nil, errors.New("workflow is empty")
+	}
+
+	return c.queuePromptWithOptions(ctx, json.RawMessage(workflow), opts)
+}
+
+// QueuePromptByNodesWithOptions queues a prompt by workflow which type is map[string]PromptNode
+func (c *Client) QueuePromptByNodesWithOptions(ctx context.Context, nodes map[string]PromptNode, opts *QueueOptions) (*QueuePromptResp, error) {
+	if len(nodes) == 0 {
+		return nil, errors.New("nodes is empty")
+	}
+
+	return c.queuePromptWithOptions(ctx, nodes, opts)
+}
+
+func (c *Client) queuePromptWithOptions(ctx context.Context, prompt interface{}, opts *QueueOptions) (*QueuePromptResp, error) {
+	if opts == nil {
+		opts = &QueueOptions{}
+	}
+
+	promptID := opts.PromptID
+	if promptID == "" {
+		promptID = NewPromptID()
+	}
+	clientID := opts.ClientID
+	if clientID == "" {
+		clientID = c.ID
+	}
+
+	extraData := make(map[string]interface{}, len(opts.ExtraData)+1)
+	for key, value := range opts.ExtraData {
+		extraData[key] = value
+	}
+	if len(opts.ExtraPngInfo) != 0 {
+		extraData["extra_pnginfo"] = opts.ExtraPngInfo
+	} else if _, ok := extraData["extra_pnginfo"]; !ok {
+		extraData["extra_pnginfo"] = json.RawMessage("{}")
+	}
+
+	// the nodes are decoded for the Queued message and for tracing
+	var nodes Prompt
+	switch prompt := prompt.(type) {
+	case map[string]PromptNode:
+		nodes = prompt
+	case json.RawMessage:
+		_ = json.Unmarshal(prompt, &nodes)
+	}
+
+	temp := &queuePromptRequest{
+		ClientID:                clientID,
+		PromptID:                promptID,
+		Prompt:                  prompt,
+		ExtraData:               extraData,
+		Front:                   opts.Front,
+		Number:                  opts.Number,
+		PartialExecutionTargets: opts.PartialExecutionTargets,
+	}
+	ctx, trace := c.startPromptTrace(ctx, promptID, clientID, nodes)
+	// events of prompts queued for another client id never reach this client
+	watched := clientID == c.ID
+	if watched {
+		// the prompt may start before the response arrives, its events wait for the Queued message
+		c.hold(promptID)
+	}
+	q, err := c.queuePrompt(ctx, promptID, temp)
+	c.promptQueued(trace, promptID, clientID, q, err)
+	if err != nil {
+		c.release(promptID, nil)
+		return nil, &QueuePromptError{PromptID: promptID, Err: err}
+	}
+	if q.PromptID != "" && watched {
+		c.WatchPrompt(q.PromptID)
+	}
+	var queued *WSMessage
+	if q.PromptID != "" && len(q.NodeErrors) == 0 {
+		data := &WSMessageDataQueued{PromptID: q.PromptID, Number: q.Number, ClientID: clientID, Prompt: nodes, QueuedAt: time.Now()}
+		queued = &WSMessage{Type: Queued, Data: data}
+	}
+	c.release(promptID, queued)
+	return q, nil
+}
+
+// queuePrompt posts temp which carries promptID to /prompt
+// A transient failure is only retried after making sure the prompt was not enqueued, so it never runs twice
+func (c *Client) queuePrompt(ctx context.Context, promptID string, temp interface{}) (*QueuePromptResp, error) {
+	var resp *http.Response
+	var err error
+	for attempt := 1; ; attempt++ {
+		resp, err = c.postJSONUsesRouter(ctx, PromptRouter, temp, nil)
+		if attempt >= c.retryPolicy.MaxAttempts || !c.retryPolicy.shouldRetry(resp, err) {
+			break
+		}
+
+		wait := c.retryPolicy.backoff(attempt, resp)
+		discardBody(resp)
+		// the server may still be handling the failed request, wait before looking for the prompt
+		if sleepErr := sleepContext(ctx, wait); sleepErr != nil {
+			return nil, sleepErr
+		}
+		exists, checkErr := c.PromptExists(ctx, promptID)
+		if checkErr != nil {
+			c.logger.Warn("can't check whether prompt was queued, not retrying", "prompt_id", promptID, "attempt", attempt, "error", checkErr)
+			if err == nil {
+				return nil, fmt.Errorf("queue prompt failed: status %v", resp.Status)
+			}
+			return nil, fmt.Errorf("c.postJSONUsesRouter: error: %w", err)
+		}
+		if exists {
+			c.logger.Info("prompt was queued despite failed request", "prompt_id", promptID, "attempt", attempt, "error", describeAttempt(resp, err))
+			return &QueuePromptResp{PromptID: promptID}, nil
+		}
+		c.logger.Info("retrying queue prompt", "prompt_id", promptID, "attempt", attempt, "error", describeAttempt(resp, err))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("c.postJSONUsesRouter: error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	q := &QueuePromptResp{}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("io.ReadAll: error: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, newPromptRejectedError(resp.StatusCode, body)
+	}
+
+	if err := json.Unmarshal(body, &q); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: error: %w, resp.Body: %v", err, string(body))
+	}
+	c.logger.Debug("prompt queued", "prompt_id", q.PromptID, "number", q.Number)
+
+	return q, nil
+}
+
+// ErrPromptStillQueued is returned by CancelPrompt when the prompt is still pending after it was deleted from the queue
+var ErrPromptStillQueued = errors.New("prompt is still queued")
+
+// CancelPrompt deletes promptID from the queue, or interrupts it when it is running, it does nothing when the prompt finished
+// A watched prompt that was removed from the queue is completed as ExecutionInterrupted right away
+func (c *Client) CancelPrompt(ctx context.Context, promptID string) error {
+	resp, err := c.postJSONUsesRouter(ctx, QueueRouter, map[string][]string{"delete": {promptID}}, nil)
+	if err != nil {
+		return fmt.Errorf("c.postJSONUsesRouter: error: %w", err)
+	}
+	discardBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("delete prompt failed: status %v", resp.Status)
+	}
+
+	queueInfo, err := c.getQueueInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("c.getQueueInfo: error: %w", err)
+	}
+	for _, item := range queueInfo.QueueRunning {
+		if item.PromptID != promptID {
+			continue
+		}
+		// servers that support prompt_id don't interrupt another prompt that started meanwhile
+		resp, err := c.postJSONUsesRouter(ctx, InterruptRouter, map[string]string{"prompt_id": promptID}, nil)
+		if err != nil {
+			return fmt.Errorf("c.postJSONUsesRouter: error: %w", err)
+		}
+		discardBody(resp)
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("interrupt prompt failed: status %v", resp.Status)
+		}
+		return nil
+	}
+	for _, item := range queueInfo.QueuePending {
+		if item.PromptID == promptID {
+			return ErrPromptStillQueued
+		}
+	}
+
+	history, err := c.getHistoryByPromptID(ctx, promptID)
+	if err != nil {
+		return fmt.Errorf("c.getHistoryByPromptID: error: %w", err)
+	}
+	if history == nil {
+		c.poller.cancelled(promptID)
+	}
+	return nil
+}
+
+// PromptExists reports whether promptID is in the queue or in the history
+func (c *Client) PromptExists(ctx context.Context, promptID string) (bool, error) {
+	queueInfo, err := c.getQueueInfo(ctx)
+	if err != nil {
+		return false, fmt.Errorf("c.getQueueInfo: error: %w", err)
+	}
+	for _, items := range [][]*NodeInfo{queueInfo.QueueRunning, queueInfo.QueuePending} {
+		for _, item := range items {
+			if item.PromptID == promptID {
+				return true, nil
+			}
+		}
+	}
+
+	history, err := c.getHistoryByPromptID(ctx, promptID)
+	if err != nil {
+		return false, fmt.Errorf("c.getHistoryByPromptID: error: %w", err)
+	}
+	return history != nil, nil
+}
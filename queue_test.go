@@ -0,0 +1,253 @@
+package comfyUIclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/XdpCs/comfyUIclient/comfytest"
+)
+
+// recorder is a minimal ComfyUI stand-in that records the bodies posted to it
+type recorder struct {
+	mu     sync.Mutex
+	bodies map[string][]byte
+}
+
+func newRecorder(t *testing.T) (*comfyUIclient.Client, *recorder) {
+	t.Helper()
+	r := &recorder{bodies: make(map[string][]byte)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.bodies[req.URL.Path] = body
+		r.mu.Unlock()
+		if req.URL.Path == "/prompt" && req.Method == http.MethodPost {
+			var request struct {
+				PromptID string `json:"prompt_id"`
+			}
+			_ = json.Unmarshal(body, &request)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"prompt_id": request.PromptID, "number": 0, "node_errors": map[string]interface{}{}})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	client, err := comfyUIclient.New(server.URL)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return client, r
+}
+
+func (r *recorder) body(t *testing.T, path string) map[string]interface{} {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var body map[string]interface{}
+	if err := json.Unmarshal(r.bodies[path], &body); err != nil {
+		t.Fatalf("body of %s: %v", path, err)
+	}
+	return body
+}
+
+func TestQueuePromptByNodesDefaultsExtraPngInfo(t *testing.T) {
+	client, r := newRecorder(t)
+	nodes := map[string]comfyUIclient.PromptNode{"1": {ClassType: "SaveImage", Inputs: map[string]interface{}{}}}
+	if _, err := client.QueuePromptByNodes(nodes, ""); err != nil {
+		t.Fatalf("QueuePromptByNodes: %v", err)
+	}
+
+	extraData, ok := r.body(t, "/prompt")["extra_data"].(map[string]interface{})
+	if !ok {
+		t.Fatal("extra_data is missing")
+	}
+	if info, ok := extraData["extra_pnginfo"].(map[string]interface{}); !ok || len(info) != 0 {
+		t.Fatalf("extra_pnginfo = %v, want {}", extraData["extra_pnginfo"])
+	}
+}
+
+func TestCancelPrompt(t *testing.T) {
+	server, client := newTestServer(t)
+	completed := completions(t, client)
+	server.SetDefaultBehavior(&comfytest.Behavior{NodeDelay: 2 * time.Second})
+
+	ctx := context.Background()
+	running, err := client.QueuePromptByNodesWithOptions(ctx, testPrompt(), nil)
+	if err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	pending, err := client.QueuePromptByNodesWithOptions(ctx, testPrompt(), nil)
+	if err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	for _, id := range []string{pending.PromptID, running.PromptID} {
+		if err := client.CancelPrompt(ctx, id); err != nil {
+			t.Fatalf("CancelPrompt %s: %v", id, err)
+		}
+	}
+
+	cancelled := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		data := nextCompleted(t, completed)
+		if data.Status != comfyUIclient.ExecutionInterrupted {
+			t.Fatalf("%s completed with %v, want %v", data.PromptID, data.Status, comfyUIclient.ExecutionInterrupted)
+		}
+		cancelled[data.PromptID] = true
+	}
+	if !cancelled[running.PromptID] || !cancelled[pending.PromptID] {
+		t.Fatalf("cancelled %v", cancelled)
+	}
+}
+
+func TestDeleteQueueByPromptID(t *testing.T) {
+	server, client := newTestServer(t)
+	completions(t, client)
+	server.Pause()
+	defer server.Resume()
+
+	ctx := context.Background()
+	var ids []string
+	for i := 0; i < 2; i++ {
+		resp, err := client.QueuePromptByNodesWithOptions(ctx, testPrompt(), nil)
+		if err != nil {
+			t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+		}
+		ids = append(ids, resp.PromptID)
+	}
+	// the fake server rejects a delete that isn't a list like ComfyUI, which deletes one id per character of a string
+	if err := client.DeleteQueueByPromptID(ids[1]); err != nil {
+		t.Fatalf("DeleteQueueByPromptID: %v", err)
+	}
+
+	info, err := client.GetQueueInfo()
+	if err != nil {
+		t.Fatalf("GetQueueInfo: %v", err)
+	}
+	var pending []string
+	for _, node := range info.QueuePending {
+		pending = append(pending, node.PromptID)
+	}
+	if len(pending) != 1 || pending[0] != ids[0] {
+		t.Fatalf("pending = %v, want [%s]", pending, ids[0])
+	}
+}
+
+func TestQueuePromptRejected(t *testing.T) {
+	server, client := newTestServer(t)
+	nodeErrors := map[string]interface{}{"2": map[string]interface{}{"errors": []interface{}{"required_input_missing"}}}
+	unknown := comfyUIclient.Prompt{"1": {ClassType: "NoSuchNode", Inputs: map[string]interface{}{}}}
+
+	tests := []struct {
+		name       string
+		prompt     comfyUIclient.Prompt
+		behavior   *comfytest.Behavior
+		errorType  string
+		nodeErrors bool
+	}{
+		{name: "unknown class type", prompt: unknown, errorType: "invalid_prompt"},
+		{name: "node errors", prompt: testPrompt(), behavior: &comfytest.Behavior{NodeErrors: nodeErrors}, errorType: "prompt_outputs_failed_validation", nodeErrors: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.behavior != nil {
+				server.Script(tt.behavior)
+			}
+			resp, err := client.QueuePromptByNodesWithOptions(context.Background(), tt.prompt, nil)
+			var rejected *comfyUIclient.PromptRejectedError
+			if !errors.As(err, &rejected) {
+				t.Fatalf("QueuePromptByNodesWithOptions = %+v, %v, want a *PromptRejectedError", resp, err)
+			}
+			if rejected.StatusCode != http.StatusBadRequest || rejected.Type != tt.errorType || rejected.Message == "" {
+				t.Fatalf("rejected = %+v", rejected)
+			}
+			if (len(rejected.NodeErrors) != 0) != tt.nodeErrors {
+				t.Fatalf("node errors = %v", rejected.NodeErrors)
+			}
+		})
+	}
+}
+
+func fastRetries() comfyUIclient.Option {
+	return comfyUIclient.WithRetryPolicy(comfyUIclient.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     100 * time.Millisecond,
+		Multiplier:     2,
+		RetryStatuses:  []int{http.StatusServiceUnavailable},
+	})
+}
+
+// promptPosts counts the requests that queued a prompt
+func promptPosts(server *comfytest.Server) int {
+	posts := 0
+	for _, request := range server.Requests() {
+		if request.Method == http.MethodPost && request.Path == string(comfyUIclient.PromptRouter) {
+			posts++
+		}
+	}
+	return posts
+}
+
+func TestQueuePromptRetriesRejectedRequest(t *testing.T) {
+	server, client := newTestServer(t, fastRetries())
+	completed := completions(t, client)
+	server.AddFault(&comfytest.Fault{Method: http.MethodPost, Path: "/prompt", Status: http.StatusServiceUnavailable, Times: 1})
+
+	resp, err := client.QueuePromptByNodesWithOptions(context.Background(), testPrompt(), nil)
+	if err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	if posts := promptPosts(server); posts != 2 {
+		t.Fatalf("posted the prompt %d times, want 2", posts)
+	}
+	if data := nextCompleted(t, completed); data.PromptID != resp.PromptID || data.Status != comfyUIclient.ExecutionSuccess {
+		t.Fatalf("completed %s with %v, want %s with %v", data.PromptID, data.Status, resp.PromptID, comfyUIclient.ExecutionSuccess)
+	}
+}
+
+// lostResponseTransport fails the first POST /prompt after the server handled it
+type lostResponseTransport struct {
+	next http.RoundTripper
+	lost atomic.Bool
+}
+
+func (l *lostResponseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := l.next.RoundTrip(req)
+	if err == nil && req.Method == http.MethodPost && req.URL.Path == "/prompt" && !l.lost.Swap(true) {
+		resp.Body.Close()
+		return nil, errors.New("connection reset")
+	}
+	return resp, err
+}
+
+func TestQueuePromptDoesntRepostEnqueuedPrompt(t *testing.T) {
+	transport := &lostResponseTransport{}
+	server, client := newTestServer(t, fastRetries(), comfyUIclient.WithHTTPTransport(func(next http.RoundTripper) http.RoundTripper {
+		transport.next = next
+		return transport
+	}))
+	completed := completions(t, client)
+
+	resp, err := client.QueuePromptByNodesWithOptions(context.Background(), testPrompt(), nil)
+	if err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	if !transport.lost.Load() {
+		t.Fatal("no response was lost")
+	}
+	if posts := promptPosts(server); posts != 1 {
+		t.Fatalf("posted the prompt %d times, want 1", posts)
+	}
+	if data := nextCompleted(t, completed); data.PromptID != resp.PromptID || data.Status != comfyUIclient.ExecutionSuccess {
+		t.Fatalf("completed %s with %v, want %s with %v", data.PromptID, data.Status, resp.PromptID, comfyUIclient.ExecutionSuccess)
+	}
+}
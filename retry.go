@@ -0,0 +1,158 @@
+package comfyUIclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed http requests are retried
+//
+// Idempotent GET requests are retried on transport errors and RetryStatuses.
+// POST /prompt is only retried after the client made sure that the prompt was not enqueued.
+// Other requests are never retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one, 1 disables retries
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the exponential backoff, a Retry-After header may ask for longer
+	MaxBackoff time.Duration
+	// Multiplier is applied to the backoff after every attempt
+	Multiplier float64
+	// RetryStatuses are the http status codes that are retried
+	RetryStatuses []int
+}
+
+// DefaultRetryPolicy returns the policy used when no WithRetryPolicy option is given
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+		RetryStatuses: []int{
+			http.StatusTooManyRequests,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// NoRetryPolicy returns a policy that disables retries
+func NoRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 1}
+}
+
+// WithRetryPolicy sets how failed http requests are retried
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *clientOptions) {
+		o.retryPolicy = policy
+	}
+}
+
+// shouldRetry reports whether the result of an attempt is transient
+func (p *RetryPolicy) shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		// only errors of http.Client.Do are transient, a canceled request must not be retried
+		var urlErr *url.Error
+		return errors.As(err, &urlErr) && !errors.Is(err, context.Canceled)
+	}
+	for _, status := range p.RetryStatuses {
+		if resp.StatusCode == status {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the wait before the next attempt, attempt starts at 1
+func (p *RetryPolicy) backoff(attempt int, resp *http.Response) time.Duration {
+	wait := p.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		wait = time.Duration(float64(wait) * p.Multiplier)
+		if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+			wait = p.MaxBackoff
+			break
+		}
+	}
+	// jitter in [wait/2, wait) keeps many clients from retrying in lockstep
+	if wait > 0 {
+		wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+	}
+
+	if retryAfter, ok := parseRetryAfter(resp); ok && retryAfter > wait {
+		wait = retryAfter
+	}
+	return wait
+}
+
+func parseRetryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date), true
+	}
+	return 0, false
+}
+
+// requestWithRetry sends a request with makeRequest, idempotent requests are retried by c.retryPolicy
+func (c *Client) requestWithRetry(ctx context.Context, method, router string, values url.Values, data interface{}, headers map[string]string, contentType string) (*http.Response, error) {
+	ctx, end := c.startRequestSpan(ctx, method, router)
+	idempotent := method == http.MethodGet || method == http.MethodHead
+	for attempt := 1; ; attempt++ {
+		resp, err := c.makeRequest(ctx, method, router, values, data, headers, contentType)
+		if !idempotent || attempt >= c.retryPolicy.MaxAttempts || !c.retryPolicy.shouldRetry(resp, err) {
+			end(resp, err, attempt)
+			return resp, err
+		}
+
+		wait := c.retryPolicy.backoff(attempt, resp)
+		c.logger.Info("retrying request", "method", method, "router", router, "attempt", attempt, "wait", wait, "error", describeAttempt(resp, err))
+		discardBody(resp)
+		if err := sleepContext(ctx, wait); err != nil {
+			end(nil, err, attempt)
+			return nil, err
+		}
+	}
+}
+
+func describeAttempt(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
+
+func discardBody(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+// maxRequestSize limits the body of job requests
+const maxRequestSize = 1 << 20
+
+// heartbeatInterval is the time between comments that keep idle event streams open
+const heartbeatInterval = 15 * time.Second
+
+type jobRequest struct {
+	Template string                 `json:"template"`
+	Params   map[string]interface{} `json:"params"`
+}
+
+func (s *Server) createJob(w http.ResponseWriter, r *http.Request, tenant *Tenant) {
+	var request jobRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	decoder.UseNumber()
+	if err := decoder.Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	template, ok := s.templates[request.Template]
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown template %q", request.Template))
+		return
+	}
+	prompt, err := template.build(request.Params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	id := comfyUIclient.NewPromptID()
+	j := &job{
+		id:       id,
+		tenant:   tenant.ID,
+		template: template,
+		backend:  s.pick(),
+		view: Job{
+			ID:        id,
+			Template:  template.Name,
+			Params:    request.Params,
+			Status:    JobQueued,
+			CreatedAt: time.Now(),
+		},
+		tracker: comfyUIclient.NewProgressTracker(id, prompt, template.expectedNodes(prompt)),
+		changed: make(chan struct{}),
+	}
+	j.tracker.OnUpdate(j.setProgress)
+	j.mu.Lock()
+	j.publish("status", j.view)
+	j.mu.Unlock()
+
+	// the job receives events from now on, the prompt may start before QueuePrompt returns
+	if !s.jobs.addLimited(j, tenant.MaxActiveJobs) {
+		writeError(w, http.StatusTooManyRequests, fmt.Sprintf("at most %d active jobs", tenant.MaxActiveJobs))
+		return
+	}
+	j.backend.acquire(j)
+	resp, err := j.backend.client.QueuePromptByNodesWithOptions(r.Context(), prompt, &comfyUIclient.QueueOptions{
+		PromptID:                id,
+		PartialExecutionTargets: template.Outputs,
+	})
+	var rejected *comfyUIclient.PromptRejectedError
+	switch {
+	case errors.As(err, &rejected) && rejected.StatusCode == http.StatusBadRequest:
+		s.jobs.remove(id)
+		j.backend.release(j)
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":       "the prompt of the template is invalid: " + rejected.Message,
+			"node_errors": rejected.NodeErrors,
+		})
+		return
+	case err != nil:
+		s.jobs.remove(id)
+		j.backend.release(j)
+		s.logger.Error("queue prompt failed", "job", id, "tenant", tenant.ID, "error", err)
+		writeError(w, http.StatusBadGateway, "queue prompt failed")
+		return
+	case len(resp.NodeErrors) != 0:
+		s.jobs.remove(id)
+		j.backend.release(j)
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":       "the prompt of the template is invalid",
+			"node_errors": resp.NodeErrors,
+		})
+		return
+	case resp.PromptID == "":
+		// the job would wait forever for a prompt the server didn't queue
+		s.jobs.remove(id)
+		j.backend.release(j)
+		s.logger.Error("queue prompt returned no prompt id", "job", id, "tenant", tenant.ID)
+		writeError(w, http.StatusBadGateway, "queue prompt failed")
+		return
+	}
+
+	w.Header().Set("Location", s.baseURL+"/jobs/"+id)
+	writeJSON(w, http.StatusAccepted, j.snapshot())
+}
+
+// deleteJob cancels an active job, a finished job is removed from the store
+func (s *Server) deleteJob(w http.ResponseWriter, r *http.Request, j *job) {
+	if j.snapshot().Status.Finished() {
+		s.jobs.remove(j.id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := s.cancel(r.Context(), j); err != nil {
+		if errors.Is(err, comfyUIclient.ErrPromptStillQueued) {
+			writeError(w, http.StatusConflict, "prompt is still queued")
+			return
+		}
+		s.logger.Error("cancel job failed", "job", j.id, "tenant", j.tenant, "error", err)
+		writeError(w, http.StatusBadGateway, "cancel job failed")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, j.snapshot())
+}
+
+// cancelAttempts is how often cancel deletes a prompt that is still queued
+const cancelAttempts = 3
+
+// cancel removes the prompt of j from the queue, or interrupts it when it's running
+// The prompt then completes as interrupted, which finishes the job as cancelled
+func (s *Server) cancel(ctx context.Context, j *job) error {
+	j.mu.Lock()
+	j.cancelled = true
+	j.mu.Unlock()
+
+	var err error
+	for attempt := 1; attempt <= cancelAttempts; attempt++ {
+		err = j.backend.client.CancelPrompt(ctx, j.id)
+		if !errors.Is(err, comfyUIclient.ErrPromptStillQueued) || attempt == cancelAttempts {
+			break
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+		}
+	}
+	if err != nil {
+		j.mu.Lock()
+		j.cancelled = false
+		j.mu.Unlock()
+		return fmt.Errorf("client.CancelPrompt: error: %w", err)
+	}
+	return nil
+}
+
+// streamEvents sends the events of j until it finished, a reconnecting client gets the events after Last-Event-ID
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, j *job) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	last, _ := strconv.Atoi(lastID)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		events, changed, finished := j.eventsAfter(last)
+		for _, e := range events {
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.id, e.name, e.data); err != nil {
+				return
+			}
+			last = e.id
+		}
+		flusher.Flush()
+		if finished {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) getOutput(w http.ResponseWriter, j *job, index int) {
+	outputs := j.snapshot().Outputs
+	if index < 0 || index >= len(outputs) {
+		writeError(w, http.StatusNotFound, "output not found")
+		return
+	}
+	output := outputs[index]
+	data, err := j.backend.client.GetFile(output.file)
+	if err != nil {
+		s.logger.Error("get output failed", "job", j.id, "file", output.Filename, "error", err)
+		writeError(w, http.StatusBadGateway, "get output failed")
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(output.Filename))
+	if contentType == "" {
+		contentType = http.DetectContentType(*data)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(*data)))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": output.Filename}))
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(*data)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+// JobStatus is the state of a job
+type JobStatus string
+
+const (
+	JobQueued      JobStatus = "queued"
+	JobRunning     JobStatus = "running"
+	JobSucceeded   JobStatus = "succeeded"
+	JobFailed      JobStatus = "failed"
+	JobInterrupted JobStatus = "interrupted"
+	JobCancelled   JobStatus = "cancelled"
+)
+
+// Finished reports whether the job will not change anymore
+func (s JobStatus) Finished() bool {
+	return s != JobQueued && s != JobRunning
+}
+
+// Job is the state of a job as returned by the api
+type Job struct {
+	ID         string                 `json:"id"`
+	Template   string                 `json:"template"`
+	Params     map[string]interface{} `json:"params,omitempty"`
+	Status     JobStatus              `json:"status"`
+	Progress   *Progress              `json:"progress,omitempty"`
+	Outputs    []*Output              `json:"outputs,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	CreatedAt  time.Time              `json:"created_at"`
+	StartedAt  *time.Time             `json:"started_at,omitempty"`
+	FinishedAt *time.Time             `json:"finished_at,omitempty"`
+}
+
+// Progress is the estimated progress of a running job
+type Progress struct {
+	Percent    float64 `json:"percent"`
+	NodesDone  int     `json:"nodes_done"`
+	NodesTotal int     `json:"nodes_total"`
+	Node       string  `json:"node,omitempty"`
+	ClassType  string  `json:"class_type,omitempty"`
+	Step       int     `json:"step,omitempty"`
+	Steps      int     `json:"steps,omitempty"`
+	// ETA is the estimated remaining time in seconds, omitted when unknown
+	ETA float64 `json:"eta,omitempty"`
+}
+
+// Output is a file produced by a job
+type Output struct {
+	Index int    `json:"index"`
+	Node  string `json:"node"`
+	// Kind is the output name of the node, e.g. images, gifs or audio
+	Kind     string `json:"kind"`
+	Filename string `json:"filename"`
+	URL      string `json:"url"`
+
+	file *comfyUIclient.DataOutputFile
+}
+
+// eventBufferSize is the number of events a job keeps for clients that reconnect with Last-Event-ID
+const eventBufferSize = 256
+
+// event is a server-sent event of a job
+type event struct {
+	id   int
+	name string
+	data []byte
+}
+
+// job is the mutable state behind Job, every field after mu is guarded by it
+type job struct {
+	id       string
+	tenant   string
+	template *Template
+	backend  *backend
+
+	mu        sync.Mutex
+	view      Job
+	tracker   *comfyUIclient.ProgressTracker
+	cancelled bool
+	events    []event
+	nextEvent int
+	// changed is closed and replaced whenever an event is added
+	changed chan struct{}
+}
+
+func (j *job) snapshot() Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	view := j.view
+	return view
+}
+
+// publish appends an event with data as json, j.mu must be held
+func (j *job) publish(name string, data interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	j.nextEvent++
+	j.events = append(j.events, event{id: j.nextEvent, name: name, data: b})
+	if len(j.events) > eventBufferSize {
+		j.events = j.events[len(j.events)-eventBufferSize:]
+	}
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// eventsAfter returns the buffered events after id, and a channel that is closed on the next event
+func (j *job) eventsAfter(id int) ([]event, <-chan struct{}, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	i := sort.Search(len(j.events), func(i int) bool { return j.events[i].id > id })
+	return append([]event(nil), j.events[i:]...), j.changed, j.view.Status.Finished()
+}
+
+// setStatus changes the status and publishes the job, j.mu must be held
+func (j *job) setStatus(status JobStatus) {
+	if j.view.Status.Finished() {
+		return
+	}
+	now := time.Now()
+	switch {
+	case status == JobRunning:
+		j.view.StartedAt = &now
+	case status.Finished():
+		j.view.FinishedAt = &now
+		j.view.Progress = nil
+	}
+	j.view.Status = status
+	j.publish("status", j.view)
+}
+
+func (j *job) setProgress(snapshot comfyUIclient.ProgressSnapshot) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.view.Status.Finished() || snapshot.Done {
+		return
+	}
+	if j.view.Status == JobQueued {
+		j.setStatus(JobRunning)
+	}
+	progress := &Progress{
+		Percent:    snapshot.Percent,
+		NodesDone:  snapshot.NodesDone,
+		NodesTotal: snapshot.NodesTotal,
+		Node:       snapshot.CurrentNode,
+		ClassType:  snapshot.CurrentClassType,
+		Step:       snapshot.StepValue,
+		Steps:      snapshot.StepMax,
+		ETA:        snapshot.ETA.Seconds(),
+	}
+	// the executing and progress events of a step may carry the same progress
+	if last := j.view.Progress; last != nil && last.NodesDone == progress.NodesDone && last.Node == progress.Node && last.Step == progress.Step {
+		return
+	}
+	j.view.Progress = progress
+	j.publish("progress", progress)
+}
+
+// complete finishes the job with the Completed message of its prompt
+func (j *job) complete(data *comfyUIclient.WSMessageDataCompleted, baseURL string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch data.Status {
+	case comfyUIclient.ExecutionSuccess:
+		j.view.Outputs = j.outputs(data.Outputs, baseURL)
+		j.setStatus(JobSucceeded)
+	case comfyUIclient.ExecutionError:
+		j.view.Error = "execution error"
+		if data.Error != nil {
+			j.view.Error = data.Error.NodeType + ": " + data.Error.ExceptionMessage
+		}
+		j.setStatus(JobFailed)
+	case comfyUIclient.ExecutionInterrupted:
+		if j.cancelled {
+			j.setStatus(JobCancelled)
+			return
+		}
+		j.setStatus(JobInterrupted)
+	}
+}
+
+// outputs lists the files of the template outputs ordered by node id and output name, j.mu must be held
+func (j *job) outputs(files map[string]map[string][]*comfyUIclient.DataOutputFile, baseURL string) []*Output {
+	nodes := j.template.Outputs
+	if len(nodes) == 0 {
+		for node := range files {
+			nodes = append(nodes, node)
+		}
+	}
+	nodes = append([]string(nil), nodes...)
+	sort.Strings(nodes)
+
+	var outputs []*Output
+	for _, node := range nodes {
+		kinds := make([]string, 0, len(files[node]))
+		for kind := range files[node] {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			for _, file := range files[node][kind] {
+				// previews of temp outputs are not results
+				if file.Type == string(comfyUIclient.TempImageType) && len(j.template.Outputs) == 0 {
+					continue
+				}
+				index := len(outputs)
+				outputs = append(outputs, &Output{
+					Index:    index,
+					Node:     node,
+					Kind:     kind,
+					Filename: file.Filename,
+					URL:      outputURL(baseURL, j.id, index),
+					file:     file,
+				})
+			}
+		}
+	}
+	return outputs
+}
+
+// jobStore keeps the jobs in memory, finished jobs are dropped after the retention
+type jobStore struct {
+	mu        sync.Mutex
+	jobs      map[string]*job
+	retention time.Duration
+}
+
+func newJobStore(retention time.Duration) *jobStore {
+	return &jobStore{jobs: make(map[string]*job), retention: retention}
+}
+
+func (s *jobStore) get(id string) *job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs[id]
+}
+
+func (s *jobStore) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, id)
+}
+
+// list returns the jobs of tenant, newest first
+func (s *jobStore) list(tenant string) []*job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	var jobs []*job
+	for _, j := range s.jobs {
+		if j.tenant == tenant {
+			jobs = append(jobs, j)
+		}
+	}
+	sort.Slice(jobs, func(a, b int) bool {
+		return jobs[a].view.CreatedAt.After(jobs[b].view.CreatedAt)
+	})
+	return jobs
+}
+
+// addLimited adds j unless its tenant already has limit unfinished jobs, a limit that isn't positive means no limit
+func (s *jobStore) addLimited(j *job, limit int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	if limit > 0 {
+		active := 0
+		for _, other := range s.jobs {
+			if other.tenant == j.tenant && !other.snapshot().Status.Finished() {
+				active++
+			}
+		}
+		if active >= limit {
+			return false
+		}
+	}
+	s.jobs[j.id] = j
+	return true
+}
+
+// prune drops jobs that finished before the retention, s.mu must be held
+func (s *jobStore) prune() {
+	deadline := time.Now().Add(-s.retention)
+	for id, j := range s.jobs {
+		j.mu.Lock()
+		expired := j.view.FinishedAt != nil && j.view.FinishedAt.Before(deadline)
+		j.mu.Unlock()
+		if expired {
+			delete(s.jobs, id)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+// Package server exposes ComfyUI servers as a simple job api
+//
+// A job runs a Template with params on one of the backends:
+//
+//	POST   /jobs                  {"template": "txt2img", "params": {"prompt": "a cat"}}
+//	GET    /jobs                  jobs of the tenant, newest first
+//	GET    /jobs/{id}             state, progress and outputs of a job
+//	GET    /jobs/{id}/events      status and progress as server-sent events
+//	GET    /jobs/{id}/outputs/{n} the n-th output file
+//	DELETE /jobs/{id}             cancel a running job, or forget a finished one
+//	GET    /templates             the templates and their params
+//	GET    /events                websocket events of the jobs of the tenant, ?prompt_id=<job id> for one job, see package sse
+//
+// Requests are authenticated with the api key of a tenant, sent as "Authorization: Bearer <key>" or "X-API-Key: <key>".
+// Tenants only see their own jobs.
+package server
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/XdpCs/comfyUIclient/sse"
+)
+
+// Tenant is a user of the api
+type Tenant struct {
+	ID      string   `json:"id"`
+	APIKeys []string `json:"api_keys"`
+	// MaxActiveJobs limits the queued and running jobs of the tenant, unlimited when not positive
+	MaxActiveJobs int `json:"max_active_jobs,omitempty"`
+}
+
+// Options configures a Server
+type Options struct {
+	// Backends run the jobs, a job is queued on the connected backend with the fewest active jobs
+	// The clients must be listening for events, see comfyUIclient.Client.ConnectAndListen
+	// Jobs whose events were lost are finished by the resync of the clients, see comfyUIclient.WithResync
+	// Create them with comfyUIclient.WithEventBufferSize(-1) unless GetTaskStatus is read, messages are queued on it for nothing otherwise
+	Backends  []*comfyUIclient.Client
+	Templates []*Template
+	Tenants   []*Tenant
+	// BaseURL is prepended to output urls, e.g. "https://api.example.com/comfy", urls are relative when empty
+	BaseURL string
+	// JobRetention is how long finished jobs are kept, one hour when zero
+	JobRetention time.Duration
+	// Logger logs failed backend requests, slog.Default() when nil
+	Logger comfyUIclient.Logger
+}
+
+// Server is an http.Handler serving the job api
+type Server struct {
+	backends  []*backend
+	templates map[string]*Template
+	tenants   map[[sha256.Size]byte]*Tenant
+	jobs      *jobStore
+	events    *sse.Bridge
+	baseURL   string
+	logger    comfyUIclient.Logger
+}
+
+// backend is a ComfyUI server, active counts its unfinished jobs
+type backend struct {
+	client *comfyUIclient.Client
+	remove func()
+
+	mu     sync.Mutex
+	active int
+	jobs   map[string]*job
+}
+
+// New creates a Server, it listens to the events of the backends until Close
+func New(opts *Options) (*Server, error) {
+	if len(opts.Backends) == 0 {
+		return nil, errors.New("no backends")
+	}
+	s := &Server{
+		templates: make(map[string]*Template, len(opts.Templates)),
+		tenants:   make(map[[sha256.Size]byte]*Tenant),
+		jobs:      newJobStore(opts.JobRetention),
+		baseURL:   strings.TrimSuffix(opts.BaseURL, "/"),
+		logger:    opts.Logger,
+	}
+	if s.jobs.retention == 0 {
+		s.jobs.retention = time.Hour
+	}
+	if s.logger == nil {
+		s.logger = slog.Default()
+	}
+	for _, template := range opts.Templates {
+		if err := template.validate(); err != nil {
+			return nil, fmt.Errorf("template %s: %w", template.Name, err)
+		}
+		if _, ok := s.templates[template.Name]; ok {
+			return nil, fmt.Errorf("duplicate template %s", template.Name)
+		}
+		s.templates[template.Name] = template
+	}
+	for _, tenant := range opts.Tenants {
+		for _, key := range tenant.APIKeys {
+			hash := sha256.Sum256([]byte(key))
+			if _, ok := s.tenants[hash]; ok || key == "" {
+				return nil, fmt.Errorf("tenant %s: empty or duplicate api key", tenant.ID)
+			}
+			s.tenants[hash] = tenant
+		}
+	}
+	for _, client := range opts.Backends {
+		b := &backend{client: client, jobs: make(map[string]*job)}
+		b.remove = client.AddListener(func(message *comfyUIclient.WSMessage) { s.handle(b, message) })
+		s.backends = append(s.backends, b)
+	}
+	s.events = sse.New(&sse.Options{PreviewMode: sse.PreviewURL}, opts.Backends...)
+	return s, nil
+}
+
+// Close stops listening to the backends, the backends are not closed
+func (s *Server) Close() {
+	for _, b := range s.backends {
+		b.remove()
+	}
+	s.events.Close()
+}
+
+// handle feeds the events of a backend to the jobs of their prompts
+func (s *Server) handle(b *backend, message *comfyUIclient.WSMessage) {
+	promptID := message.PromptID()
+	if promptID == "" {
+		return
+	}
+	b.mu.Lock()
+	j := b.jobs[promptID]
+	b.mu.Unlock()
+	if j == nil {
+		return
+	}
+
+	j.tracker.Handle(message)
+	if data, ok := message.Data.(*comfyUIclient.WSMessageDataCompleted); ok {
+		j.complete(data, s.baseURL)
+		b.release(j)
+	}
+}
+
+// pick returns the connected backend with the fewest active jobs, any backend when none is connected
+func (s *Server) pick() *backend {
+	var best *backend
+	bestActive := 0
+	for _, b := range s.backends {
+		if !b.client.IsInitialized() {
+			continue
+		}
+		b.mu.Lock()
+		active := b.active
+		b.mu.Unlock()
+		if best == nil || active < bestActive {
+			best, bestActive = b, active
+		}
+	}
+	if best == nil {
+		return s.backends[0]
+	}
+	return best
+}
+
+func (b *backend) acquire(j *job) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.active++
+	b.jobs[j.id] = j
+}
+
+func (b *backend) release(j *job) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.jobs[j.id]; ok {
+		b.active--
+		delete(b.jobs, j.id)
+	}
+}
+
+// ServeHTTP routes the request to the handler of its path
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tenant := s.authenticate(r)
+	if tenant == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="comfyUIclient"`)
+		writeError(w, http.StatusUnauthorized, "missing or invalid api key")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "templates":
+		s.allow(w, r, http.MethodGet, func() { s.listTemplates(w) })
+	case len(parts) == 1 && parts[0] == "events":
+		s.events.Serve(w, r, func(promptID string) bool {
+			j := s.jobs.get(promptID)
+			return j != nil && j.tenant == tenant.ID
+		})
+	case len(parts) == 1 && parts[0] == "jobs":
+		switch r.Method {
+		case http.MethodGet:
+			s.listJobs(w, tenant)
+		case http.MethodPost:
+			s.createJob(w, r, tenant)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	case len(parts) >= 2 && parts[0] == "jobs":
+		j := s.jobs.get(parts[1])
+		if j == nil || j.tenant != tenant.ID {
+			writeError(w, http.StatusNotFound, "job not found")
+			return
+		}
+		switch {
+		case len(parts) == 2 && r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, j.snapshot())
+		case len(parts) == 2 && r.Method == http.MethodDelete:
+			s.deleteJob(w, r, j)
+		case len(parts) == 2:
+			w.Header().Set("Allow", "GET, DELETE")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		case len(parts) == 3 && parts[2] == "events":
+			s.allow(w, r, http.MethodGet, func() { s.streamEvents(w, r, j) })
+		case len(parts) == 4 && parts[2] == "outputs":
+			index, err := strconv.Atoi(parts[3])
+			if err != nil {
+				writeError(w, http.StatusNotFound, "output not found")
+				return
+			}
+			s.allow(w, r, http.MethodGet, func() { s.getOutput(w, j, index) })
+		default:
+			writeError(w, http.StatusNotFound, "not found")
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) allow(w http.ResponseWriter, r *http.Request, method string, handler func()) {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	handler()
+}
+
+// authenticate returns the tenant of the api key of r, nil when it's unknown
+func (s *Server) authenticate(r *http.Request) *Tenant {
+	key := r.Header.Get("X-API-Key")
+	if authorization := r.Header.Get("Authorization"); key == "" && strings.HasPrefix(authorization, "Bearer ") {
+		key = strings.TrimPrefix(authorization, "Bearer ")
+	}
+	if key == "" {
+		return nil
+	}
+	return s.tenants[sha256.Sum256([]byte(key))]
+}
+
+func (s *Server) listTemplates(w http.ResponseWriter) {
+	type templateView struct {
+		Name        string            `json:"name"`
+		Description string            `json:"description,omitempty"`
+		Params      map[string]*Param `json:"params"`
+	}
+	views := make([]templateView, 0, len(s.templates))
+	for _, template := range s.templates {
+		views = append(views, templateView{Name: template.Name, Description: template.Description, Params: template.Params})
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (s *Server) listJobs(w http.ResponseWriter, tenant *Tenant) {
+	jobs := s.jobs.list(tenant.ID)
+	views := make([]Job, 0, len(jobs))
+	for _, j := range jobs {
+		views = append(views, j.snapshot())
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func outputURL(baseURL, jobID string, index int) string {
+	return fmt.Sprintf("%s/jobs/%s/outputs/%d", baseURL, jobID, index)
+}
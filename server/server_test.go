@@ -0,0 +1,146 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/XdpCs/comfyUIclient/comfytest"
+	"github.com/XdpCs/comfyUIclient/server"
+)
+
+func newTestAPI(t *testing.T, maxActiveJobs int) (*comfytest.Server, *httptest.Server) {
+	t.Helper()
+	backend := comfytest.NewServer()
+	t.Cleanup(backend.Close)
+	client, err := backend.Client(comfyUIclient.WithEventBufferSize(-1))
+	if err != nil {
+		t.Fatalf("backend.Client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	api, err := server.New(&server.Options{
+		Backends: []*comfyUIclient.Client{client},
+		Templates: []*server.Template{{
+			Name: "txt2img",
+			Prompt: comfyUIclient.Prompt{
+				"1": {ClassType: "EmptyLatentImage", Inputs: map[string]interface{}{"width": 64, "height": 64, "batch_size": 1}},
+				"2": {ClassType: "SaveImage", Inputs: map[string]interface{}{"images": []interface{}{"1", 0}}},
+			},
+			Outputs: []string{"2"},
+		}, {
+			// ComfyUI rejects the unknown class_type with 400 and no prompt id
+			Name: "invalid",
+			Prompt: comfyUIclient.Prompt{
+				"1": {ClassType: "NoSuchNode", Inputs: map[string]interface{}{}},
+				"2": {ClassType: "SaveImage", Inputs: map[string]interface{}{"images": []interface{}{"1", 0}}},
+			},
+			Outputs: []string{"2"},
+		}},
+		Tenants: []*server.Tenant{{ID: "tenant", APIKeys: []string{"key"}, MaxActiveJobs: maxActiveJobs}},
+	})
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	t.Cleanup(api.Close)
+	httpServer := httptest.NewServer(api)
+	t.Cleanup(httpServer.Close)
+	return backend, httpServer
+}
+
+// request sends an authenticated request to the api, it may be called from other goroutines
+func request(t *testing.T, method, url, body string) (int, *server.Job) {
+	t.Helper()
+	job := &server.Job{}
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Errorf("http.NewRequest: %v", err)
+		return 0, job
+	}
+	req.Header.Set("X-API-Key", "key")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("%s %s: %v", method, url, err)
+		return 0, job
+	}
+	defer resp.Body.Close()
+	_ = json.NewDecoder(resp.Body).Decode(job)
+	return resp.StatusCode, job
+}
+
+func TestMaxActiveJobsUnderConcurrentRequests(t *testing.T) {
+	backend, api := newTestAPI(t, 2)
+	backend.Pause()
+	defer backend.Resume()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		statuses = map[int]int{}
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, _ := request(t, http.MethodPost, api.URL+"/jobs", `{"template": "txt2img"}`)
+			mu.Lock()
+			statuses[status]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if statuses[http.StatusAccepted] != 2 || statuses[http.StatusTooManyRequests] != 8 {
+		t.Fatalf("statuses = %v, want 2 accepted and 8 rejected", statuses)
+	}
+}
+
+func TestCancelQueuedJob(t *testing.T) {
+	backend, api := newTestAPI(t, 0)
+	backend.Pause()
+	defer backend.Resume()
+
+	status, job := request(t, http.MethodPost, api.URL+"/jobs", `{"template": "txt2img"}`)
+	if status != http.StatusAccepted {
+		t.Fatalf("POST /jobs = %d", status)
+	}
+	if status, _ := request(t, http.MethodDelete, api.URL+"/jobs/"+job.ID, ""); status != http.StatusAccepted {
+		t.Fatalf("DELETE /jobs/%s = %d", job.ID, status)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, job = request(t, http.MethodGet, api.URL+"/jobs/"+job.ID, "")
+		if job.Status == server.JobCancelled {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job status = %s, want %s", job.Status, server.JobCancelled)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestInvalidTemplateReleasesJob(t *testing.T) {
+	backend, api := newTestAPI(t, 1)
+	backend.Pause()
+	defer backend.Resume()
+
+	// a rejected job doesn't keep the only active job slot
+	for i := 0; i < 2; i++ {
+		if status, _ := request(t, http.MethodPost, api.URL+"/jobs", `{"template": "invalid"}`); status != http.StatusUnprocessableEntity {
+			t.Fatalf("POST /jobs with the invalid template = %d, want %d", status, http.StatusUnprocessableEntity)
+		}
+	}
+	status, job := request(t, http.MethodPost, api.URL+"/jobs", `{"template": "txt2img"}`)
+	if status != http.StatusAccepted {
+		t.Fatalf("POST /jobs = %d, want %d", status, http.StatusAccepted)
+	}
+	if job.Status != server.JobQueued {
+		t.Fatalf("job status = %s, want %s", job.Status, server.JobQueued)
+	}
+}
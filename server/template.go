@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/XdpCs/comfyUIclient/graph"
+)
+
+// Template is an api format workflow whose inputs are exposed as named params
+type Template struct {
+	// Name is used in job requests, the file name without extension when loaded by LoadTemplates
+	Name        string               `json:"name"`
+	Description string               `json:"description,omitempty"`
+	Prompt      comfyUIclient.Prompt `json:"prompt"`
+	// Params maps param names to node inputs
+	Params map[string]*Param `json:"params,omitempty"`
+	// Outputs are the ids of the nodes whose files are returned, all output files when empty
+	// Only these nodes are executed on servers that support partial execution
+	Outputs []string `json:"outputs,omitempty"`
+}
+
+// Param is an input of a node that a job may set
+type Param struct {
+	NodeID string `json:"node_id"`
+	Input  string `json:"input"`
+	// Type is string, int, number or bool, any json value is accepted when empty
+	Type        string      `json:"type,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Default     interface{} `json:"default,omitempty"`
+}
+
+// LoadTemplates reads every .json file in dir as a Template
+func LoadTemplates(dir string) ([]*Template, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("filepath.Glob: error: %w", err)
+	}
+	sort.Strings(paths)
+	templates := make([]*Template, 0, len(paths))
+	for _, path := range paths {
+		template, err := ReadTemplate(path)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+// ReadTemplate reads the Template in the json file at path
+func ReadTemplate(path string) (*Template, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: error: %w", err)
+	}
+	template := &Template{}
+	if err := json.Unmarshal(b, template); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: error: %w, file: %s", err, path)
+	}
+	if template.Name == "" {
+		template.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if err := template.validate(); err != nil {
+		return nil, fmt.Errorf("template %s: %w", path, err)
+	}
+	return template, nil
+}
+
+func (t *Template) validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("name is empty")
+	}
+	if len(t.Prompt) == 0 {
+		return fmt.Errorf("prompt is empty")
+	}
+	if _, err := graph.New(t.Prompt); err != nil {
+		return fmt.Errorf("graph.New: error: %w", err)
+	}
+	for name, param := range t.Params {
+		if _, ok := t.Prompt[param.NodeID]; !ok {
+			return fmt.Errorf("param %s: node %s not found in prompt", name, param.NodeID)
+		}
+		switch param.Type {
+		case "", "string", "int", "number", "bool":
+		default:
+			return fmt.Errorf("param %s: unknown type %s", name, param.Type)
+		}
+		if param.Default != nil {
+			if _, err := param.convert(param.Default); err != nil {
+				return fmt.Errorf("param %s: default: %w", name, err)
+			}
+		}
+	}
+	for _, node := range t.Outputs {
+		if _, ok := t.Prompt[node]; !ok {
+			return fmt.Errorf("output node %s not found in prompt", node)
+		}
+	}
+	return nil
+}
+
+// build returns a copy of the prompt with params applied
+func (t *Template) build(params map[string]interface{}) (comfyUIclient.Prompt, error) {
+	for name := range params {
+		if _, ok := t.Params[name]; !ok {
+			return nil, fmt.Errorf("unknown param %s", name)
+		}
+	}
+
+	prompt := make(comfyUIclient.Prompt, len(t.Prompt))
+	for id, node := range t.Prompt {
+		inputs := make(map[string]interface{}, len(node.Inputs))
+		for name, value := range node.Inputs {
+			inputs[name] = value
+		}
+		node.Inputs = inputs
+		prompt[id] = node
+	}
+
+	for name, param := range t.Params {
+		value, ok := params[name]
+		if !ok || value == nil {
+			if param.Required {
+				return nil, fmt.Errorf("param %s is required", name)
+			}
+			if param.Default == nil {
+				continue
+			}
+			value = param.Default
+		}
+		converted, err := param.convert(value)
+		if err != nil {
+			return nil, fmt.Errorf("param %s: %w", name, err)
+		}
+		prompt[param.NodeID].Inputs[param.Input] = converted
+	}
+	return prompt, nil
+}
+
+// convert checks that value is of the type of the param, json numbers become int64 for int params
+func (p *Param) convert(value interface{}) (interface{}, error) {
+	switch p.Type {
+	case "string":
+		if _, ok := value.(string); !ok {
+			return nil, fmt.Errorf("want a string, got %T", value)
+		}
+	case "bool":
+		if _, ok := value.(bool); !ok {
+			return nil, fmt.Errorf("want a bool, got %T", value)
+		}
+	case "number":
+		if _, ok := toFloat(value); !ok {
+			return nil, fmt.Errorf("want a number, got %T", value)
+		}
+	case "int":
+		// seeds exceed the integers a float64 holds exactly
+		if number, ok := value.(json.Number); ok {
+			if i, err := number.Int64(); err == nil {
+				return i, nil
+			}
+			if u, err := strconv.ParseUint(number.String(), 10, 64); err == nil {
+				return u, nil
+			}
+			return nil, fmt.Errorf("want an integer, got %v", value)
+		}
+		number, ok := toFloat(value)
+		if !ok || number != math.Trunc(number) || math.Abs(number) > 1<<53 {
+			return nil, fmt.Errorf("want an integer, got %v", value)
+		}
+		return int64(number), nil
+	}
+	return value, nil
+}
+
+// toFloat accepts the numbers of decoded json and of templates built in go
+func toFloat(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case float64:
+		return number, true
+	case float32:
+		return float64(number), true
+	case int:
+		return float64(number), true
+	case int64:
+		return float64(number), true
+	case json.Number:
+		f, err := number.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// expectedNodes is the number of nodes a prompt of the template executes, 0 when unknown
+func (t *Template) expectedNodes(prompt comfyUIclient.Prompt) int {
+	if len(t.Outputs) == 0 {
+		return 0
+	}
+	g, err := graph.New(prompt)
+	if err != nil {
+		return 0
+	}
+	return g.ExpectedExecutingStepsFor(t.Outputs, nil)
+}
@@ -0,0 +1,391 @@
+// Package sse re-emits the websocket events of ComfyUI clients as server-sent events for browsers
+//
+// Event names are the WsMessageType of the message, e.g. executing, progress or completed, the data is json.
+// A stream is opened with GET, ?prompt_id=<id> limits it to one prompt and ends after its completed event.
+// Reconnecting clients send Last-Event-ID and get the buffered events they missed.
+package sse
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+// PreviewMode controls how preview images are forwarded
+type PreviewMode int
+
+const (
+	// PreviewBase64 embeds the image as base64 in the event
+	PreviewBase64 PreviewMode = iota
+	// PreviewURL sends a url that serves the image, the image is kept for PreviewBufferSize previews
+	PreviewURL
+	// PreviewNone drops preview events
+	PreviewNone
+)
+
+// Filter reports whether the events of promptID may be sent to a stream
+type Filter func(promptID string) bool
+
+// Options configures a Bridge
+type Options struct {
+	// BufferSize is the number of events kept for Last-Event-ID, 1024 when not positive
+	BufferSize int
+	// PreviewMode selects how preview images are sent, PreviewBase64 by default
+	PreviewMode PreviewMode
+	// PreviewBufferSize is the number of preview images kept, 32 when not positive
+	PreviewBufferSize int
+	// Authorize returns the filter of the prompts the request may see, a nil filter allows every prompt
+	// The request is rejected with 401 when it returns an error, every request is allowed when Authorize is nil
+	Authorize func(r *http.Request) (Filter, error)
+	// HeartbeatInterval is the time between comments that keep idle streams open, 15 seconds when zero
+	HeartbeatInterval time.Duration
+}
+
+// Bridge is an http.Handler streaming the events of clients
+type Bridge struct {
+	opts    Options
+	removes []func()
+
+	mu      sync.Mutex
+	events  []*event
+	nextID  uint64
+	changed chan struct{}
+	// previews counts the events holding an image, the oldest images are dropped beyond PreviewBufferSize
+	previews []*event
+	// running is the prompt each client executes, it is used for events without prompt id
+	running map[*comfyUIclient.Client]string
+	// completed remembers finished prompts to end their streams right away
+	completed      map[string]uint64
+	completedOrder []string
+}
+
+// event is a buffered message, data is nil for previews
+type event struct {
+	id       uint64
+	promptID string
+	name     string
+	data     []byte
+	preview  *comfyUIclient.WSMessageDataPreviewImage
+}
+
+// completedSize is the number of finished prompts remembered
+const completedSize = 4096
+
+// New creates a Bridge for the events of clients until Close
+// The clients must be listening for events, see comfyUIclient.Client.ConnectAndListen
+func New(opts *Options, clients ...*comfyUIclient.Client) *Bridge {
+	b := &Bridge{
+		changed:   make(chan struct{}),
+		running:   make(map[*comfyUIclient.Client]string),
+		completed: make(map[string]uint64),
+	}
+	if opts != nil {
+		b.opts = *opts
+	}
+	if b.opts.BufferSize <= 0 {
+		b.opts.BufferSize = 1024
+	}
+	if b.opts.PreviewBufferSize <= 0 {
+		b.opts.PreviewBufferSize = 32
+	}
+	if b.opts.HeartbeatInterval <= 0 {
+		b.opts.HeartbeatInterval = 15 * time.Second
+	}
+	for _, client := range clients {
+		client := client
+		b.removes = append(b.removes, client.AddListener(func(message *comfyUIclient.WSMessage) { b.handle(client, message) }))
+	}
+	return b
+}
+
+// Close stops listening to the clients, open streams stay open until their requests end
+func (b *Bridge) Close() {
+	for _, remove := range b.removes {
+		remove()
+	}
+}
+
+func (b *Bridge) handle(client *comfyUIclient.Client, message *comfyUIclient.WSMessage) {
+	if message.Type == comfyUIclient.Binary || (message.Type == comfyUIclient.PreviewImage && b.opts.PreviewMode == PreviewNone) {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	promptID := message.PromptID()
+	switch data := message.Data.(type) {
+	case *comfyUIclient.WSMessageDataExecutionStart:
+		b.running[client] = data.PromptID
+	case *comfyUIclient.WSMessageDataExecuting:
+		if data.Node != "" {
+			b.running[client] = data.PromptID
+		}
+	case *comfyUIclient.WSMessageDataCompleted:
+		if b.running[client] == data.PromptID {
+			delete(b.running, client)
+		}
+	}
+	// old servers send progress and previews without prompt id, they belong to the running prompt
+	if promptID == "" && (message.Type == comfyUIclient.Progress || message.Type == comfyUIclient.PreviewImage || message.Type == comfyUIclient.ProgressText) {
+		promptID = b.running[client]
+	}
+
+	e := &event{promptID: promptID, name: string(message.Type)}
+	if preview, ok := message.Data.(*comfyUIclient.WSMessageDataPreviewImage); ok {
+		e.preview = preview
+	} else {
+		data, err := payload(message)
+		if err != nil {
+			return
+		}
+		e.data = data
+	}
+	b.add(e)
+
+	if message.Type == comfyUIclient.Completed && promptID != "" {
+		b.completed[promptID] = e.id
+		b.completedOrder = append(b.completedOrder, promptID)
+		if len(b.completedOrder) > completedSize {
+			delete(b.completed, b.completedOrder[0])
+			b.completedOrder = b.completedOrder[1:]
+		}
+	}
+}
+
+// add buffers e and wakes up the streams, b.mu must be held
+func (b *Bridge) add(e *event) {
+	b.nextID++
+	e.id = b.nextID
+	b.events = append(b.events, e)
+	if len(b.events) > b.opts.BufferSize {
+		b.events = b.events[len(b.events)-b.opts.BufferSize:]
+	}
+	if e.preview != nil {
+		b.previews = append(b.previews, e)
+		if len(b.previews) > b.opts.PreviewBufferSize {
+			b.previews[0].preview = nil
+			b.previews = b.previews[1:]
+		}
+	}
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// payload returns the json data of message, the undecoded data when the server sent it
+func payload(message *comfyUIclient.WSMessage) ([]byte, error) {
+	if len(message.Raw) != 0 {
+		return message.Raw, nil
+	}
+	switch data := message.Data.(type) {
+	case *comfyUIclient.WSMessageDataExecuted:
+		return json.Marshal(map[string]interface{}{"node": data.Node, "prompt_id": data.PromptID, "output": data.Output})
+	case *comfyUIclient.WSMessageDataQueued:
+		return json.Marshal(map[string]interface{}{"prompt_id": data.PromptID, "number": data.Number, "queued_at": data.QueuedAt})
+	case *comfyUIclient.WSMessageDataProgressText:
+		return json.Marshal(map[string]interface{}{"node_id": data.NodeID, "text": data.Text})
+	case *comfyUIclient.WSMessageDataCompleted:
+		completed := map[string]interface{}{
+			"prompt_id":      data.PromptID,
+			"status":         data.Status,
+			"outputs":        data.Outputs,
+			"executed_nodes": data.ExecutedNodes,
+			"cached_nodes":   data.CachedNodes,
+			"ended_at":       data.EndedAt,
+		}
+		if !data.StartedAt.IsZero() {
+			completed["started_at"] = data.StartedAt
+		}
+		if data.Error != nil {
+			completed["error"] = data.Error
+		}
+		if data.Interrupted != nil {
+			completed["interrupted"] = data.Interrupted
+		}
+		return json.Marshal(completed)
+	}
+	return json.Marshal(message.Data)
+}
+
+// ServeHTTP authorizes the request with Options.Authorize and serves it, see Serve
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var filter Filter
+	if b.opts.Authorize != nil {
+		var err error
+		if filter, err = b.opts.Authorize(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	b.Serve(w, r, filter)
+}
+
+// Serve streams the events filter allows, or serves a preview image for ?preview=<event id>
+// Events without prompt id, e.g. of custom nodes, are only sent when filter is nil, status events always are
+func (b *Bridge) Serve(w http.ResponseWriter, r *http.Request, filter Filter) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	if query.Has("preview") {
+		b.servePreview(w, query.Get("preview"), filter)
+		return
+	}
+
+	promptID := query.Get("prompt_id")
+	if promptID != "" && filter != nil && !filter(promptID) {
+		http.Error(w, "prompt not found", http.StatusNotFound)
+		return
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = query.Get("last_event_id")
+	}
+	last, _ := strconv.ParseUint(lastID, 10, 64)
+
+	// a reconnecting browser would wait forever for a prompt that finished, 204 stops it from reconnecting
+	b.mu.Lock()
+	completedID, completed := b.completed[promptID]
+	b.mu.Unlock()
+	if promptID != "" && completed && completedID <= last {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	s := &stream{promptID: promptID, filter: filter, previewMode: b.opts.PreviewMode, path: r.URL.Path}
+	heartbeat := time.NewTicker(b.opts.HeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		events, changed := b.eventsAfter(last)
+		for _, e := range events {
+			last = e.id
+			if !s.allows(e) {
+				continue
+			}
+			data, ok := s.data(e)
+			if !ok {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.id, e.name, data); err != nil {
+				return
+			}
+			if promptID != "" && e.name == string(comfyUIclient.Completed) {
+				flusher.Flush()
+				return
+			}
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// eventsAfter returns the buffered events after id, and a channel that is closed on the next event
+func (b *Bridge) eventsAfter(id uint64) ([]*event, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := sort.Search(len(b.events), func(i int) bool { return b.events[i].id > id })
+	events := make([]*event, len(b.events)-i)
+	for j, e := range b.events[i:] {
+		copied := *e
+		events[j] = &copied
+	}
+	return events, b.changed
+}
+
+func (b *Bridge) servePreview(w http.ResponseWriter, rawID string, filter Filter) {
+	id, err := strconv.ParseUint(rawID, 10, 64)
+	if err != nil {
+		http.Error(w, "preview not found", http.StatusNotFound)
+		return
+	}
+	var preview *comfyUIclient.WSMessageDataPreviewImage
+	var promptID string
+	b.mu.Lock()
+	for _, e := range b.previews {
+		if e.id == id {
+			preview, promptID = e.preview, e.promptID
+		}
+	}
+	b.mu.Unlock()
+	if preview == nil || (filter != nil && !filter(promptID)) {
+		http.Error(w, "preview not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", preview.Format)
+	w.Header().Set("Content-Length", strconv.Itoa(len(preview.Image)))
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	_, _ = w.Write(preview.Image)
+}
+
+// stream is the selection of one request
+type stream struct {
+	promptID    string
+	filter      Filter
+	previewMode PreviewMode
+	path        string
+}
+
+func (s *stream) allows(e *event) bool {
+	switch {
+	case s.promptID != "":
+		return e.promptID == s.promptID
+	case e.promptID == "":
+		return s.filter == nil || e.name == string(comfyUIclient.Status)
+	}
+	return s.filter == nil || s.filter(e.promptID)
+}
+
+// data returns the event data, previews whose image was dropped are skipped
+func (s *stream) data(e *event) ([]byte, bool) {
+	if e.data != nil {
+		return e.data, true
+	}
+	if e.preview == nil {
+		return nil, false
+	}
+	preview := map[string]interface{}{
+		"prompt_id": e.promptID,
+		"node_id":   e.preview.NodeID,
+		"format":    e.preview.Format,
+	}
+	if s.previewMode == PreviewURL {
+		preview["url"] = s.path + "?preview=" + strconv.FormatUint(e.id, 10)
+	} else {
+		preview["image"] = base64.StdEncoding.EncodeToString(e.preview.Image)
+	}
+	data, err := json.Marshal(preview)
+	return data, err == nil
+}
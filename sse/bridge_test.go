@@ -0,0 +1,230 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+func executing(promptID, node string) *comfyUIclient.WSMessage {
+	return &comfyUIclient.WSMessage{
+		Type: comfyUIclient.Executing,
+		Data: &comfyUIclient.WSMessageDataExecuting{PromptID: promptID, Node: node},
+		Raw:  []byte(`{"prompt_id":"` + promptID + `","node":"` + node + `"}`),
+	}
+}
+
+func completed(promptID string) *comfyUIclient.WSMessage {
+	return &comfyUIclient.WSMessage{
+		Type: comfyUIclient.Completed,
+		Data: &comfyUIclient.WSMessageDataCompleted{PromptID: promptID, Status: comfyUIclient.ExecutionSuccess},
+	}
+}
+
+// sent is an event as read from a stream
+type sent struct {
+	id, name, data string
+}
+
+// open starts a stream of b, the handler is done when the returned channel is closed
+func open(t *testing.T, b *Bridge, query string, header http.Header) (*http.Response, *bufio.Reader, context.CancelFunc, <-chan struct{}) {
+	t.Helper()
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		b.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events"+query, nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest: %v", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /events%s: %v", query, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp, bufio.NewReader(resp.Body), cancel, done
+}
+
+// next reads the next event of a stream, skipping comments
+func next(t *testing.T, r *bufio.Reader) sent {
+	t.Helper()
+	lines := make(chan []string, 1)
+	go func() {
+		var event []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				lines <- nil
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "" && len(event) != 0:
+				lines <- event
+				return
+			case line == "", strings.HasPrefix(line, ":"):
+			default:
+				event = append(event, line)
+			}
+		}
+	}()
+	select {
+	case event := <-lines:
+		if len(event) != 3 {
+			t.Fatalf("event lines = %q, want id, event and data", event)
+		}
+		return sent{
+			id:   strings.TrimPrefix(event[0], "id: "),
+			name: strings.TrimPrefix(event[1], "event: "),
+			data: strings.TrimPrefix(event[2], "data: "),
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return sent{}
+	}
+}
+
+func TestEventFraming(t *testing.T) {
+	b := New(nil)
+	b.handle(nil, &comfyUIclient.WSMessage{Type: comfyUIclient.Status, Raw: []byte(`{"status":{"exec_info":{"queue_remaining":1}}}`)})
+
+	resp, r, _, _ := open(t, b, "", nil)
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", got)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "no-cache" {
+		t.Fatalf("Cache-Control = %q, want no-cache", got)
+	}
+	if got, want := next(t, r), (sent{id: "1", name: "status", data: `{"status":{"exec_info":{"queue_remaining":1}}}`}); got != want {
+		t.Fatalf("event = %+v, want %+v", got, want)
+	}
+
+	// events without raw data are encoded by the bridge
+	b.handle(nil, completed("a"))
+	got := next(t, r)
+	if got.id != "2" || got.name != "completed" || !strings.Contains(got.data, `"prompt_id":"a"`) || !strings.Contains(got.data, `"status":"execution_success"`) {
+		t.Fatalf("event = %+v, want the completed prompt a", got)
+	}
+}
+
+func TestStreamEndsOnDisconnect(t *testing.T) {
+	b := New(&Options{HeartbeatInterval: time.Hour})
+	_, r, cancel, done := open(t, b, "", nil)
+
+	// the event is flushed right away, the stream isn't buffered until it ends
+	b.handle(nil, executing("a", "1"))
+	if got := next(t, r); got.name != "executing" || got.id != "1" {
+		t.Fatalf("event = %+v, want executing", got)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream still served after the client disconnected")
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	b := New(&Options{HeartbeatInterval: 10 * time.Millisecond})
+	_, r, _, _ := open(t, b, "", nil)
+	line, err := r.ReadString('\n')
+	if err != nil || line != ": heartbeat\n" {
+		t.Fatalf("read %q, %v, want a heartbeat comment", line, err)
+	}
+}
+
+func TestLastEventID(t *testing.T) {
+	b := New(nil)
+	b.handle(nil, executing("a", "1"))
+	b.handle(nil, executing("b", "1"))
+	b.handle(nil, executing("a", "2"))
+	b.handle(nil, completed("a"))
+
+	tests := []struct {
+		name   string
+		query  string
+		header http.Header
+		want   []string
+	}{
+		{name: "header", header: http.Header{"Last-Event-Id": {"1"}}, want: []string{"2", "3", "4"}},
+		{name: "query", query: "?last_event_id=2", want: []string{"3", "4"}},
+		{name: "prompt", query: "?prompt_id=a", want: []string{"1", "3", "4"}},
+		{name: "prompt after id", query: "?prompt_id=a", header: http.Header{"Last-Event-Id": {"1"}}, want: []string{"3", "4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, r, _, _ := open(t, b, tt.query, tt.header)
+			for _, id := range tt.want {
+				if got := next(t, r); got.id != id {
+					t.Fatalf("event id = %s, want %s", got.id, id)
+				}
+			}
+		})
+	}
+
+	// a stream of a prompt ends after its completed event
+	_, r, _, done := open(t, b, "?prompt_id=a", nil)
+	for i := 0; i < 3; i++ {
+		next(t, r)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream of a completed prompt didn't end")
+	}
+
+	// a client that already saw the completed event isn't sent anything
+	resp, _, _, _ := open(t, b, "?prompt_id=a", http.Header{"Last-Event-Id": {"4"}})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+}
+
+func TestBufferSize(t *testing.T) {
+	b := New(&Options{BufferSize: 2})
+	for _, node := range []string{"1", "2", "3"} {
+		b.handle(nil, executing("a", node))
+	}
+	// the oldest event was dropped, the stream starts with the buffered ones
+	_, r, _, _ := open(t, b, "", nil)
+	for _, id := range []string{"2", "3"} {
+		if got := next(t, r); got.id != id {
+			t.Fatalf("event id = %s, want %s", got.id, id)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	b := New(&Options{Authorize: func(r *http.Request) (Filter, error) {
+		return func(promptID string) bool { return promptID == "mine" }, nil
+	}})
+	b.handle(nil, executing("other", "1"))
+	b.handle(nil, &comfyUIclient.WSMessage{Type: comfyUIclient.Status, Raw: []byte(`{}`)})
+	b.handle(nil, executing("mine", "1"))
+
+	resp, _, _, _ := open(t, b, "?prompt_id=other", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("stream of another prompt = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+	_, r, _, _ := open(t, b, "", nil)
+	for _, want := range []sent{{id: "2", name: "status", data: "{}"}, {id: "3", name: "executing", data: `{"prompt_id":"mine","node":"1"}`}} {
+		if got := next(t, r); got != want {
+			t.Fatalf("event = %+v, want %+v", got, want)
+		}
+	}
+}
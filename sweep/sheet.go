@@ -0,0 +1,157 @@
+package sweep
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	textScale = 2
+	padding   = 8
+)
+
+var (
+	backgroundColor = color.White
+	textColor       = color.Black
+	errorColor      = color.RGBA{R: 0xC0, A: 0xFF}
+	emptyColor      = color.Gray{Y: 0xE0}
+)
+
+// drawSheet lays cells out with the values of the first axis as columns and the other axes as rows
+func drawSheet(axes []Axis, cells []*Cell, thumbnails []image.Image, cellSize int) *image.RGBA {
+	columns := len(axes[0].Values)
+	rows := (len(cells) + columns - 1) / columns
+	lineHeight := glyphHeight*textScale + padding/2
+
+	columnLabels := make([]string, columns)
+	for i, value := range axes[0].Values {
+		columnLabels[i] = axes[0].label() + "=" + formatValue(value)
+	}
+	rowLabels := make([][]string, rows)
+	rowLabelWidth := 0
+	for row := range rowLabels {
+		cell := cells[row*columns]
+		for i := 1; i < len(axes); i++ {
+			line := axes[i].label() + "=" + formatValue(axes[i].Values[cell.Coordinates[i]])
+			rowLabels[row] = append(rowLabels[row], line)
+			if width := textWidth(line, textScale); width > rowLabelWidth {
+				rowLabelWidth = width
+			}
+		}
+	}
+	if maxWidth := 2 * cellSize; rowLabelWidth > maxWidth {
+		rowLabelWidth = maxWidth
+	}
+	if rowLabelWidth > 0 {
+		rowLabelWidth += 2 * padding
+	}
+
+	headerHeight := lineHeight + padding
+	rowHeight := cellSize + padding
+	if height := len(axes[1:])*lineHeight + padding; height > rowHeight {
+		rowHeight = height
+	}
+	width := rowLabelWidth + columns*(cellSize+padding) + padding
+	height := headerHeight + rows*rowHeight + padding
+
+	sheet := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(backgroundColor), image.Point{}, draw.Src)
+
+	for column, label := range columnLabels {
+		x := rowLabelWidth + padding + column*(cellSize+padding)
+		drawText(sheet, x, padding, truncateText(label, cellSize, textScale), textScale, textColor)
+	}
+
+	for row := 0; row < rows; row++ {
+		y := headerHeight + row*rowHeight
+		for line, label := range rowLabels[row] {
+			drawText(sheet, padding, y+line*lineHeight, truncateText(label, rowLabelWidth-2*padding, textScale), textScale, textColor)
+		}
+
+		for column := 0; column < columns; column++ {
+			index := row*columns + column
+			if index >= len(cells) {
+				break
+			}
+			x := rowLabelWidth + padding + column*(cellSize+padding)
+			area := image.Rect(x, y, x+cellSize, y+cellSize)
+			thumbnail := thumbnails[index]
+			if thumbnail == nil {
+				draw.Draw(sheet, area, image.NewUniform(emptyColor), image.Point{}, draw.Src)
+				message := "no output"
+				if cells[index].Error != "" {
+					message = "error"
+				}
+				drawText(sheet, x+padding, y+padding, message, textScale, errorColor)
+				continue
+			}
+			bounds := thumbnail.Bounds()
+			offset := image.Pt(x+(cellSize-bounds.Dx())/2, y+(cellSize-bounds.Dy())/2)
+			draw.Draw(sheet, bounds.Sub(bounds.Min).Add(offset), thumbnail, bounds.Min, draw.Src)
+		}
+	}
+	return sheet
+}
+
+// fit scales img down to fit into a size x size square by averaging the source pixels of every target pixel
+func fit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return img
+	}
+	targetWidth, targetHeight := size, size
+	if width > height {
+		targetHeight = max(1, height*size/width)
+	} else {
+		targetWidth = max(1, width*size/height)
+	}
+
+	scaled := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	for y := 0; y < targetHeight; y++ {
+		y0 := bounds.Min.Y + y*height/targetHeight
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*height/targetHeight)
+		for x := 0; x < targetWidth; x++ {
+			x0 := bounds.Min.X + x*width/targetWidth
+			x1 := max(x0+1, bounds.Min.X+(x+1)*width/targetWidth)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			scaled.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return scaled
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return v
+	}
+	return strings.TrimSpace(fmt.Sprint(value))
+}
+
+func writePNG(name string, img image.Image) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("os.Create: error: %w", err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return fmt.Errorf("png.Encode: error: %w", err)
+	}
+	return f.Close()
+}
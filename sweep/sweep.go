@@ -0,0 +1,274 @@
+// Package sweep runs parameter sweeps over a prompt and assembles the results into a contact sheet
+package sweep
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+// Axis varies one input of one node
+type Axis struct {
+	// Label names the axis on the sheet and in the manifest, "NodeID.Input" when empty
+	Label  string        `json:"label"`
+	NodeID string        `json:"node_id"`
+	Input  string        `json:"input"`
+	Values []interface{} `json:"values"`
+}
+
+func (a *Axis) label() string {
+	if a.Label != "" {
+		return a.Label
+	}
+	return a.NodeID + "." + a.Input
+}
+
+// Cell is one combination of axis values
+type Cell struct {
+	Index int `json:"index"`
+	// Column is the value index of the first axis, Row enumerates the combinations of the other axes
+	Column int `json:"column"`
+	Row    int `json:"row"`
+	// Coordinates holds the value index of every axis
+	Coordinates []int `json:"coordinates"`
+	// Params maps axis labels to values
+	Params   map[string]interface{}        `json:"params"`
+	PromptID string                        `json:"prompt_id,omitempty"`
+	Output   *comfyUIclient.DataOutputFile `json:"output,omitempty"`
+	// File is the downloaded output relative to the output directory
+	File   string               `json:"file,omitempty"`
+	Error  string               `json:"error,omitempty"`
+	Prompt comfyUIclient.Prompt `json:"-"`
+}
+
+// Generate returns the cartesian product of axes applied to base, the first axis varies fastest
+func Generate(base comfyUIclient.Prompt, axes []Axis) ([]*Cell, error) {
+	if len(axes) == 0 {
+		return nil, errors.New("axes is empty")
+	}
+	labels := make(map[string]bool, len(axes))
+	total := 1
+	for i := range axes {
+		axis := &axes[i]
+		if _, ok := base[axis.NodeID]; !ok {
+			return nil, fmt.Errorf("axis %s: node %s not found in prompt", axis.label(), axis.NodeID)
+		}
+		if len(axis.Values) == 0 {
+			return nil, fmt.Errorf("axis %s: values is empty", axis.label())
+		}
+		if labels[axis.label()] {
+			return nil, fmt.Errorf("axis %s: duplicate label", axis.label())
+		}
+		labels[axis.label()] = true
+		total *= len(axis.Values)
+	}
+
+	columns := len(axes[0].Values)
+	cells := make([]*Cell, 0, total)
+	for index := 0; index < total; index++ {
+		cell := &Cell{
+			Index:       index,
+			Column:      index % columns,
+			Row:         index / columns,
+			Coordinates: make([]int, len(axes)),
+			Params:      make(map[string]interface{}, len(axes)),
+			Prompt:      copyPrompt(base),
+		}
+		rest := index
+		for i := range axes {
+			axis := &axes[i]
+			coordinate := rest % len(axis.Values)
+			rest /= len(axis.Values)
+			cell.Coordinates[i] = coordinate
+			cell.Params[axis.label()] = axis.Values[coordinate]
+			cell.Prompt[axis.NodeID].Inputs[axis.Input] = axis.Values[coordinate]
+		}
+		cells = append(cells, cell)
+	}
+	return cells, nil
+}
+
+// copyPrompt copies prompt deep enough to set inputs without touching prompt
+func copyPrompt(prompt comfyUIclient.Prompt) comfyUIclient.Prompt {
+	copied := make(comfyUIclient.Prompt, len(prompt))
+	for id, node := range prompt {
+		inputs := make(map[string]interface{}, len(node.Inputs)+1)
+		for name, value := range node.Inputs {
+			inputs[name] = value
+		}
+		node.Inputs = inputs
+		copied[id] = node
+	}
+	return copied
+}
+
+// Options controls Run
+type Options struct {
+	// OutputDir receives the downloaded outputs, the sheet and the manifest, it is created if missing
+	OutputDir string
+	// OutputNode selects the node whose first image is used, the first output node with images when empty
+	OutputNode string
+	// CellSize is the size in pixels of a cell on the sheet, 256 when not positive
+	CellSize int
+	// Batch controls how the prompts are queued
+	Batch *comfyUIclient.BatchOptions
+}
+
+// Result is the outcome of Run
+type Result struct {
+	Cells    []*Cell
+	Sheet    string
+	Manifest string
+	Summary  *comfyUIclient.BatchSummary
+}
+
+// Manifest is written as manifest.json next to the sheet
+type Manifest struct {
+	Axes    []Axis                      `json:"axes"`
+	Sheet   string                      `json:"sheet"`
+	Cells   []*Cell                     `json:"cells"`
+	Summary *comfyUIclient.BatchSummary `json:"summary"`
+}
+
+// Run generates the prompts of the sweep, runs them with client and writes sheet.png and manifest.json to OutputDir
+// Failed cells are marked on the sheet and in the manifest, the error reports failures of the sweep itself
+// When the batch fails, e.g. ctx is done or a prompt failed without ContinueOnError,
+// the partial sheet and manifest are still written and returned along with the error
+func Run(ctx context.Context, client *comfyUIclient.Client, base comfyUIclient.Prompt, axes []Axis, opts *Options) (*Result, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	cellSize := opts.CellSize
+	if cellSize <= 0 {
+		cellSize = 256
+	}
+	outputDir := opts.OutputDir
+	if outputDir == "" {
+		outputDir = "."
+	}
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll: error: %w", err)
+	}
+
+	cells, err := Generate(base, axes)
+	if err != nil {
+		return nil, fmt.Errorf("Generate: error: %w", err)
+	}
+	prompts := make([]comfyUIclient.Prompt, len(cells))
+	for i, cell := range cells {
+		prompts[i] = cell.Prompt
+	}
+
+	batchOptions := comfyUIclient.BatchOptions{ContinueOnError: true}
+	if opts.Batch != nil {
+		batchOptions = *opts.Batch
+	}
+	batch := client.SubmitBatch(ctx, prompts, &batchOptions)
+
+	thumbnails := make([]image.Image, len(cells))
+	for result := range batch.Results() {
+		cell := cells[result.Index]
+		cell.PromptID = result.PromptID
+		if result.Err != nil {
+			cell.Error = result.Err.Error()
+			continue
+		}
+		thumbnail, err := download(client, cell, result.Completed, outputDir, opts.OutputNode, cellSize)
+		if err != nil {
+			cell.Error = err.Error()
+			continue
+		}
+		thumbnails[result.Index] = thumbnail
+	}
+	summary, waitErr := batch.Wait()
+	for _, cell := range cells {
+		if cell.PromptID == "" && cell.Error == "" {
+			cell.Error = "not run"
+		}
+	}
+
+	result := &Result{
+		Cells:    cells,
+		Sheet:    filepath.Join(outputDir, "sheet.png"),
+		Manifest: filepath.Join(outputDir, "manifest.json"),
+		Summary:  summary,
+	}
+	sheet := drawSheet(axes, cells, thumbnails, cellSize)
+	if err := writePNG(result.Sheet, sheet); err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{Axes: axes, Sheet: "sheet.png", Cells: cells, Summary: summary}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("json.MarshalIndent: error: %w", err)
+	}
+	if err := os.WriteFile(result.Manifest, data, 0o644); err != nil {
+		return nil, fmt.Errorf("os.WriteFile: error: %w", err)
+	}
+	if waitErr != nil {
+		return result, fmt.Errorf("batch.Wait: error: %w", waitErr)
+	}
+	return result, nil
+}
+
+// download saves the output image of cell and returns its thumbnail
+func download(client *comfyUIclient.Client, cell *Cell, completed *comfyUIclient.WSMessageDataCompleted, outputDir, outputNode string, cellSize int) (image.Image, error) {
+	output := pickOutput(completed, outputNode)
+	if output == nil {
+		return nil, errors.New("no image output")
+	}
+	cell.Output = output
+
+	data, err := client.GetFile(output)
+	if err != nil {
+		return nil, fmt.Errorf("client.GetFile: error: %w", err)
+	}
+	cell.File = fmt.Sprintf("cell_%04d%s", cell.Index, strings.ToLower(path.Ext(output.Filename)))
+	if err := os.WriteFile(filepath.Join(outputDir, cell.File), *data, 0o644); err != nil {
+		return nil, fmt.Errorf("os.WriteFile: error: %w", err)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(*data))
+	if err != nil {
+		return nil, fmt.Errorf("image.Decode: error: %w", err)
+	}
+	return fit(img, cellSize), nil
+}
+
+func pickOutput(completed *comfyUIclient.WSMessageDataCompleted, outputNode string) *comfyUIclient.DataOutputFile {
+	if completed == nil {
+		return nil
+	}
+	if outputNode != "" {
+		if images := completed.Outputs[outputNode]["images"]; len(images) != 0 {
+			return images[0]
+		}
+		return nil
+	}
+
+	nodes := make([]string, 0, len(completed.Outputs))
+	for node := range completed.Outputs {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		if images := completed.Outputs[node]["images"]; len(images) != 0 {
+			return images[0]
+		}
+	}
+	return nil
+}
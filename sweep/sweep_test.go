@@ -0,0 +1,236 @@
+package sweep
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/XdpCs/comfyUIclient/comfytest"
+)
+
+func basePrompt() comfyUIclient.Prompt {
+	return comfyUIclient.Prompt{
+		"1": {ClassType: "EmptyLatentImage", Inputs: map[string]interface{}{"width": 64, "height": 64, "batch_size": 1}},
+		"2": {ClassType: "SaveImage", Inputs: map[string]interface{}{"images": []interface{}{"1", 0}, "filename_prefix": "sweep"}},
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	base := basePrompt()
+	axes := []Axis{
+		{Label: "width", NodeID: "1", Input: "width", Values: []interface{}{64, 128, 256}},
+		{NodeID: "1", Input: "height", Values: []interface{}{32, 48}},
+	}
+	cells, err := Generate(base, axes)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if len(cells) != 6 {
+		t.Fatalf("%d cells, want 6", len(cells))
+	}
+
+	tests := []struct {
+		index       int
+		column, row int
+		coordinates []int
+		params      map[string]interface{}
+	}{
+		{index: 0, column: 0, row: 0, coordinates: []int{0, 0}, params: map[string]interface{}{"width": 64, "1.height": 32}},
+		{index: 1, column: 1, row: 0, coordinates: []int{1, 0}, params: map[string]interface{}{"width": 128, "1.height": 32}},
+		{index: 2, column: 2, row: 0, coordinates: []int{2, 0}, params: map[string]interface{}{"width": 256, "1.height": 32}},
+		{index: 4, column: 1, row: 1, coordinates: []int{1, 1}, params: map[string]interface{}{"width": 128, "1.height": 48}},
+	}
+	for _, tt := range tests {
+		cell := cells[tt.index]
+		if cell.Index != tt.index || cell.Column != tt.column || cell.Row != tt.row || !reflect.DeepEqual(cell.Coordinates, tt.coordinates) {
+			t.Errorf("cell %d: index %d, column %d, row %d, coordinates %v", tt.index, cell.Index, cell.Column, cell.Row, cell.Coordinates)
+		}
+		if !reflect.DeepEqual(cell.Params, tt.params) {
+			t.Errorf("cell %d: params = %v, want %v", tt.index, cell.Params, tt.params)
+		}
+		inputs := cell.Prompt["1"].Inputs
+		if inputs["width"] != tt.params["width"] || inputs["height"] != tt.params["1.height"] {
+			t.Errorf("cell %d: inputs = %v", tt.index, inputs)
+		}
+	}
+	if base["1"].Inputs["width"] != 64 || base["1"].Inputs["height"] != 64 {
+		t.Fatalf("base prompt was changed: %v", base["1"].Inputs)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		axes []Axis
+	}{
+		{name: "no axes"},
+		{name: "missing node", axes: []Axis{{NodeID: "9", Input: "seed", Values: []interface{}{1}}}},
+		{name: "no values", axes: []Axis{{NodeID: "1", Input: "width"}}},
+		{name: "duplicate label", axes: []Axis{
+			{NodeID: "1", Input: "width", Values: []interface{}{1}},
+			{NodeID: "1", Input: "width", Values: []interface{}{2}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Generate(basePrompt(), tt.axes); err == nil {
+				t.Fatal("Generate succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestSheetLayout(t *testing.T) {
+	const cellSize = 32
+	axes := []Axis{
+		{Label: "a", NodeID: "1", Input: "width", Values: []interface{}{1, 2}},
+		{Label: "b", NodeID: "1", Input: "height", Values: []interface{}{1, 2}},
+	}
+	cells, err := Generate(basePrompt(), axes)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	red := color.RGBA{R: 0xFF, A: 0xFF}
+	blue := color.RGBA{B: 0xFF, A: 0xFF}
+	thumbnails := []image.Image{solid(red, cellSize, cellSize), solid(blue, cellSize, cellSize/2), nil, solid(red, 8, 8)}
+	cells[2].Error = "failed"
+
+	sheet := drawSheet(axes, cells, thumbnails, cellSize)
+
+	rowLabelWidth := textWidth("b=1", textScale) + 2*padding
+	headerHeight := glyphHeight*textScale + padding/2 + padding
+	rowHeight := cellSize + padding
+	if got, want := sheet.Bounds().Size(), image.Pt(rowLabelWidth+2*(cellSize+padding)+padding, headerHeight+2*rowHeight+padding); got != want {
+		t.Fatalf("sheet size = %v, want %v", got, want)
+	}
+	center := func(column, row int) color.Color {
+		return sheet.At(rowLabelWidth+padding+column*(cellSize+padding)+cellSize/2, headerHeight+row*rowHeight+cellSize/2)
+	}
+	tests := []struct {
+		name        string
+		column, row int
+		want        color.Color
+	}{
+		{name: "thumbnail", column: 0, row: 0, want: red},
+		{name: "centered thumbnail", column: 1, row: 0, want: blue},
+		{name: "small thumbnail", column: 1, row: 1, want: red},
+	}
+	for _, tt := range tests {
+		if !sameColor(center(tt.column, tt.row), tt.want) {
+			t.Errorf("%s: center of column %d row %d is %v, want %v", tt.name, tt.column, tt.row, center(tt.column, tt.row), tt.want)
+		}
+	}
+	// a failed cell is filled and labeled at its top left
+	if corner := sheet.At(rowLabelWidth+padding+cellSize-2, headerHeight+rowHeight+cellSize-2); !sameColor(corner, emptyColor) {
+		t.Errorf("corner of the failed cell is %v, want %v", corner, emptyColor)
+	}
+	// the half height thumbnail leaves the top of its cell empty
+	if top := sheet.At(rowLabelWidth+padding+cellSize+padding+cellSize/2, headerHeight+1); !sameColor(top, backgroundColor) {
+		t.Errorf("top of column 1 row 0 is %v, want the background", top)
+	}
+}
+
+func solid(c color.Color, width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func sameColor(a, b color.Color) bool {
+	ar, ag, ab, aa := a.RGBA()
+	br, bg, bb, ba := b.RGBA()
+	return ar == br && ag == bg && ab == bb && aa == ba
+}
+
+func newClient(t *testing.T, server *comfytest.Server) *comfyUIclient.Client {
+	t.Helper()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("server.Client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestRun(t *testing.T) {
+	server := comfytest.NewServer()
+	defer server.Close()
+	client := newClient(t, server)
+	axes := []Axis{
+		{NodeID: "1", Input: "width", Values: []interface{}{64, 128}},
+		{NodeID: "2", Input: "filename_prefix", Values: []interface{}{"a", "b"}},
+	}
+	dir := t.TempDir()
+
+	result, err := Run(context.Background(), client, basePrompt(), axes, &Options{OutputDir: dir, CellSize: 32})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	for _, cell := range result.Cells {
+		if cell.Error != "" || cell.File == "" {
+			t.Fatalf("cell %d: error %q, file %q", cell.Index, cell.Error, cell.File)
+		}
+		if _, err := os.Stat(filepath.Join(dir, cell.File)); err != nil {
+			t.Fatalf("cell %d: %v", cell.Index, err)
+		}
+	}
+	f, err := os.Open(result.Sheet)
+	if err != nil {
+		t.Fatalf("os.Open: %v", err)
+	}
+	defer f.Close()
+	if _, err := png.Decode(f); err != nil {
+		t.Fatalf("png.Decode: %v", err)
+	}
+}
+
+func TestRunFailsFast(t *testing.T) {
+	server := comfytest.NewServer()
+	defer server.Close()
+	client := newClient(t, server)
+	server.Script(&comfytest.Behavior{ErrorAt: "1"})
+	axes := []Axis{{NodeID: "1", Input: "width", Values: []interface{}{64, 128, 256}}}
+	dir := t.TempDir()
+
+	result, err := Run(context.Background(), client, basePrompt(), axes, &Options{
+		OutputDir: dir,
+		CellSize:  32,
+		Batch:     &comfyUIclient.BatchOptions{Concurrency: 1},
+	})
+	if err == nil {
+		t.Fatal("Run succeeded, want the error of the failed prompt")
+	}
+	if result == nil || result.Cells[0].Error == "" {
+		t.Fatalf("result = %+v, want the failed cell", result)
+	}
+	if result.Summary.Succeeded != 0 || result.Summary.Skipped == 0 {
+		t.Fatalf("summary = %+v, want skipped prompts", result.Summary)
+	}
+	if _, err := os.Stat(result.Manifest); err != nil {
+		t.Fatalf("partial manifest: %v", err)
+	}
+}
+
+func TestRunWithoutListening(t *testing.T) {
+	server := comfytest.NewServer()
+	defer server.Close()
+	client, err := comfyUIclient.New(server.URL)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	axes := []Axis{{NodeID: "1", Input: "width", Values: []interface{}{64}}}
+
+	if _, err := Run(context.Background(), client, basePrompt(), axes, &Options{OutputDir: t.TempDir()}); err == nil {
+		t.Fatal("Run succeeded, want the error of the batch")
+	}
+}
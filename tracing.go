@@ -0,0 +1,303 @@
+package comfyUIclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracer creates the spans of http requests, prompts and nodes, see WithTracer
+// It follows the OpenTelemetry tracing api, so an adapter only maps Start, Span and Attribute
+//
+// Every api call gets a span named after its method and route, e.g. "GET /history", as a child of the span in the ctx of the call.
+// A prompt gets a "comfyui.prompt" span from QueuePrompt through completion, with a "comfyui.queued" child until execution started
+// and a "comfyui.node" child per executed or cached node, built from the times the client received executing and executed.
+// Prompts queued for another client id only get the prompt span up to queueing, their events never reach this client.
+type Tracer interface {
+	// Start starts a span at start as a child of the span in ctx and returns a context holding the new span
+	Start(ctx context.Context, name string, start time.Time, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	// SetError marks the span as failed
+	SetError(err error)
+	End(end time.Time)
+}
+
+// TracePropagator is implemented by tracers that send the trace context of ctx to ComfyUI in request headers, e.g. traceparent
+type TracePropagator interface {
+	Inject(ctx context.Context, header http.Header)
+}
+
+// Attribute is a key value pair of a span, Value is a string, bool, int, int64, float64 or []string
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Attr returns an Attribute
+func Attr(key string, value interface{}) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span attribute keys set by the client
+const (
+	AttrHTTPMethod     = "http.request.method"
+	AttrHTTPRoute      = "http.route"
+	AttrHTTPStatusCode = "http.response.status_code"
+	AttrHTTPAttempts   = "comfyui.http.attempts"
+	AttrPromptID       = "comfyui.prompt_id"
+	AttrClientID       = "comfyui.client_id"
+	AttrPromptNumber   = "comfyui.prompt_number"
+	AttrPromptStatus   = "comfyui.prompt_status"
+	AttrNodeID         = "comfyui.node_id"
+	AttrClassType      = "comfyui.class_type"
+	AttrCached         = "comfyui.cached"
+	AttrErrorType      = "error.type"
+)
+
+// WithTracer sets the Tracer of the client, nothing is traced by default or when tracer is nil
+func WithTracer(tracer Tracer) Option {
+	return func(o *clientOptions) {
+		if tracer == nil {
+			tracer = noopTracer{}
+		}
+		o.tracer = tracer
+	}
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string, start time.Time, attrs ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(attrs ...Attribute) {}
+func (noopSpan) SetError(err error)               {}
+func (noopSpan) End(end time.Time)                {}
+
+// routers are the routes of span names, longer routes first so /view_metadata isn't taken for /view
+var routers = []Router{
+	ViewMetadataRouter, SystemStatsRouter, UploadImageRouter, UploadMaskRouter, ObjectInfoRouter,
+	EmbeddingsRouter, ExtensionsRouter, InterruptRouter, HistoryRouter, PromptRouter, ModelsRouter, QueueRouter, ViewRouter,
+}
+
+// routeOf strips path parameters such as the prompt id from router
+func routeOf(router string) string {
+	for _, route := range routers {
+		if router == string(route) || strings.HasPrefix(router, string(route)+"/") {
+			return string(route)
+		}
+	}
+	return router
+}
+
+// startRequestSpan starts the span of an api call, it ends with the returned func
+func (c *Client) startRequestSpan(ctx context.Context, method, router string) (context.Context, func(resp *http.Response, err error, attempts int)) {
+	route := routeOf(router)
+	ctx, span := c.tracer.Start(ctx, method+" "+route, time.Now(), Attr(AttrHTTPMethod, method), Attr(AttrHTTPRoute, route))
+	return ctx, func(resp *http.Response, err error, attempts int) {
+		if attempts > 1 {
+			span.SetAttributes(Attr(AttrHTTPAttempts, attempts))
+		}
+		switch {
+		case err != nil:
+			span.SetError(err)
+		case resp.StatusCode >= http.StatusBadRequest:
+			span.SetAttributes(Attr(AttrHTTPStatusCode, resp.StatusCode))
+			span.SetError(fmt.Errorf("status %s", resp.Status))
+		default:
+			span.SetAttributes(Attr(AttrHTTPStatusCode, resp.StatusCode))
+		}
+		span.End(time.Now())
+	}
+}
+
+// injectTrace passes the trace context of ctx to the request when the tracer supports it
+func (c *Client) injectTrace(ctx context.Context, header http.Header) {
+	if propagator, ok := c.tracer.(TracePropagator); ok {
+		propagator.Inject(ctx, header)
+	}
+}
+
+// promptTracer keeps the open spans of the prompts queued by the client
+type promptTracer struct {
+	mu     sync.Mutex
+	traces map[string]*promptTrace
+}
+
+type promptTrace struct {
+	ctx    context.Context
+	span   Span
+	prompt Prompt
+	queued Span
+	// running is set once the first execution event arrived
+	running bool
+	node    string
+	// nodeSpan is the span of node, nil when no node is running
+	nodeSpan Span
+}
+
+// startPromptTrace starts the span of a prompt before it is posted, the returned context holds it
+func (c *Client) startPromptTrace(ctx context.Context, promptID, clientID string, prompt Prompt) (context.Context, *promptTrace) {
+	if _, ok := c.tracer.(noopTracer); ok {
+		return ctx, nil
+	}
+	ctx, span := c.tracer.Start(ctx, "comfyui.prompt", time.Now(), Attr(AttrPromptID, promptID), Attr(AttrClientID, clientID))
+	trace := &promptTrace{ctx: ctx, span: span, prompt: prompt}
+	if clientID == c.ID {
+		t := &c.promptTraces
+		t.mu.Lock()
+		if t.traces == nil {
+			t.traces = make(map[string]*promptTrace)
+		}
+		t.traces[promptID] = trace
+		t.mu.Unlock()
+	}
+	return ctx, trace
+}
+
+// promptQueued ends trace when queueing failed or its events can't be received, otherwise the queued span starts
+func (c *Client) promptQueued(trace *promptTrace, promptID, clientID string, resp *QueuePromptResp, err error) {
+	if trace == nil {
+		return
+	}
+	now := time.Now()
+	if err == nil && len(resp.NodeErrors) != 0 {
+		err = fmt.Errorf("prompt rejected, node errors: %v", resp.NodeErrors)
+	}
+	if err != nil {
+		trace.span.SetError(err)
+	} else if resp.Number != 0 {
+		trace.span.SetAttributes(Attr(AttrPromptNumber, resp.Number))
+	}
+	if err != nil || clientID != c.ID {
+		t := &c.promptTraces
+		t.mu.Lock()
+		if t.traces[promptID] == trace {
+			delete(t.traces, promptID)
+		}
+		t.mu.Unlock()
+		trace.span.End(now)
+		return
+	}
+
+	t := &c.promptTraces
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// the prompt may have started or even completed before QueuePrompt returned
+	if t.traces[promptID] == trace && !trace.running {
+		_, trace.queued = c.tracer.Start(trace.ctx, "comfyui.queued", now)
+	}
+}
+
+// tracePrompt updates the spans of the prompt of message, completed is the Completed message when the prompt finished
+func (c *Client) tracePrompt(message *WSMessage, completed *WSMessageDataCompleted) {
+	promptID := message.PromptID()
+	if promptID == "" {
+		return
+	}
+	t := &c.promptTraces
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	trace := t.traces[promptID]
+	if trace == nil {
+		return
+	}
+	now := time.Now()
+
+	switch data := message.Data.(type) {
+	case *WSMessageDataExecutionStart:
+		trace.started(now)
+	case *WSMessageDataExecutionCached:
+		trace.started(now)
+		for _, node := range data.Nodes {
+			_, span := c.tracer.Start(trace.ctx, "comfyui.node", now, trace.nodeAttributes(node, true)...)
+			span.End(now)
+		}
+	case *WSMessageDataExecuting:
+		trace.started(now)
+		trace.endNode(now)
+		if data.Node != "" {
+			trace.node = data.Node
+			_, trace.nodeSpan = c.tracer.Start(trace.ctx, "comfyui.node", now, trace.nodeAttributes(data.Node, false)...)
+		}
+	case *WSMessageDataExecuted:
+		if data.Node == trace.node {
+			trace.endNode(now)
+		}
+	case *WSMessageExecutionError:
+		trace.started(now)
+		if trace.nodeSpan == nil || trace.node != data.Node {
+			trace.endNode(now)
+			trace.node = data.Node
+			_, trace.nodeSpan = c.tracer.Start(trace.ctx, "comfyui.node", now, trace.nodeAttributes(data.Node, false)...)
+		}
+		err := errors.New(data.ExceptionMessage)
+		trace.nodeSpan.SetAttributes(Attr(AttrErrorType, data.ExceptionType))
+		trace.nodeSpan.SetError(err)
+		trace.endNode(now)
+		trace.span.SetAttributes(Attr(AttrErrorType, data.ExceptionType))
+		trace.span.SetError(fmt.Errorf("node %s (%s): %w", data.Node, data.NodeType, err))
+	case *WSMessageExecutionInterrupted:
+		trace.endNode(now)
+	}
+
+	if completed != nil {
+		trace.started(now)
+		trace.endNode(now)
+		trace.span.SetAttributes(Attr(AttrPromptStatus, string(completed.Status)))
+		trace.span.End(now)
+		delete(t.traces, promptID)
+	}
+}
+
+var errClientClosed = errors.New("client closed before the prompt completed")
+
+// endPromptTraces ends the spans of all prompts that didn't complete with err
+func (c *Client) endPromptTraces(err error) {
+	t := &c.promptTraces
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for promptID, trace := range t.traces {
+		if trace.queued != nil {
+			trace.queued.End(now)
+		}
+		trace.endNode(now)
+		trace.span.SetError(err)
+		trace.span.End(now)
+		delete(t.traces, promptID)
+	}
+}
+
+func (t *promptTrace) started(now time.Time) {
+	if t.queued != nil {
+		t.queued.End(now)
+		t.queued = nil
+	}
+	t.running = true
+}
+
+func (t *promptTrace) endNode(now time.Time) {
+	if t.nodeSpan != nil {
+		t.nodeSpan.End(now)
+		t.nodeSpan = nil
+	}
+}
+
+func (t *promptTrace) nodeAttributes(node string, cached bool) []Attribute {
+	attrs := []Attribute{Attr(AttrNodeID, node), Attr(AttrCached, cached)}
+	if n, ok := t.prompt[node]; ok {
+		attrs = append(attrs, Attr(AttrClassType, n.ClassType))
+	}
+	return attrs
+}
@@ -0,0 +1,131 @@
+package comfyUIclient_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/XdpCs/comfyUIclient/comfytest"
+)
+
+// recordingTracer keeps the prompt spans it started, keyed by prompt id
+type recordingTracer struct {
+	mu      sync.Mutex
+	prompts map[string]*recordedSpan
+}
+
+type recordedSpan struct {
+	mu    sync.Mutex
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+func newRecordingTracer() *recordingTracer {
+	return &recordingTracer{prompts: make(map[string]*recordedSpan)}
+}
+
+func (r *recordingTracer) Start(ctx context.Context, name string, start time.Time, attrs ...comfyUIclient.Attribute) (context.Context, comfyUIclient.Span) {
+	span := &recordedSpan{attrs: make(map[string]interface{})}
+	span.SetAttributes(attrs...)
+	if name == "comfyui.prompt" {
+		r.mu.Lock()
+		r.prompts[span.attrs[comfyUIclient.AttrPromptID].(string)] = span
+		r.mu.Unlock()
+	}
+	return ctx, span
+}
+
+func (r *recordingTracer) prompt(promptID string) *recordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.prompts[promptID]
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...comfyUIclient.Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, attr := range attrs {
+		s.attrs[attr.Key] = attr.Value
+	}
+}
+
+func (s *recordedSpan) SetError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+func (s *recordedSpan) End(end time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended = true
+}
+
+func (s *recordedSpan) state() (bool, interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ended, s.attrs[comfyUIclient.AttrPromptStatus], s.err
+}
+
+func TestDeletedPromptTraceEnds(t *testing.T) {
+	tracer := newRecordingTracer()
+	server, client := newTestServer(t, comfyUIclient.WithTracer(tracer), comfyUIclient.WithResync(time.Minute, 100*time.Millisecond))
+	completions(t, client)
+	server.SetDefaultBehavior(&comfytest.Behavior{NodeDelay: time.Second})
+
+	ctx := context.Background()
+	if _, err := client.QueuePromptByNodesWithOptions(ctx, testPrompt(), nil); err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	pending, err := client.QueuePromptByNodesWithOptions(ctx, testPrompt(), nil)
+	if err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	// a resync is due in a minute, deleting the prompt must start the check
+	if err := client.DeleteQueueByPromptID(pending.PromptID); err != nil {
+		t.Fatalf("DeleteQueueByPromptID: %v", err)
+	}
+
+	span := tracer.prompt(pending.PromptID)
+	waitFor(t, 5*time.Second, "deleted prompt span to end", func() bool {
+		ended, _, _ := span.state()
+		return ended
+	})
+	if _, status, _ := span.state(); status != string(comfyUIclient.ExecutionInterrupted) {
+		t.Fatalf("status = %v, want %v", status, comfyUIclient.ExecutionInterrupted)
+	}
+}
+
+func TestCloseEndsPromptTraces(t *testing.T) {
+	tracer := newRecordingTracer()
+	server, client := newTestServer(t, comfyUIclient.WithTracer(tracer))
+	server.SetDefaultBehavior(&comfytest.Behavior{NodeDelay: time.Second})
+
+	resp, err := client.QueuePromptByNodesWithOptions(context.Background(), testPrompt(), nil)
+	if err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if ended, _, err := tracer.prompt(resp.PromptID).state(); !ended || err == nil {
+		t.Fatalf("ended = %v, error = %v, want an ended span with an error", ended, err)
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	_, client := newTestServer(t, comfyUIclient.WithTracer(nil))
+	completed := completions(t, client)
+
+	if _, err := client.QueuePromptByNodesWithOptions(context.Background(), testPrompt(), nil); err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	nextCompleted(t, completed)
+	if _, err := client.GetQueueInfo(); err != nil {
+		t.Fatalf("GetQueueInfo: %v", err)
+	}
+}
@@ -0,0 +1,485 @@
+package comfyUIclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// WebhookEvent is the kind of a webhook notification
+type WebhookEvent string
+
+const (
+	WebhookQueued      WebhookEvent = "prompt.queued"
+	WebhookStarted     WebhookEvent = "prompt.started"
+	WebhookProgress    WebhookEvent = "prompt.progress"
+	WebhookCompleted   WebhookEvent = "prompt.completed"
+	WebhookFailed      WebhookEvent = "prompt.failed"
+	WebhookInterrupted WebhookEvent = "prompt.interrupted"
+)
+
+// Headers of webhook requests, the signature is "sha256=" and the hex HMAC-SHA256 of timestamp + "." + body
+const (
+	WebhookHeaderID        = "X-Webhook-Id"
+	WebhookHeaderEvent     = "X-Webhook-Event"
+	WebhookHeaderTimestamp = "X-Webhook-Timestamp"
+	WebhookHeaderSignature = "X-Webhook-Signature"
+)
+
+// WebhookEndpoint is a url that receives notifications
+type WebhookEndpoint struct {
+	URL string
+	// Secret signs the requests, they are unsigned when it's empty
+	Secret string
+	// Events are the events sent to the endpoint, all when empty
+	Events []WebhookEvent
+}
+
+func (e *WebhookEndpoint) wants(event WebhookEvent) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, wanted := range e.Events {
+		if wanted == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookOptions configures a WebhookNotifier
+type WebhookOptions struct {
+	Endpoints []WebhookEndpoint
+	// OutboxDir keeps undelivered notifications so they are sent after a restart, they are only kept in memory when empty
+	// Notifications that failed for good are moved to the failed subdirectory
+	OutboxDir string
+	// ProgressMilestones are the percentages that send prompt.progress, 25, 50 and 75 when nil
+	ProgressMilestones []float64
+	// Retry controls redelivery, see DefaultWebhookRetryPolicy
+	Retry *RetryPolicy
+	// Concurrency is the number of requests sent at a time, 4 when not positive
+	Concurrency int
+	// HTTPClient sends the requests, a client with a 10 second timeout when nil
+	HTTPClient *http.Client
+	// DownloadURL returns the url of an output file, the /view url of the client when nil
+	DownloadURL func(file *DataOutputFile) string
+}
+
+// DefaultWebhookRetryPolicy returns the policy used when WebhookOptions.Retry is nil
+func DefaultWebhookRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    10,
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Minute,
+		Multiplier:     2,
+		RetryStatuses: []int{
+			http.StatusRequestTimeout,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// WebhookNotification is the json body of a webhook request
+type WebhookNotification struct {
+	ID        string       `json:"id"`
+	Event     WebhookEvent `json:"event"`
+	CreatedAt time.Time    `json:"created_at"`
+	PromptID  string       `json:"prompt_id"`
+	// Progress is the estimated percentage of prompt.progress
+	Progress float64 `json:"progress,omitempty"`
+	// Outputs are the files of prompt.completed
+	Outputs       []*WebhookOutput         `json:"outputs,omitempty"`
+	ExecutedNodes []string                 `json:"executed_nodes,omitempty"`
+	CachedNodes   []string                 `json:"cached_nodes,omitempty"`
+	Error         *WSMessageExecutionError `json:"error,omitempty"`
+	// Duration is the execution time in seconds of finished prompts, omitted when the start was missed
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// WebhookOutput is an output file of a prompt and the url to download it
+type WebhookOutput struct {
+	Node string `json:"node"`
+	// Kind is the output name of the node, e.g. images, gifs or audio
+	Kind string `json:"kind"`
+	*DataOutputFile
+	URL string `json:"url"`
+}
+
+// webhookDelivery is a notification for one endpoint, it is saved as json in the outbox
+type webhookDelivery struct {
+	ID          string          `json:"id"`
+	URL         string          `json:"url"`
+	Event       WebhookEvent    `json:"event"`
+	Body        json.RawMessage `json:"body"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+// webhookPrompt is what the notifier knows about a prompt
+type webhookPrompt struct {
+	started   bool
+	tracker   *ProgressTracker
+	milestone int
+}
+
+// WebhookNotifier posts notifications about the prompts of a client to webhook endpoints
+// Notifications are sent concurrently and retried, receivers should order them by created_at and drop duplicates by id
+// prompt.progress is only sent for prompts queued by the client
+type WebhookNotifier struct {
+	client     *Client
+	opts       WebhookOptions
+	retry      RetryPolicy
+	milestones []float64
+	httpClient *http.Client
+	endpoints  map[string]*WebhookEndpoint
+	outbox     *webhookOutbox
+	remove     func()
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+	semaphore  chan struct{}
+
+	mu      sync.Mutex
+	prompts map[string]*webhookPrompt
+	// finished remembers completed prompts, so trailing events don't track them again
+	finished      map[string]struct{}
+	finishedOrder []string
+}
+
+// webhookFinishedSize is the number of completed prompts remembered
+const webhookFinishedSize = 4096
+
+// NewWebhookNotifier starts notifying about the prompts of client, deliveries left in the outbox are resumed
+func NewWebhookNotifier(client *Client, opts *WebhookOptions) (*WebhookNotifier, error) {
+	if opts == nil || len(opts.Endpoints) == 0 {
+		return nil, errors.New("no webhook endpoints")
+	}
+	n := &WebhookNotifier{
+		client:     client,
+		opts:       *opts,
+		retry:      DefaultWebhookRetryPolicy(),
+		milestones: opts.ProgressMilestones,
+		httpClient: opts.HTTPClient,
+		endpoints:  make(map[string]*WebhookEndpoint, len(opts.Endpoints)),
+		prompts:    make(map[string]*webhookPrompt),
+		finished:   make(map[string]struct{}),
+	}
+	if opts.Retry != nil {
+		n.retry = *opts.Retry
+	}
+	if n.milestones == nil {
+		n.milestones = []float64{25, 50, 75}
+	}
+	n.milestones = append([]float64(nil), n.milestones...)
+	sort.Float64s(n.milestones)
+	if n.httpClient == nil {
+		n.httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	n.semaphore = make(chan struct{}, concurrency)
+	for i := range n.opts.Endpoints {
+		endpoint := &n.opts.Endpoints[i]
+		if _, err := url.ParseRequestURI(endpoint.URL); err != nil {
+			return nil, fmt.Errorf("url.ParseRequestURI: error: %w", err)
+		}
+		n.endpoints[endpoint.URL] = endpoint
+	}
+
+	var pending []*webhookDelivery
+	if opts.OutboxDir != "" {
+		outbox, err := openWebhookOutbox(opts.OutboxDir)
+		if err != nil {
+			return nil, err
+		}
+		n.outbox = outbox
+		if pending, err = outbox.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	for _, delivery := range pending {
+		if _, ok := n.endpoints[delivery.URL]; !ok {
+			client.logger.Warn("webhook endpoint was removed, moving delivery to failed", "id", delivery.ID, "url", delivery.URL)
+			n.outbox.fail(delivery)
+			continue
+		}
+		n.start(delivery)
+	}
+	n.remove = client.AddListener(n.handle)
+	return n, nil
+}
+
+// Close stops notifying and waits for running requests, undelivered notifications stay in the outbox
+func (n *WebhookNotifier) Close() {
+	n.remove()
+	n.cancel()
+	n.wg.Wait()
+}
+
+func (n *WebhookNotifier) handle(message *WSMessage) {
+	promptID := message.PromptID()
+	if promptID == "" {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.finished[promptID]; ok {
+		return
+	}
+	prompt := n.prompts[promptID]
+
+	switch data := message.Data.(type) {
+	case *WSMessageDataQueued:
+		// events of prompts queued for another client id never reach this client
+		if data.ClientID != n.client.ID {
+			n.notify(&WebhookNotification{Event: WebhookQueued, PromptID: promptID})
+			return
+		}
+		if prompt != nil {
+			return
+		}
+		prompt = &webhookPrompt{}
+		if len(data.Prompt) != 0 {
+			prompt.tracker = NewProgressTracker(promptID, data.Prompt, 0)
+		}
+		n.prompts[promptID] = prompt
+		n.notify(&WebhookNotification{Event: WebhookQueued, PromptID: promptID})
+		return
+	case *WSMessageDataCompleted:
+		delete(n.prompts, promptID)
+		n.finished[promptID] = struct{}{}
+		n.finishedOrder = append(n.finishedOrder, promptID)
+		if len(n.finishedOrder) > webhookFinishedSize {
+			delete(n.finished, n.finishedOrder[0])
+			n.finishedOrder = n.finishedOrder[1:]
+		}
+		notification := &WebhookNotification{
+			Event:         WebhookCompleted,
+			PromptID:      promptID,
+			ExecutedNodes: data.ExecutedNodes,
+			CachedNodes:   data.CachedNodes,
+			Duration:      data.Duration().Seconds(),
+		}
+		switch data.Status {
+		case ExecutionError:
+			notification.Event = WebhookFailed
+			notification.Error = data.Error
+		case ExecutionInterrupted:
+			notification.Event = WebhookInterrupted
+		}
+		notification.Outputs = n.outputs(data.Outputs)
+		n.notify(notification)
+		return
+	case *WSMessageDataExecuting:
+		// executing without a node ends the prompt, completed follows
+		if data.Node == "" {
+			return
+		}
+	}
+
+	if prompt == nil {
+		prompt = &webhookPrompt{}
+		n.prompts[promptID] = prompt
+	}
+	if !prompt.started {
+		prompt.started = true
+		n.notify(&WebhookNotification{Event: WebhookStarted, PromptID: promptID})
+	}
+	if prompt.tracker == nil {
+		return
+	}
+	prompt.tracker.Handle(message)
+	percent := prompt.tracker.Snapshot().Percent
+	crossed := -1
+	for prompt.milestone < len(n.milestones) && percent >= n.milestones[prompt.milestone] {
+		crossed = prompt.milestone
+		prompt.milestone++
+	}
+	// a jump over several milestones only sends the last one
+	if crossed >= 0 {
+		n.notify(&WebhookNotification{Event: WebhookProgress, PromptID: promptID, Progress: n.milestones[crossed]})
+	}
+}
+
+// outputs lists the files ordered by node id and output name
+func (n *WebhookNotifier) outputs(files map[string]map[string][]*DataOutputFile) []*WebhookOutput {
+	nodes := make([]string, 0, len(files))
+	for node := range files {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	var outputs []*WebhookOutput
+	for _, node := range nodes {
+		kinds := make([]string, 0, len(files[node]))
+		for kind := range files[node] {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			for _, file := range files[node][kind] {
+				downloadURL := n.client.viewURL(file)
+				if n.opts.DownloadURL != nil {
+					downloadURL = n.opts.DownloadURL(file)
+				}
+				outputs = append(outputs, &WebhookOutput{Node: node, Kind: kind, DataOutputFile: file, URL: downloadURL})
+			}
+		}
+	}
+	return outputs
+}
+
+// notify creates a delivery for every endpoint that wants the notification
+func (n *WebhookNotifier) notify(notification *WebhookNotification) {
+	notification.ID = NewPromptID()
+	notification.CreatedAt = time.Now()
+	body, err := json.Marshal(notification)
+	if err != nil {
+		n.client.logger.Error("encode webhook notification failed", "prompt_id", notification.PromptID, "error", err)
+		return
+	}
+	for i := range n.opts.Endpoints {
+		endpoint := &n.opts.Endpoints[i]
+		if !endpoint.wants(notification.Event) {
+			continue
+		}
+		delivery := &webhookDelivery{
+			ID:          notification.ID + "-" + strconv.Itoa(i),
+			URL:         endpoint.URL,
+			Event:       notification.Event,
+			Body:        body,
+			NextAttempt: notification.CreatedAt,
+		}
+		// the delivery is saved before the listener returns, so it survives a crash or Close right after the event
+		if n.outbox != nil {
+			if err := n.outbox.save(delivery); err != nil {
+				n.client.logger.Error("save webhook delivery failed", "id", delivery.ID, "error", err)
+			}
+		}
+		n.start(delivery)
+	}
+}
+
+// start sends delivery in the background until it succeeded, failed for good or the notifier is closed
+func (n *WebhookNotifier) start(delivery *webhookDelivery) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		for {
+			if err := sleepContext(n.ctx, time.Until(delivery.NextAttempt)); err != nil {
+				return
+			}
+			select {
+			case n.semaphore <- struct{}{}:
+			case <-n.ctx.Done():
+				return
+			}
+			resp, err := n.send(delivery)
+			<-n.semaphore
+			if err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				discardBody(resp)
+				if n.outbox != nil {
+					n.outbox.remove(delivery)
+				}
+				return
+			}
+			if n.ctx.Err() != nil {
+				discardBody(resp)
+				return
+			}
+
+			delivery.Attempts++
+			delivery.LastError = describeAttempt(resp, err)
+			if delivery.Attempts >= n.retry.MaxAttempts || !n.retry.shouldRetry(resp, err) {
+				discardBody(resp)
+				n.client.logger.Warn("webhook delivery failed", "id", delivery.ID, "url", delivery.URL, "attempts", delivery.Attempts, "error", delivery.LastError)
+				if n.outbox != nil {
+					n.outbox.fail(delivery)
+				}
+				return
+			}
+			delivery.NextAttempt = time.Now().Add(n.retry.backoff(delivery.Attempts, resp))
+			discardBody(resp)
+			n.client.logger.Info("retrying webhook delivery", "id", delivery.ID, "url", delivery.URL, "attempt", delivery.Attempts, "error", delivery.LastError)
+			if n.outbox != nil {
+				if err := n.outbox.save(delivery); err != nil {
+					n.client.logger.Error("save webhook delivery failed", "id", delivery.ID, "error", err)
+				}
+			}
+		}
+	}()
+}
+
+func (n *WebhookNotifier) send(delivery *webhookDelivery) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+	var notification struct {
+		ID string `json:"id"`
+	}
+	_ = json.Unmarshal(delivery.Body, &notification)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookHeaderID, notification.ID)
+	req.Header.Set(WebhookHeaderEvent, string(delivery.Event))
+	req.Header.Set(WebhookHeaderTimestamp, timestamp)
+	if endpoint := n.endpoints[delivery.URL]; endpoint != nil && endpoint.Secret != "" {
+		req.Header.Set(WebhookHeaderSignature, SignWebhook(endpoint.Secret, timestamp, delivery.Body))
+	}
+	return n.httpClient.Do(req)
+}
+
+// SignWebhook returns the signature header of body sent at timestamp
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature of a webhook request and returns its body
+// Requests whose timestamp is further than tolerance from now are rejected, a non-positive tolerance skips the check
+func VerifyWebhook(r *http.Request, secret string, tolerance time.Duration) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("io.ReadAll: error: %w", err)
+	}
+	timestamp := r.Header.Get(WebhookHeaderTimestamp)
+	if tolerance > 0 {
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid webhook timestamp")
+		}
+		if age := time.Since(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+			return nil, errors.New("webhook timestamp out of tolerance")
+		}
+	}
+	expected := SignWebhook(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(WebhookHeaderSignature))) {
+		return nil, errors.New("invalid webhook signature")
+	}
+	return body, nil
+}
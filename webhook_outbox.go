@@ -0,0 +1,75 @@
+package comfyUIclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// webhookOutbox keeps every undelivered webhookDelivery as a json file
+type webhookOutbox struct {
+	dir string
+}
+
+func openWebhookOutbox(dir string) (*webhookOutbox, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "failed"), 0o700); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll: error: %w", err)
+	}
+	return &webhookOutbox{dir: dir}, nil
+}
+
+func (o *webhookOutbox) path(delivery *webhookDelivery) string {
+	return filepath.Join(o.dir, delivery.ID+".json")
+}
+
+// save writes delivery to a temporary file first, so a crash never leaves a partial file behind
+func (o *webhookOutbox) save(delivery *webhookDelivery) error {
+	b, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: error: %w", err)
+	}
+	temp := o.path(delivery) + ".tmp"
+	if err := os.WriteFile(temp, b, 0o600); err != nil {
+		return fmt.Errorf("os.WriteFile: error: %w", err)
+	}
+	if err := os.Rename(temp, o.path(delivery)); err != nil {
+		return fmt.Errorf("os.Rename: error: %w", err)
+	}
+	return nil
+}
+
+func (o *webhookOutbox) remove(delivery *webhookDelivery) {
+	_ = os.Remove(o.path(delivery))
+}
+
+// fail moves delivery to the failed directory for inspection
+func (o *webhookOutbox) fail(delivery *webhookDelivery) {
+	if err := o.save(delivery); err != nil {
+		return
+	}
+	_ = os.Rename(o.path(delivery), filepath.Join(o.dir, "failed", delivery.ID+".json"))
+}
+
+// load returns the saved deliveries, oldest first
+func (o *webhookOutbox) load() ([]*webhookDelivery, error) {
+	paths, err := filepath.Glob(filepath.Join(o.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("filepath.Glob: error: %w", err)
+	}
+	deliveries := make([]*webhookDelivery, 0, len(paths))
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("os.ReadFile: error: %w", err)
+		}
+		delivery := &webhookDelivery{}
+		if err := json.Unmarshal(b, delivery); err != nil {
+			return nil, fmt.Errorf("json.Unmarshal: error: %w, file: %s", err, path)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].NextAttempt.Before(deliveries[j].NextAttempt) })
+	return deliveries, nil
+}
@@ -0,0 +1,192 @@
+package comfyUIclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+)
+
+// webhookReceiver records the notifications posted to it after verifying their signature
+type webhookReceiver struct {
+	// down rejects notifications with 503, rejected counts them
+	down     atomic.Bool
+	rejected atomic.Int32
+
+	mu            sync.Mutex
+	notifications []*comfyUIclient.WebhookNotification
+}
+
+func newWebhookReceiver(t *testing.T, secret string) (*httptest.Server, *webhookReceiver) {
+	t.Helper()
+	r := &webhookReceiver{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if r.down.Load() {
+			r.rejected.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, err := comfyUIclient.VerifyWebhook(req, secret, time.Minute)
+		if err != nil {
+			t.Errorf("VerifyWebhook: %v", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		notification := &comfyUIclient.WebhookNotification{}
+		if err := json.Unmarshal(body, notification); err != nil {
+			t.Errorf("json.Unmarshal: %v", err)
+		}
+		r.mu.Lock()
+		r.notifications = append(r.notifications, notification)
+		r.mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return server, r
+}
+
+// events returns the events of promptID ordered by created_at
+func (r *webhookReceiver) events(promptID string) []comfyUIclient.WebhookEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var notifications []*comfyUIclient.WebhookNotification
+	for _, notification := range r.notifications {
+		if notification.PromptID == promptID {
+			notifications = append(notifications, notification)
+		}
+	}
+	sort.Slice(notifications, func(i, j int) bool { return notifications[i].CreatedAt.Before(notifications[j].CreatedAt) })
+	events := make([]comfyUIclient.WebhookEvent, len(notifications))
+	for i, notification := range notifications {
+		events[i] = notification.Event
+	}
+	return events
+}
+
+func TestWebhookNotifierDeliversPromptEvents(t *testing.T) {
+	_, client := newTestServer(t)
+	receiver, r := newWebhookReceiver(t, "secret")
+	outbox := t.TempDir()
+	notifier, err := comfyUIclient.NewWebhookNotifier(client, &comfyUIclient.WebhookOptions{
+		Endpoints: []comfyUIclient.WebhookEndpoint{{
+			URL:    receiver.URL,
+			Secret: "secret",
+			Events: []comfyUIclient.WebhookEvent{comfyUIclient.WebhookQueued, comfyUIclient.WebhookStarted, comfyUIclient.WebhookCompleted},
+		}},
+		OutboxDir: outbox,
+	})
+	if err != nil {
+		t.Fatalf("NewWebhookNotifier: %v", err)
+	}
+	defer notifier.Close()
+
+	resp, err := client.QueuePromptByNodesWithOptions(context.Background(), testPrompt(), nil)
+	if err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	waitFor(t, 5*time.Second, "webhook notifications", func() bool { return len(r.events(resp.PromptID)) == 3 })
+
+	want := []comfyUIclient.WebhookEvent{comfyUIclient.WebhookQueued, comfyUIclient.WebhookStarted, comfyUIclient.WebhookCompleted}
+	events := r.events(resp.PromptID)
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("events = %v, want %v", events, want)
+		}
+	}
+	// delivered notifications are removed from the outbox
+	waitFor(t, 5*time.Second, "empty outbox", func() bool {
+		files, _ := filepath.Glob(filepath.Join(outbox, "*.json"))
+		return len(files) == 0
+	})
+	if failed, _ := os.ReadDir(filepath.Join(outbox, "failed")); len(failed) != 0 {
+		t.Fatalf("%d deliveries failed", len(failed))
+	}
+}
+
+func TestWebhookNotifierResumesOutbox(t *testing.T) {
+	_, client := newTestServer(t)
+	receiver, r := newWebhookReceiver(t, "secret")
+	r.down.Store(true)
+	outbox := t.TempDir()
+	opts := &comfyUIclient.WebhookOptions{
+		Endpoints: []comfyUIclient.WebhookEndpoint{{URL: receiver.URL, Secret: "secret", Events: []comfyUIclient.WebhookEvent{comfyUIclient.WebhookCompleted}}},
+		OutboxDir: outbox,
+		Retry:     &comfyUIclient.RetryPolicy{MaxAttempts: 10, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2, RetryStatuses: []int{http.StatusServiceUnavailable}},
+	}
+	notifier, err := comfyUIclient.NewWebhookNotifier(client, opts)
+	if err != nil {
+		t.Fatalf("NewWebhookNotifier: %v", err)
+	}
+
+	resp, err := client.QueuePromptByNodesWithOptions(context.Background(), testPrompt(), nil)
+	if err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	waitFor(t, 5*time.Second, "rejected delivery", func() bool { return r.rejected.Load() != 0 })
+	notifier.Close()
+	if files, _ := filepath.Glob(filepath.Join(outbox, "*.json")); len(files) != 1 {
+		t.Fatalf("outbox has %d deliveries, want 1", len(files))
+	}
+
+	// the endpoint recovered, a new notifier sends the saved delivery
+	r.down.Store(false)
+	notifier, err = comfyUIclient.NewWebhookNotifier(client, opts)
+	if err != nil {
+		t.Fatalf("NewWebhookNotifier: %v", err)
+	}
+	defer notifier.Close()
+	waitFor(t, 5*time.Second, "resumed delivery", func() bool { return len(r.events(resp.PromptID)) == 1 })
+	if events := r.events(resp.PromptID); events[0] != comfyUIclient.WebhookCompleted {
+		t.Fatalf("events = %v, want %v", events, comfyUIclient.WebhookCompleted)
+	}
+}
+
+func TestWebhookNotifierSavesBeforeClose(t *testing.T) {
+	_, client := newTestServer(t)
+	receiver, r := newWebhookReceiver(t, "secret")
+	r.down.Store(true)
+	outbox := t.TempDir()
+	notifier, err := comfyUIclient.NewWebhookNotifier(client, &comfyUIclient.WebhookOptions{
+		Endpoints: []comfyUIclient.WebhookEndpoint{{URL: receiver.URL, Secret: "secret", Events: []comfyUIclient.WebhookEvent{comfyUIclient.WebhookCompleted}}},
+		OutboxDir: outbox,
+		Retry:     &comfyUIclient.RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Minute, MaxBackoff: time.Minute, Multiplier: 2, RetryStatuses: []int{http.StatusServiceUnavailable}},
+	})
+	if err != nil {
+		t.Fatalf("NewWebhookNotifier: %v", err)
+	}
+
+	// listeners run in order, this one runs right after the notifier handled the event and closes it
+	saved := make(chan int, 1)
+	remove := client.AddListener(func(message *comfyUIclient.WSMessage) {
+		if message.Type != comfyUIclient.Completed {
+			return
+		}
+		files, _ := filepath.Glob(filepath.Join(outbox, "*.json"))
+		notifier.Close()
+		saved <- len(files)
+	})
+	defer remove()
+
+	if _, err := client.QueuePromptByNodesWithOptions(context.Background(), testPrompt(), nil); err != nil {
+		t.Fatalf("QueuePromptByNodesWithOptions: %v", err)
+	}
+	select {
+	case n := <-saved:
+		if n != 1 {
+			t.Fatalf("outbox had %d deliveries when the listener returned, want 1", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the completed prompt")
+	}
+	if files, _ := filepath.Glob(filepath.Join(outbox, "*.json")); len(files) != 1 {
+		t.Fatalf("outbox has %d deliveries after Close, want 1", len(files))
+	}
+}
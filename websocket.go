@@ -1,9 +1,12 @@
 package comfyUIclient
 
 import (
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"net"
+	"net/http"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,17 +15,71 @@ import (
 )
 
 type WebSocketConnection struct {
-	URL         string
-	Conn        *websocket.Conn
+	URL string
+	// Conn is the current connection when it was dialed by Dialer, it is nil with a custom Transport
+	Conn *websocket.Conn
+	// mu guards conn and Conn, the reconnect loop replaces them while Close reads them
+	mu          sync.Mutex
+	conn        WebSocketConn
 	isConnected atomic.Bool
 	MaxRetry    int
 	handler     Handler
+	// Authenticator adds credentials to the websocket handshake
+	Authenticator Authenticator
+	// Dialer is used to connect, websocket.DefaultDialer when nil
+	Dialer *websocket.Dialer
+	// Transport is used to connect instead of Dialer when set, e.g. to record or replay the connection
+	Transport WebSocketDialer
+	// Header is sent with the websocket handshake
+	Header http.Header
+	// ReconnectInterval is the time between connection checks, 5 seconds when zero
+	ReconnectInterval time.Duration
+	// Logger receives connection logs, slog.Default() when nil
+	Logger Logger
+
+	// onStateChange is called when the connection goes up or down
+	onStateChange func(connected bool)
+	stopped       atomic.Bool
 }
 
 type Handler interface {
 	Handle(string) error
 }
 
+// BinaryHandler is implemented by handlers that accept binary frames, other handlers receive them as string
+type BinaryHandler interface {
+	HandleBinary([]byte) error
+}
+
+// WebSocketConn is a connection received messages are read from, *websocket.Conn implements it
+type WebSocketConn interface {
+	ReadMessage() (messageType int, data []byte, err error)
+	Close() error
+}
+
+// WebSocketDialer opens websocket connections, see WithWebSocketTransport
+type WebSocketDialer interface {
+	Dial(url string, header http.Header) (WebSocketConn, *http.Response, error)
+}
+
+// DialerTransport returns dialer as a WebSocketDialer
+func DialerTransport(dialer *websocket.Dialer) WebSocketDialer {
+	return dialerTransport{dialer: dialer}
+}
+
+type dialerTransport struct {
+	dialer *websocket.Dialer
+}
+
+func (d dialerTransport) Dial(url string, header http.Header) (WebSocketConn, *http.Response, error) {
+	conn, resp, err := d.dialer.Dial(url, header)
+	if err != nil {
+		// a nil *websocket.Conn must not become a non-nil WebSocketConn
+		return nil, resp, err
+	}
+	return conn, resp, nil
+}
+
 func NewDefaultWebSocketConnection(url string, handler Handler) *WebSocketConnection {
 	return NewWebSocketConnection(url, 3, handler)
 }
@@ -38,91 +95,255 @@ func NewWebSocketConnection(url string, maxRetry int, handler Handler) *WebSocke
 // ConnectAndListen connects to the websocket and listens for messages
 func (w *WebSocketConnection) ConnectAndListen() {
 	defer w.Close()
-	for {
+	logger := w.logger()
+	reconnecting := false
+	for !w.stopped.Load() {
 		if !w.GetIsConnected() {
-			var err error
-			for i := 0; i < w.MaxRetry; i++ {
-				if err = w.Connect(); err != nil {
-					log.Printf("websocket connection error %v", err)
-					continue
-				}
-				break
+			if reconnecting {
+				logger.Info("websocket disconnected, reconnecting")
 			}
-
-			if err == nil {
-				w.SetIsConnected(true)
-				go w.listen()
+			if err := w.connectAndStartListening(); err == nil {
+				reconnecting = true
 			}
 		}
-		time.Sleep(5 * time.Second)
+		time.Sleep(w.reconnectInterval())
 	}
 }
 
-func (w *WebSocketConnection) Connect() error {
-	var err error
-	w.Conn, _, err = websocket.DefaultDialer.Dial(w.URL, nil)
+// connectAndStartListening dials up to MaxRetry times and starts listening once connected
+func (w *WebSocketConnection) connectAndStartListening() error {
+	logger := w.logger()
+	err := errors.New("MaxRetry must be positive")
+	var conn WebSocketConn
+	for i := 0; i < w.MaxRetry; i++ {
+		if conn, err = w.connect(); errors.Is(err, errWebSocketStopped) {
+			return err
+		}
+		if err != nil {
+			logger.Warn("websocket connection failed", "attempt", i+1, "max_retry", w.MaxRetry, "error", err)
+			continue
+		}
+		break
+	}
 	if err != nil {
-		return fmt.Errorf("websocket.DefaultDialer.Dial: error: %w", err)
+		logger.Error("websocket connection retries exhausted", "max_retry", w.MaxRetry, "error", err)
+		return err
 	}
+
+	logger.Info("websocket connected")
 	w.SetIsConnected(true)
+	go w.listen(conn)
 	return nil
 }
 
-func (w *WebSocketConnection) listen() {
-	defer w.Close()
+var errWebSocketStopped = errors.New("websocket connection stopped")
+
+func (w *WebSocketConnection) Connect() error {
+	_, err := w.connect()
+	return err
+}
+
+// connect dials and makes the new connection the current one, it is closed right away when stop was called meanwhile
+func (w *WebSocketConnection) connect() (WebSocketConn, error) {
+	conn, resp, err := w.dial()
+	// credentials may have expired, refresh them and retry once
+	if err != nil && resp != nil && resp.StatusCode == http.StatusUnauthorized && w.Authenticator != nil {
+		if refreshErr := w.Authenticator.Refresh(); refreshErr == nil {
+			conn, _, err = w.dial()
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dialer.Dial: error: %w", err)
+	}
+	w.mu.Lock()
+	if w.stopped.Load() {
+		w.mu.Unlock()
+		conn.Close()
+		return nil, errWebSocketStopped
+	}
+	w.conn = conn
+	w.Conn, _ = conn.(*websocket.Conn)
+	w.mu.Unlock()
+	w.SetIsConnected(true)
+	return conn, nil
+}
+
+func (w *WebSocketConnection) dial() (WebSocketConn, *http.Response, error) {
+	header := w.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if w.Authenticator != nil {
+		if err := w.Authenticator.Authenticate(header); err != nil {
+			return nil, nil, fmt.Errorf("w.Authenticator.Authenticate: error: %w", err)
+		}
+	}
+	if w.Transport != nil {
+		return w.Transport.Dial(w.URL, header)
+	}
+	dialer := w.Dialer
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	return DialerTransport(dialer).Dial(w.URL, header)
+}
+
+func (w *WebSocketConnection) reconnectInterval() time.Duration {
+	if w.ReconnectInterval <= 0 {
+		return 5 * time.Second
+	}
+	return w.ReconnectInterval
+}
+
+// listen reads from conn until it fails, the reconnect loop then replaces the current connection
+func (w *WebSocketConnection) listen(conn WebSocketConn) {
+	logger := w.logger()
 	for {
-		_, message, err := w.Conn.ReadMessage()
+		messageType, message, err := conn.ReadMessage()
 		if err != nil {
-			log.Println("reading from WebSocket error: ", err)
+			switch {
+			case w.stopped.Load() || errors.Is(err, net.ErrClosed):
+				// the connection was closed by stop or Close
+				logger.Debug("websocket closed", "error", err)
+			case websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway):
+				logger.Info("websocket closed by server", "error", err)
+			default:
+				logger.Warn("reading from websocket failed", "error", err)
+			}
+			w.release(conn)
 			w.SetIsConnected(false)
 			break
 		}
-		if err := w.handler.Handle(string(message)); err != nil {
-			log.Println("handle WebSocket error: ", err)
+		if binaryHandler, ok := w.handler.(BinaryHandler); ok && messageType == websocket.BinaryMessage {
+			err = binaryHandler.HandleBinary(message)
+		} else {
+			err = w.handler.Handle(string(message))
 		}
+		if err != nil {
+			logger.Warn("handle websocket message failed", "error", err)
+		}
+	}
+
+}
+
+func (w *WebSocketConnection) logger() Logger {
+	if w.Logger == nil {
+		return withFields(defaultLogger(), "url", w.URL)
 	}
+	return w.Logger
+}
 
+// release closes conn and forgets it unless it was replaced already
+func (w *WebSocketConnection) release(conn WebSocketConn) {
+	w.mu.Lock()
+	if w.conn == conn {
+		w.conn = nil
+		w.Conn = nil
+	}
+	w.mu.Unlock()
+	conn.Close()
 }
 
 func (w *WebSocketConnection) Close() error {
-	if err := w.Conn.Close(); err != nil {
+	w.mu.Lock()
+	conn := w.conn
+	w.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	if err := conn.Close(); err != nil {
 		return fmt.Errorf(" w.Conn.Close() error: %w", err)
 	}
 	return nil
 }
 
+// stop ends ConnectAndListen and closes the connection
+func (w *WebSocketConnection) stop() error {
+	w.stopped.Store(true)
+	return w.Close()
+}
+
 func (w *WebSocketConnection) GetIsConnected() bool {
 	return w.isConnected.Load()
 }
 
 func (w *WebSocketConnection) SetIsConnected(iConnected bool) {
-	w.isConnected.Store(iConnected)
+	if w.isConnected.Swap(iConnected) != iConnected && w.onStateChange != nil {
+		w.onStateChange(iConnected)
+	}
 }
 
 type WSMessage struct {
 	Type WsMessageType `json:"type"`
 	Data interface{}   `json:"data"`
+	// Raw is the undecoded data of the message
+	Raw json.RawMessage `json:"-"`
+}
+
+// PromptID returns the prompt id of the message, empty for messages not about a prompt
+func (m *WSMessage) PromptID() string {
+	switch data := m.Data.(type) {
+	case *WSMessageDataExecutionStart:
+		return data.PromptID
+	case *WSMessageDataExecutionCached:
+		return data.PromptID
+	case *WSMessageDataExecuting:
+		return data.PromptID
+	case *WSMessageDataProgress:
+		return data.PromptID
+	case *WSMessageDataExecuted:
+		return data.PromptID
+	case *WSMessageExecutionInterrupted:
+		return data.PromptID
+	case *WSMessageExecutionError:
+		return data.PromptID
+	case *WSMessageDataExecutionSuccess:
+		return data.PromptID
+	case *WSMessageDataProgressState:
+		return data.PromptID
+	case *WSMessageDataPreviewImage:
+		return data.PromptID
+	case *WSMessageDataCompleted:
+		return data.PromptID
+	case *WSMessageDataQueued:
+		return data.PromptID
+	}
+	return ""
 }
 
 var (
-	messageTypeMap map[WsMessageType]func() interface{}
-	once           sync.Once
+	messageTypeMu  sync.RWMutex
+	messageTypeMap = map[WsMessageType]func() interface{}{
+		Status:               func() interface{} { return &WSMessageDataStatus{} },
+		ExecutionStart:       func() interface{} { return &WSMessageDataExecutionStart{} },
+		ExecutionCached:      func() interface{} { return &WSMessageDataExecutionCached{} },
+		Executing:            func() interface{} { return &WSMessageDataExecuting{} },
+		Progress:             func() interface{} { return &WSMessageDataProgress{} },
+		Executed:             func() interface{} { return &WSMessageDataExecuted{} },
+		ExecutionInterrupted: func() interface{} { return &WSMessageExecutionInterrupted{} },
+		ExecutionError:       func() interface{} { return &WSMessageExecutionError{} },
+		ExecutionSuccess:     func() interface{} { return &WSMessageDataExecutionSuccess{} },
+		ProgressState:        func() interface{} { return &WSMessageDataProgressState{} },
+	}
 )
 
+// RegisterWSMessageType registers a decoder for messages of messageType, e.g. events of custom nodes
+// newData must return a pointer that the data of the message is unmarshalled into
+// Messages without a registered decoder are delivered with Data of type json.RawMessage
+func RegisterWSMessageType(messageType WsMessageType, newData func() interface{}) {
+	messageTypeMu.Lock()
+	defer messageTypeMu.Unlock()
+	messageTypeMap[messageType] = newData
+}
+
 func getWSMessageData(messageType WsMessageType) interface{} {
-	once.Do(func() {
-		messageTypeMap = map[WsMessageType]func() interface{}{
-			Status:               func() interface{} { return &WSMessageDataStatus{} },
-			ExecutionStart:       func() interface{} { return &WSMessageDataExecutionStart{} },
-			ExecutionCached:      func() interface{} { return &WSMessageDataExecutionCached{} },
-			Executing:            func() interface{} { return &WSMessageDataExecuting{} },
-			Progress:             func() interface{} { return &WSMessageDataProgress{} },
-			Executed:             func() interface{} { return &WSMessageDataExecuted{} },
-			ExecutionInterrupted: func() interface{} { return &WSMessageExecutionInterrupted{} },
-			ExecutionError:       func() interface{} { return &WSMessageExecutionError{} },
-		}
-	})
-	return messageTypeMap[messageType]()
+	messageTypeMu.RLock()
+	newData, ok := messageTypeMap[messageType]
+	messageTypeMu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return newData()
 }
 
 func (m *WSMessage) UnmarshalJSON(b []byte) error {
@@ -135,16 +356,93 @@ func (m *WSMessage) UnmarshalJSON(b []byte) error {
 	}
 
 	m.Type = temp.Type
+	m.Raw = temp.Data
 	messageData := getWSMessageData(m.Type)
-	if messageData != nil {
-		if err := json.Unmarshal(temp.Data, messageData); err != nil {
-			return err
-		}
-		m.Data = messageData
+	if messageData == nil {
+		m.Data = temp.Data
+		return nil
 	}
+	if err := json.Unmarshal(temp.Data, messageData); err != nil {
+		return fmt.Errorf("unmarshal %s data: %w", m.Type, err)
+	}
+	m.Data = messageData
 	return nil
 }
 
+// binary event types of ComfyUI
+const (
+	binaryPreviewImage             = 1
+	binaryUnencodedPreviewImage    = 2
+	binaryText                     = 3
+	binaryPreviewImageWithMetadata = 4
+)
+
+// decodeBinaryMessage decodes a binary frame, its first 4 bytes are the big endian event type
+func decodeBinaryMessage(b []byte) (*WSMessage, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("binary message too short: %d bytes", len(b))
+	}
+	eventType := binary.BigEndian.Uint32(b[:4])
+	payload := b[4:]
+	switch eventType {
+	case binaryPreviewImage:
+		preview, err := decodePreviewImage(payload)
+		if err != nil {
+			return nil, err
+		}
+		return &WSMessage{Type: PreviewImage, Data: preview}, nil
+	case binaryPreviewImageWithMetadata:
+		if len(payload) < 4 {
+			return nil, errors.New("preview metadata too short")
+		}
+		metadataLength := int(binary.BigEndian.Uint32(payload[:4]))
+		if len(payload) < 4+metadataLength {
+			return nil, errors.New("preview metadata too short")
+		}
+		var metadata struct {
+			PromptID  string `json:"prompt_id"`
+			NodeID    string `json:"node_id"`
+			ImageType string `json:"image_type"`
+		}
+		if err := json.Unmarshal(payload[4:4+metadataLength], &metadata); err != nil {
+			return nil, fmt.Errorf("json.Unmarshal: preview metadata error: %w", err)
+		}
+		preview := &WSMessageDataPreviewImage{
+			Format:   metadata.ImageType,
+			Image:    payload[4+metadataLength:],
+			PromptID: metadata.PromptID,
+			NodeID:   metadata.NodeID,
+		}
+		return &WSMessage{Type: PreviewImage, Data: preview}, nil
+	case binaryText:
+		if len(payload) < 4 {
+			return nil, errors.New("text message too short")
+		}
+		nodeIDLength := int(binary.BigEndian.Uint32(payload[:4]))
+		if len(payload) < 4+nodeIDLength {
+			return nil, errors.New("text message too short")
+		}
+		text := &WSMessageDataProgressText{
+			NodeID: string(payload[4 : 4+nodeIDLength]),
+			Text:   string(payload[4+nodeIDLength:]),
+		}
+		return &WSMessage{Type: ProgressText, Data: text}, nil
+	}
+	// unencoded previews and unknown events are passed on as they are
+	return &WSMessage{Type: Binary, Data: b}, nil
+}
+
+func decodePreviewImage(payload []byte) (*WSMessageDataPreviewImage, error) {
+	if len(payload) < 4 {
+		return nil, errors.New("preview image too short")
+	}
+	format := "image/jpeg"
+	if binary.BigEndian.Uint32(payload[:4]) == 2 {
+		format = "image/png"
+	}
+	return &WSMessageDataPreviewImage{Format: format, Image: payload[4:]}, nil
+}
+
 //	WSMessageDataStatus
 //
 // Json {"type": "status", "data": {"status": {"exec_info": {"queue_remaining": 1}}}}
@@ -158,23 +456,28 @@ type WSMessageDataStatus struct {
 }
 
 // WSMessageDataExecutionStart
-// Json {"type": "execution_start", "data": {"prompt_id": "ed986d60-2a27-4d28-8871-2fdb36582902"}}
+// Json {"type": "execution_start", "data": {"prompt_id": "ed986d60-2a27-4d28-8871-2fdb36582902", "timestamp": 1718000000000}}
+// timestamp is in milliseconds and only sent by newer servers
 type WSMessageDataExecutionStart struct {
-	PromptID string `json:"prompt_id"`
+	PromptID  string `json:"prompt_id"`
+	Timestamp int64  `json:"timestamp"`
 }
 
 // WSMessageDataExecutionCached
-// json {"type": "execution_cached", "data": {"nodes": [], "prompt_id": "ed986d60-2a27-4d28-8871-2fdb36582902"}}
+// json {"type": "execution_cached", "data": {"nodes": [], "prompt_id": "ed986d60-2a27-4d28-8871-2fdb36582902", "timestamp": 1718000000000}}
 type WSMessageDataExecutionCached struct {
-	Nodes    []string `json:"nodes"`
-	PromptID string   `json:"prompt_id"`
+	Nodes     []string `json:"nodes"`
+	PromptID  string   `json:"prompt_id"`
+	Timestamp int64    `json:"timestamp"`
 }
 
 // WSMessageDataExecuting
-// json {"type": "executing", "data": {"node": "12", "prompt_id": "ed986d60-2a27-4d28-8871-2fdb36582902"}}
+// json {"type": "executing", "data": {"node": "12", "display_node": "12", "prompt_id": "ed986d60-2a27-4d28-8871-2fdb36582902"}}
+// node is null when the prompt finished
 type WSMessageDataExecuting struct {
-	Node     string `json:"node"`
-	PromptID string `json:"prompt_id"`
+	Node        string `json:"node"`
+	DisplayNode string `json:"display_node"`
+	PromptID    string `json:"prompt_id"`
 }
 
 // WSMessageDataProgress
@@ -183,13 +486,18 @@ type WSMessageDataExecuting struct {
   "type": "progress",
   "data": {
     "value": 18,
-    "max": 20
+    "max": 20,
+    "prompt_id": "ed986d60-2a27-4d28-8871-2fdb36582902",
+    "node": "3"
   }
 }
 */
+// prompt_id and node are only sent by newer servers
 type WSMessageDataProgress struct {
-	Value int `json:"value"`
-	Max   int `json:"max"`
+	Value    int    `json:"value"`
+	Max      int    `json:"max"`
+	PromptID string `json:"prompt_id"`
+	Node     string `json:"node"`
 }
 
 //
@@ -205,6 +513,51 @@ type WSMessageDataExecuted struct {
 	Node     string `json:"node"`
 	PromptID string `json:"prompt_id"`
 	Output   map[string][]*DataOutputFile
+	// ExtraOutput holds outputs that aren't files, e.g. {"animated": [true]} or {"text": ["..."]}
+	ExtraOutput map[string]json.RawMessage
+}
+
+func (e *WSMessageDataExecuted) UnmarshalJSON(b []byte) error {
+	var temp struct {
+		Node     string                     `json:"node"`
+		PromptID string                     `json:"prompt_id"`
+		Output   map[string]json.RawMessage `json:"output"`
+	}
+	if err := json.Unmarshal(b, &temp); err != nil {
+		return err
+	}
+
+	e.Node = temp.Node
+	e.PromptID = temp.PromptID
+	e.Output, e.ExtraOutput = splitOutput(temp.Output)
+	return nil
+}
+
+// splitOutput separates file lists from other node outputs
+func splitOutput(output map[string]json.RawMessage) (map[string][]*DataOutputFile, map[string]json.RawMessage) {
+	files := make(map[string][]*DataOutputFile, len(output))
+	var extra map[string]json.RawMessage
+	for key, value := range output {
+		var list []*DataOutputFile
+		if err := json.Unmarshal(value, &list); err == nil && isFileList(list) {
+			files[key] = list
+			continue
+		}
+		if extra == nil {
+			extra = make(map[string]json.RawMessage)
+		}
+		extra[key] = value
+	}
+	return files, extra
+}
+
+func isFileList(list []*DataOutputFile) bool {
+	for _, file := range list {
+		if file == nil || file.Filename == "" {
+			return false
+		}
+	}
+	return true
 }
 
 // WSMessageExecutionInterrupted
@@ -212,10 +565,11 @@ type WSMessageDataExecuted struct {
 {"type": "execution_interrupted", "data": {"prompt_id": "dc7093d7-980a-4fe6-bf0c-f6fef932c74b", "node_id": "19", "node_type": "SaveImage", "executed": ["5", "17", "10", "11"]}}
 */
 type WSMessageExecutionInterrupted struct {
-	PromptID string   `json:"prompt_id"`
-	NodeID   string   `json:"node_id"`
-	NodeType string   `json:"node_type"`
-	Executed []string `json:"executed"`
+	PromptID  string   `json:"prompt_id"`
+	NodeID    string   `json:"node_id"`
+	NodeType  string   `json:"node_type"`
+	Executed  []string `json:"executed"`
+	Timestamp int64    `json:"timestamp"`
 }
 
 type WSMessageExecutionError struct {
@@ -228,4 +582,51 @@ type WSMessageExecutionError struct {
 	Traceback        []string               `json:"traceback"`
 	CurrentInputs    map[string]interface{} `json:"current_inputs"`
 	CurrentOutputs   map[int]interface{}    `json:"current_outputs"`
+	Timestamp        int64                  `json:"timestamp"`
+}
+
+// WSMessageDataExecutionSuccess is sent by newer servers when a prompt finished without error
+/*
+{"type": "execution_success", "data": {"prompt_id": "ed986d60-2a27-4d28-8871-2fdb36582902", "timestamp": 1718000000000}}
+*/
+type WSMessageDataExecutionSuccess struct {
+	PromptID  string `json:"prompt_id"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// WSMessageDataProgressState is sent by newer servers with the progress of every running node
+/*
+{"type": "progress_state", "data": {"prompt_id": "ed986d60-2a27-4d28-8871-2fdb36582902", "nodes": {"3": {"value": 4, "max": 20, "state": "running", "node_id": "3", "prompt_id": "ed986d60-2a27-4d28-8871-2fdb36582902", "display_node_id": "3", "parent_node_id": null, "real_node_id": "3"}}}}
+*/
+type WSMessageDataProgressState struct {
+	PromptID string                        `json:"prompt_id"`
+	Nodes    map[string]*NodeProgressState `json:"nodes"`
+}
+
+// NodeProgressState is the progress of one node in WSMessageDataProgressState
+type NodeProgressState struct {
+	Value         float64 `json:"value"`
+	Max           float64 `json:"max"`
+	State         string  `json:"state"`
+	NodeID        string  `json:"node_id"`
+	PromptID      string  `json:"prompt_id"`
+	DisplayNodeID string  `json:"display_node_id"`
+	ParentNodeID  string  `json:"parent_node_id"`
+	RealNodeID    string  `json:"real_node_id"`
+}
+
+// WSMessageDataPreviewImage is a preview image sent as a binary websocket frame, e.g. while sampling
+// PromptID and NodeID are only known when the server sends preview metadata
+type WSMessageDataPreviewImage struct {
+	// Format is the mime type of Image, image/jpeg or image/png
+	Format   string
+	Image    []byte
+	PromptID string
+	NodeID   string
+}
+
+// WSMessageDataProgressText is a text sent by a node as a binary websocket frame
+type WSMessageDataProgressText struct {
+	NodeID string
+	Text   string
 }
@@ -0,0 +1,150 @@
+package comfyUIclient_test
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/XdpCs/comfyUIclient"
+	"github.com/XdpCs/comfyUIclient/comfytest"
+)
+
+func TestRegisteredStatusDecoderDoesntPanic(t *testing.T) {
+	comfyUIclient.RegisterWSMessageType(comfyUIclient.Status, func() interface{} { return &map[string]interface{}{} })
+	defer comfyUIclient.RegisterWSMessageType(comfyUIclient.Status, func() interface{} { return &comfyUIclient.WSMessageDataStatus{} })
+
+	client, err := comfyUIclient.New("http://127.0.0.1:8188", comfyUIclient.WithEventBufferSize(1))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	received := make(chan *comfyUIclient.WSMessage, 1)
+	client.AddListener(func(message *comfyUIclient.WSMessage) { received <- message })
+	if err := client.Handle(`{"type": "status", "data": {"status": {"exec_info": {"queue_remaining": 2}}}}`); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+	select {
+	case message := <-received:
+		if _, ok := message.Data.(*map[string]interface{}); !ok {
+			t.Fatalf("listener got %T, want the registered type", message.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("status message wasn't delivered")
+	}
+}
+
+func TestBinaryFramesAreListenerOnly(t *testing.T) {
+	client, err := comfyUIclient.New("http://127.0.0.1:8188", comfyUIclient.WithEventBufferSize(1))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	var previews atomic.Int32
+	client.AddListener(func(message *comfyUIclient.WSMessage) {
+		if message.Type == comfyUIclient.PreviewImage {
+			previews.Add(1)
+		}
+	})
+
+	frame := []byte{0, 0, 0, 1, 0, 0, 0, 2, 0x89, 'P', 'N', 'G'}
+	for i := 0; i < 3; i++ {
+		if err := client.HandleBinary(frame); err != nil {
+			t.Fatalf("HandleBinary: %v", err)
+		}
+	}
+	waitFor(t, 5*time.Second, "previews", func() bool { return previews.Load() == 3 })
+	if n := len(client.GetTaskStatus()); n != 0 {
+		t.Fatalf("task status channel has %d messages, want 0", n)
+	}
+	if dropped := client.DroppedEvents(); dropped != 0 {
+		t.Fatalf("DroppedEvents = %d, want 0", dropped)
+	}
+}
+
+// trackingDialer counts the connections it opened that weren't closed yet
+type trackingDialer struct {
+	next comfyUIclient.WebSocketDialer
+	open atomic.Int32
+	last atomic.Value
+}
+
+type trackedConn struct {
+	comfyUIclient.WebSocketConn
+	dialer *trackingDialer
+	closed atomic.Bool
+}
+
+func (d *trackingDialer) Dial(url string, header http.Header) (comfyUIclient.WebSocketConn, *http.Response, error) {
+	conn, resp, err := d.next.Dial(url, header)
+	if err != nil {
+		return nil, resp, err
+	}
+	d.open.Add(1)
+	tracked := &trackedConn{WebSocketConn: conn, dialer: d}
+	d.last.Store(tracked)
+	return tracked, resp, nil
+}
+
+func (c *trackedConn) Close() error {
+	if !c.closed.Swap(true) {
+		c.dialer.open.Add(-1)
+	}
+	return c.WebSocketConn.Close()
+}
+
+func TestCloseDuringReconnect(t *testing.T) {
+	dialer := &trackingDialer{}
+	server, client := newTestServer(t,
+		comfyUIclient.WithReconnectPolicy(comfyUIclient.ReconnectPolicy{MaxRetry: 3, Interval: 10 * time.Millisecond}),
+		comfyUIclient.WithWebSocketTransport(func(next comfyUIclient.WebSocketDialer) comfyUIclient.WebSocketDialer {
+			dialer.next = next
+			return dialer
+		}),
+	)
+	// the reconnect is still dialing when the client is closed
+	server.AddFault(&comfytest.Fault{Path: "/ws", Delay: 300 * time.Millisecond, Times: 1})
+	dialer.last.Load().(*trackedConn).Close()
+	waitFor(t, 5*time.Second, "disconnect", func() bool { return !client.IsInitialized() })
+	time.Sleep(100 * time.Millisecond)
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	waitFor(t, 5*time.Second, "connections to be closed", func() bool { return dialer.open.Load() == 0 })
+	time.Sleep(500 * time.Millisecond)
+	if open := dialer.open.Load(); open != 0 {
+		t.Fatalf("%d connections are open after Close", open)
+	}
+}
+
+// warnLogger records the messages logged at Warn and Error
+type warnLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *warnLogger) Debug(msg string, args ...any) {}
+func (l *warnLogger) Info(msg string, args ...any)  {}
+func (l *warnLogger) Warn(msg string, args ...any)  { l.record(msg) }
+func (l *warnLogger) Error(msg string, args ...any) { l.record(msg) }
+
+func (l *warnLogger) record(msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, msg)
+}
+
+func TestCloseDoesntWarn(t *testing.T) {
+	logger := &warnLogger{}
+	_, client := newTestServer(t, comfyUIclient.WithLogger(logger))
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	waitFor(t, 5*time.Second, "disconnect", func() bool { return !client.IsInitialized() })
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if len(logger.messages) != 0 {
+		t.Fatalf("Close logged %v", logger.messages)
+	}
+}